@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Abstract nodes are non-file dependencies, named like URLs (e.g. `env://DATABASE_URL`,
+// `service://auth-api`, `pkg://numpy==1.26`). Rules add edges to them with `visit_abstract_nodes`.
+// They have no dependencies of their own, and are hashed by their value:
+//   - The `command`, `env` or `value` configured for them in `abstract_nodes`, if any
+//   - The environment variable, for `env://NAME`
+//   - Otherwise their name, for names that already identify a version (like `pkg://numpy==1.26`)
+func isAbstractNode(path string) bool {
+	return strings.Contains(path, "://")
+}
+
+// The value an abstract node is hashed by
+func abstractNodeValue(name string, config *Config, base_dir string) ([]byte, error) {
+	if input, ok := config.AbstractNodes[name]; ok {
+		return input.capture(base_dir)
+	}
+	if env_name, ok := strings.CutPrefix(name, "env://"); ok {
+		return []byte(os.Getenv(env_name)), nil
+	}
+	return []byte(name), nil
+}
+
+func validateAbstractNodes(config *Config) error {
+	for name, input := range config.AbstractNodes {
+		if !isAbstractNode(name) {
+			return fmt.Errorf("abstract node '%s' must be named like a URL (e.g. 'service://%s')", name, name)
+		}
+		if err := input.validateSource(); err != nil {
+			return fmt.Errorf("abstract node '%s': %v", name, err)
+		}
+		if len(input.Targets.items) != 0 {
+			return fmt.Errorf("abstract node '%s' can't have targets, rules choose which files depend on it", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Categories of affected inputs, from the most specific
+const (
+	AFFECTED_DIRECT     = "direct"
+	AFFECTED_TRANSITIVE = "transitive"
+	AFFECTED_GLOBAL_DEP = "global_dep"
+	AFFECTED_CONFIG     = "config"
+)
+
+// The config file and the configs it includes, relative to the repo root. Remote configs and
+// configs outside the repo can't be in the changed files, so they're skipped.
+func configFilesInRepo(config_path string, config *Config, base_dir string) []string {
+	config_files := []string{config_path}
+	for file, includes := range config.include_graph {
+		config_files = append(config_files, file)
+		config_files = append(config_files, includes...)
+	}
+	paths := []string{}
+	for _, config_file := range config_files {
+		if isRemoteConfig(config_file) {
+			continue
+		}
+		path, err := filepath.Rel(base_dir, config_file)
+		if err == nil && !strings.HasPrefix(path, "..") {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Returns why each input is affected by the changed files, only for affected inputs.
+// An input is affected directly if it changed itself, transitively if a change reaches it through
+// a dependency created by a rule, through a global dep if only `global_deps` edges lead to a change,
+// and by the config if the config (or an included one) changed and nothing else reaches it.
+func CalculateAffected(
+	file_relation_map map[string][]string,
+	edge_origins EdgeOrigins,
+	input_files []string,
+	changed_files []string,
+	config_path string,
+	config *Config,
+	base_dir string,
+) schema.Affected {
+	changed := map[string]bool{}
+	for _, file := range changed_files {
+		changed[filepath.Clean(file)] = true
+	}
+	config_changed := false
+	for _, config_file := range configFilesInRepo(config_path, config, base_dir) {
+		config_changed = config_changed || changed[config_file]
+	}
+
+	// Files that (recursively) depend on a changed file. Every visited file depends on the global
+	// deps, so they're only followed from the inputs themselves.
+	onlyGlobal := func(edge Edge) bool {
+		origins := edge_origins[edge]
+		only_global := len(origins) != 0
+		for _, origin := range origins {
+			only_global = only_global && origin.Type == EDGE_TYPE_GLOBAL
+		}
+		return only_global
+	}
+	reverse_relations := map[string][]string{}
+	for file, deps := range file_relation_map {
+		for _, dep := range deps {
+			if !onlyGlobal(Edge{From: file, To: dep}) {
+				reverse_relations[dep] = append(reverse_relations[dep], file)
+			}
+		}
+	}
+	reaches_change := map[string]bool{}
+	queue := []string{}
+	for file := range changed {
+		queue = append(queue, file)
+	}
+	for len(queue) != 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if reaches_change[file] {
+			continue
+		}
+		reaches_change[file] = true
+		queue = append(queue, reverse_relations[file]...)
+	}
+
+	// The changed files among the recursive dependencies of a file
+	changedDepsOf := func(file string) []string {
+		changed_deps := []string{}
+		for _, dep := range BuildFilteredDepList(file_relation_map, file, func(edge Edge) bool {
+			return reaches_change[edge.To] && !onlyGlobal(edge)
+		}) {
+			if changed[dep] {
+				changed_deps = append(changed_deps, dep)
+			}
+		}
+		return changed_deps
+	}
+
+	affected := schema.Affected{}
+	for _, input := range input_files {
+		if changed[input] {
+			affected[input] = schema.AffectedTarget{Category: AFFECTED_DIRECT, Changed: []string{input}}
+			continue
+		}
+		var transitive, global *schema.AffectedTarget
+		deps := slices.Clone(file_relation_map[input])
+		slices.Sort(deps)
+		for _, dep := range deps {
+			if !reaches_change[dep] {
+				continue
+			}
+			only_global := onlyGlobal(Edge{From: input, To: dep})
+			if !only_global && transitive == nil {
+				transitive = &schema.AffectedTarget{Category: AFFECTED_TRANSITIVE, Via: dep, Changed: changedDepsOf(dep)}
+			} else if only_global && global == nil {
+				global = &schema.AffectedTarget{Category: AFFECTED_GLOBAL_DEP, Via: dep, Changed: changedDepsOf(dep)}
+			}
+		}
+		if transitive != nil {
+			affected[input] = *transitive
+		} else if global != nil {
+			affected[input] = *global
+		} else if config_changed {
+			affected[input] = schema.AffectedTarget{Category: AFFECTED_CONFIG}
+		}
+	}
+	return affected
+}
+
+// The files in the graph matching a (valid) glob, sorted
+func SimulatedChangedFiles(file_relation_map map[string][]string, pattern string) []string {
+	matches := []string{}
+	for file := range file_relation_map {
+		if match, _ := doublestar.Match(pattern, file); match {
+			matches = append(matches, file)
+		}
+	}
+	slices.Sort(matches)
+	return matches
+}
+
+// Prints the affected inputs, sorted
+func PrintAffected(affected schema.Affected) {
+	inputs := make([]string, 0, len(affected))
+	for input := range affected {
+		inputs = append(inputs, input)
+	}
+	slices.Sort(inputs)
+	log.Println("category\tinput\tvia")
+	for _, input := range inputs {
+		log.Printf("%s\t%s\t%s", affected[input].Category, input, affected[input].Via)
+	}
+}
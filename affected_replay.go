@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Reads an output recorded by a run manifest, checking it's the one the run wrote. A chunked
+// output (see `-max-output-bytes`) is read from its chunks, each checked the same way.
+func readManifestArtifact(manifest *schema.RunManifest, flag_name string, path string, value any) error {
+	data, err := readRecordedArtifact(manifest, flag_name, path)
+	if err != nil {
+		return err
+	}
+	index := schema.ChunkedOutput{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) && json.Unmarshal(data, &index) == nil && index.Chunked {
+		for _, chunk := range index.Chunks {
+			chunk_path := filepath.Join(filepath.Dir(path), chunk)
+			chunk_data, err := readRecordedArtifact(manifest, flag_name, chunk_path)
+			if err != nil {
+				return err
+			}
+			// Objects are split by keys, so decoding each chunk into the value merges them
+			if !bytes.HasPrefix(bytes.TrimSpace(chunk_data), []byte("{")) {
+				return fmt.Errorf("chunk '%s' of the -%s output isn't an object, which can't be merged", chunk_path, flag_name)
+			}
+			if err := json.Unmarshal(chunk_data, value); err != nil {
+				return fmt.Errorf("failed to decode chunk '%s': %w", chunk_path, err)
+			}
+		}
+		return nil
+	}
+	return json.Unmarshal(data, value)
+}
+
+// Reads a file, checking it's one of the outputs of a flag recorded by a run manifest
+func readRecordedArtifact(manifest *schema.RunManifest, flag_name string, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256(data))
+	recorded := false
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Flag != flag_name {
+			continue
+		}
+		recorded = true
+		if artifact.SHA256 == digest {
+			return data, nil
+		}
+	}
+	if !recorded {
+		return nil, fmt.Errorf("the run manifest has no -%s output", flag_name)
+	}
+	return nil, fmt.Errorf("'%s' isn't the -%s output recorded by the run manifest (SHA-256 %s)", path, flag_name, digest)
+}
+
+// Returns why each input of the head run is affected, from the recorded outputs of two runs alone:
+// the files whose hashes differ (or that exist in only one of the runs) are the changed files, and
+// the head run's graph snapshot gives the recursive dependencies of each input. Without the graph
+// itself, inputs affected through a dependency are "transitive" with no `via`, and global deps
+// aren't told apart from other dependencies.
+func ReplayAffected(
+	base_manifest *schema.RunManifest,
+	base_file_hashes schema.FileHashes,
+	head_manifest *schema.RunManifest,
+	head_file_hashes schema.FileHashes,
+	head_snapshot *schema.GraphSnapshot,
+) schema.Affected {
+	changed := map[string]bool{}
+	for file, hash := range head_file_hashes {
+		if base_hash, ok := base_file_hashes[file]; !ok || base_hash != hash {
+			changed[file] = true
+		}
+	}
+	for file := range base_file_hashes {
+		if _, ok := head_file_hashes[file]; !ok {
+			changed[file] = true
+		}
+	}
+	config_changed := base_manifest.ConfigHash != head_manifest.ConfigHash
+
+	affected := schema.Affected{}
+	for _, input := range head_manifest.Inputs {
+		if changed[input] {
+			affected[input] = schema.AffectedTarget{Category: AFFECTED_DIRECT, Changed: []string{input}}
+			continue
+		}
+		changed_deps := []string{}
+		for _, dep := range head_snapshot.Targets[input].Deps {
+			if changed[dep] {
+				changed_deps = append(changed_deps, dep)
+			}
+		}
+		if len(changed_deps) != 0 {
+			slices.Sort(changed_deps)
+			affected[input] = schema.AffectedTarget{Category: AFFECTED_TRANSITIVE, Changed: changed_deps}
+		} else if config_changed {
+			affected[input] = schema.AffectedTarget{Category: AFFECTED_CONFIG}
+		}
+	}
+	return affected
+}
+
+// The `replay-affected` command: answers which inputs changed between two builds from their
+// recorded outputs, without a checkout of the repo
+func runReplayAffected(command_args []string) {
+	flags := flag.NewFlagSet("replay-affected", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay-affected -base-manifest run1.json -base-file-hashes file_hashes1.json -head-manifest run2.json -head-file-hashes file_hashes2.json -head-graph-snapshot snapshot2.json\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	base_manifest_path := flags.String("base-manifest", "", "Path of the '-out-run-manifest' output of the earlier run")
+	base_file_hashes_path := flags.String("base-file-hashes", "", "Path of the '-out-file-hashes' output of the earlier run")
+	head_manifest_path := flags.String("head-manifest", "", "Path of the '-out-run-manifest' output of the later run")
+	head_file_hashes_path := flags.String("head-file-hashes", "", "Path of the '-out-file-hashes' output of the later run")
+	head_snapshot_path := flags.String("head-graph-snapshot", "", "Path of the '-out-graph-snapshot' output of the later run")
+	out_affected := flags.String("out-affected", STDOUT_OUTPUT, "Output the affected inputs of the later run (like '-out-affected') to the specified file")
+	flags.Parse(command_args)
+	if *base_manifest_path == "" || *base_file_hashes_path == "" || *head_manifest_path == "" ||
+		*head_file_hashes_path == "" || *head_snapshot_path == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	base_manifest := schema.RunManifest{}
+	if err := readJsonFile(*base_manifest_path, &base_manifest); err != nil {
+		log.Fatalf("error while reading base run manifest: %v\n", err)
+	}
+	head_manifest := schema.RunManifest{}
+	if err := readJsonFile(*head_manifest_path, &head_manifest); err != nil {
+		log.Fatalf("error while reading head run manifest: %v\n", err)
+	}
+	base_file_hashes := schema.FileHashes{}
+	if err := readManifestArtifact(&base_manifest, "out-file-hashes", *base_file_hashes_path, &base_file_hashes); err != nil {
+		log.Fatalf("error while reading base file hashes: %v\n", err)
+	}
+	head_file_hashes := schema.FileHashes{}
+	if err := readManifestArtifact(&head_manifest, "out-file-hashes", *head_file_hashes_path, &head_file_hashes); err != nil {
+		log.Fatalf("error while reading head file hashes: %v\n", err)
+	}
+	head_snapshot := schema.GraphSnapshot{}
+	if err := readManifestArtifact(&head_manifest, "out-graph-snapshot", *head_snapshot_path, &head_snapshot); err != nil {
+		log.Fatalf("error while reading head graph snapshot: %v\n", err)
+	}
+
+	affected := ReplayAffected(&base_manifest, base_file_hashes, &head_manifest, head_file_hashes, &head_snapshot)
+	log.Printf("%d of %d inputs affected\n", len(affected), len(head_manifest.Inputs))
+	writeJsonOutput(&Args{}, "out-affected", *out_affected, affected)
+}
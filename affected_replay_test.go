@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+func TestReadManifestArtifactChunks(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{
+		"hashes.json":     `{"chunked": true, "chunks": ["hashes.000.json", "hashes.001.json"]}`,
+		"hashes.000.json": `{"a.py": "aa", "b.py": "bb"}`,
+		"hashes.001.json": `{"c.py": "cc"}`,
+		"array.json":      `{"chunked": true, "chunks": ["array.000.json"]}`,
+		"array.000.json":  `["a.py"]`,
+	})
+	manifest := schema.RunManifest{}
+	record := func(name string) {
+		data, err := os.ReadFile(filepath.Join(base_dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, schema.RunArtifact{
+			Flag: "out-file-hashes", Path: name, SHA256: fmt.Sprintf("%x", sha256.Sum256(data)),
+		})
+	}
+	for _, name := range []string{"hashes.json", "hashes.000.json", "hashes.001.json", "array.json", "array.000.json"} {
+		record(name)
+	}
+
+	hashes := schema.FileHashes{}
+	if err := readManifestArtifact(&manifest, "out-file-hashes", filepath.Join(base_dir, "hashes.json"), &hashes); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (schema.FileHashes{"a.py": "aa", "b.py": "bb", "c.py": "cc"}); !maps.Equal(hashes, expected) {
+		t.Errorf("read %v, expected %v", hashes, expected)
+	}
+
+	if err := readManifestArtifact(&manifest, "out-file-hashes", filepath.Join(base_dir, "array.json"), &[]string{}); err == nil {
+		t.Error("expected an error for chunks that aren't objects")
+	}
+
+	// A chunk that isn't the recorded one
+	if err := os.WriteFile(filepath.Join(base_dir, "hashes.001.json"), []byte(`{"c.py": "00"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := readManifestArtifact(&manifest, "out-file-hashes", filepath.Join(base_dir, "hashes.json"), &schema.FileHashes{}); err == nil {
+		t.Error("expected an error for a chunk that doesn't match the manifest")
+	}
+}
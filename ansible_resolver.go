@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// Keys whose value is a file relative to the including one (or a `file:` mapping)
+var ansible_file_keys = map[string]bool{
+	"import_playbook": true, "include_tasks": true, "import_tasks": true, "include_vars": true,
+	"include": true, "vars_files": true,
+}
+
+// Keys whose value is a role (or a list of roles)
+var ansible_role_keys = map[string]bool{
+	"roles": true, "role": true, "include_role": true, "import_role": true, "dependencies": true,
+}
+
+// Resolves Ansible playbooks, task files and role metadata to the files of their `import_playbook`,
+// `include_tasks`/`import_tasks`, `include_vars` and `vars_files` (relative to the file), and to
+// every file of the roles they use (`roles:`, `include_role`/`import_role` and role
+// `dependencies`). Roles are searched in the `roles/` dir next to the file, in the `roles/` dir
+// the file itself is in, then in `ansible_roles_paths`. Templated paths (`{{ ... }}`) are ignored.
+type AnsibleResolver struct{}
+
+type ansibleReferences struct {
+	files []string
+	roles []string
+}
+
+func (refs *ansibleReferences) addFiles(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		refs.files = append(refs.files, node.Value)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			refs.addFiles(item)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "file" {
+				refs.addFiles(node.Content[i+1])
+			}
+		}
+	}
+}
+
+func (refs *ansibleReferences) addRoles(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		refs.roles = append(refs.roles, node.Value)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			refs.addRoles(item)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; key == "role" || key == "name" {
+				refs.addRoles(node.Content[i+1])
+			}
+		}
+	}
+}
+
+// Collects the referenced files and roles anywhere in a document
+func (refs *ansibleReferences) collect(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := strings.TrimPrefix(strings.TrimPrefix(node.Content[i].Value, "ansible.builtin."), "ansible.legacy.")
+			if ansible_file_keys[key] {
+				refs.addFiles(node.Content[i+1])
+			} else if ansible_role_keys[key] {
+				refs.addRoles(node.Content[i+1])
+			}
+		}
+	}
+	for _, child := range node.Content {
+		refs.collect(child)
+	}
+}
+
+// Returns the repo-relative dir of a role, if found
+func findAnsibleRole(file string, role string, config *Config, base_dir string) (string, bool) {
+	candidates := []string{filepath.Join(filepath.Dir(file), "roles", role)}
+	// Files inside a role (e.g. its `meta/main.yml`) find their sibling roles
+	for dir := filepath.Dir(file); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == "roles" {
+			candidates = append(candidates, filepath.Join(dir, role))
+			break
+		}
+	}
+	for _, roles_path := range config.AnsibleRolesPaths.items {
+		candidates = append(candidates, filepath.Join(roles_path, role))
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, "..") {
+			continue
+		}
+		if dirExists(config, filepath.Join(base_dir, candidate)) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func (res *AnsibleResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	document := yaml.Node{}
+	if err := yaml.Unmarshal([]byte(file_data), &document); err != nil {
+		return nil, fmt.Errorf("error while parsing ansible file: %v", err)
+	}
+	refs := ansibleReferences{}
+	refs.collect(&document)
+
+	paths := []string{}
+	for _, ref := range refs.files {
+		if strings.Contains(ref, "{{") {
+			continue
+		}
+		path := filepath.Join(filepath.Dir(file), ref)
+		if path != file && !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+	for _, role := range refs.roles {
+		if strings.Contains(role, "{{") {
+			continue
+		}
+		role_dir, ok := findAnsibleRole(file, role, config, base_dir)
+		if !ok {
+			continue
+		}
+		matches, err := doublestar.Glob(
+			config.repoFS(filepath.Join(base_dir, role_dir)), "**", doublestar.WithFilesOnly(), doublestar.WithFailOnIOErrors(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error while globbing role '%s': %v", role, err)
+		}
+		for _, match := range matches {
+			if path := filepath.Join(role_dir, match); path != file {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, nil
+}
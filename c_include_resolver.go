@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var c_include_parser = regexp.MustCompile(`(?m:^[ \t]*#[ \t]*include[ \t]*(?:"([^"\n]+)"|<([^>\n]+)>))`)
+
+// Resolves `#include` directives of C/C++ files to files in the repo.
+// `#include "..."` is searched relative to the file first, then in `c_include_dirs`, and
+// `#include <...>` only in `c_include_dirs`. Includes that aren't found (e.g. system headers)
+// are ignored. Headers are visited like any other file, so rules should match them too to
+// follow transitive includes.
+type CIncludeResolver struct {
+	cache map[string]string
+}
+
+func (res *CIncludeResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.cache == nil {
+		res.cache = map[string]string{}
+	}
+	paths := []string{}
+	for _, match := range c_include_parser.FindAllStringSubmatch(file_data, -1) {
+		if match[1] != "" {
+			relative := filepath.Join(filepath.Dir(file), match[1])
+			if !strings.HasPrefix(relative, "..") && fileExists(config, filepath.Join(base_dir, relative)) {
+				paths = append(paths, relative)
+				continue
+			}
+			if path := res.resolveInIncludeDirs(match[1], config, base_dir); path != "" {
+				paths = append(paths, path)
+			}
+		} else if path := res.resolveInIncludeDirs(match[2], config, base_dir); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// Returns the first match of the include in the include dirs, or "" if there's none
+func (res *CIncludeResolver) resolveInIncludeDirs(include string, config *Config, base_dir string) string {
+	if path, ok := res.cache[include]; ok {
+		return path
+	}
+	path := ""
+	for _, include_dir := range config.CIncludeDirs.items {
+		candidate := filepath.Join(include_dir, include)
+		if fileExists(config, filepath.Join(base_dir, candidate)) {
+			path = candidate
+			break
+		}
+	}
+	res.cache[include] = path
+	return path
+}
@@ -0,0 +1,137 @@
+"""Read the artifacts exported by repo_dagger, and traverse its dependency graph.
+
+The formats are defined in the `pkg/schema` Go package. This client supports schema version 1,
+and like the schema promises, ignores fields it doesn't know.
+"""
+
+import json
+import posixpath
+from pathlib import Path
+from typing import Dict, Iterable, List, Optional, Set, Union
+
+SCHEMA_VERSION = 1
+
+_JS_EXTENSIONS = (".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".mts", ".cts")
+
+PathLike = Union[str, Path]
+
+
+def _load_json(path: PathLike):
+    with open(path, "r", encoding="utf-8") as f:
+        data = json.load(f)
+    # Outputs larger than `-max-output-bytes` are split into chunks, with an index in their place
+    if isinstance(data, dict) and data.get("chunked") is True:
+        chunks = [_load_json(Path(path).parent / chunk) for chunk in data["chunks"]]
+        if chunks and isinstance(chunks[0], list):
+            return [item for chunk in chunks for item in chunk]
+        merged = {}
+        for chunk in chunks:
+            merged.update(chunk)
+        return merged
+    return data
+
+
+def load_dep_hashes(path: PathLike) -> Dict[str, str]:
+    """Load `-out-dep-hashes`: input file -> dependency hash."""
+    return _load_json(path)
+
+
+def load_env_dep_hashes(path: PathLike) -> Dict[str, Dict[str, str]]:
+    """Load `-out-env-dep-hashes`: hash environment -> input file -> dependency hash."""
+    return _load_json(path)
+
+
+def load_file_hashes(path: PathLike) -> Dict[str, str]:
+    """Load `-out-file-hashes`: file -> hash of its contents."""
+    return _load_json(path)
+
+
+def load_recursive_deps(path: PathLike) -> List[str]:
+    """Load `-out-recursive-deps`: the recursive dependencies of a single input file."""
+    return _load_json(path)
+
+
+class Graph:
+    """The file dependency graph, as written by `-out-relations`."""
+
+    def __init__(self, relations: Dict[str, Optional[List[str]]]):
+        self._deps: Dict[str, List[str]] = {
+            file: list(deps or []) for file, deps in relations.items()
+        }
+        self._rdeps: Dict[str, List[str]] = {}
+        for file, deps in self._deps.items():
+            for dep in deps:
+                self._rdeps.setdefault(dep, []).append(file)
+        for rdeps in self._rdeps.values():
+            rdeps.sort()
+
+    @classmethod
+    def load(cls, relations_path: PathLike) -> "Graph":
+        return cls(_load_json(relations_path))
+
+    @property
+    def files(self) -> List[str]:
+        """All files in the graph, sorted."""
+        return sorted(self._deps)
+
+    def deps(self, file: str) -> List[str]:
+        """The direct dependencies of a file."""
+        return list(self._deps.get(file, []))
+
+    def rdeps(self, file: str) -> List[str]:
+        """The files directly depending on a file."""
+        return list(self._rdeps.get(file, []))
+
+    def recursive_deps(self, file: str) -> List[str]:
+        """The recursive dependencies of a file, including itself (like `-out-recursive-deps`)."""
+        return sorted(self._reachable([file], self._deps))
+
+    def recursive_rdeps(self, files: Iterable[str]) -> List[str]:
+        """All files recursively depending on any of the files, including them."""
+        return sorted(self._reachable(files, self._rdeps))
+
+    def affected(self, changed_files: Iterable[str], inputs: Optional[Iterable[str]] = None) -> List[str]:
+        """The inputs affected by changes to the given files.
+
+        If `inputs` isn't given, every affected file in the graph is returned.
+        """
+        affected = self._reachable(changed_files, self._rdeps)
+        if inputs is not None:
+            affected &= set(inputs)
+        return sorted(affected)
+
+    def go_test_packages(self, changed_files: Iterable[str], inputs: Optional[Iterable[str]] = None) -> List[str]:
+        """The Go packages with affected `_test.go` files, as `go test` arguments (e.g. `./pkg/foo`).
+
+        Test files are compiled with the package in their directory, which the graph has no edges
+        for, so a package is also affected through its own affected `.go` files.
+        """
+        test_files = [file for file in (self.files if inputs is None else inputs) if file.endswith("_test.go")]
+        affected = self._reachable(changed_files, self._rdeps)
+        affected_dirs = {posixpath.dirname(file) for file in affected if file.endswith(".go")}
+        packages = {
+            "./" + posixpath.dirname(file) if posixpath.dirname(file) else "."
+            for file in test_files
+            if file in affected or posixpath.dirname(file) in affected_dirs
+        }
+        return sorted(packages)
+
+    def jest_related_files(self, changed_files: Iterable[str], inputs: Optional[Iterable[str]] = None) -> List[str]:
+        """The affected JS/TS files, as `jest --findRelatedTests` arguments.
+
+        Includes files affected through other kinds of files (e.g. a changed JSON or GraphQL file),
+        which Jest wouldn't find on its own.
+        """
+        return [file for file in self.affected(changed_files, inputs) if file.endswith(_JS_EXTENSIONS)]
+
+    @staticmethod
+    def _reachable(start: Iterable[str], edges: Dict[str, List[str]]) -> Set[str]:
+        visited: Set[str] = set()
+        stack = list(start)
+        while stack:
+            file = stack.pop()
+            if file in visited:
+                continue
+            visited.add(file)
+            stack.extend(edges.get(file, []))
+        return visited
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var cmake_command_parser = regexp.MustCompile(`(?i)\b(include|add_subdirectory|target_sources|add_executable|add_library)\s*\(([^)]*)\)`)
+var cmake_comment_parser = regexp.MustCompile(`(?m:#.*$)`)
+var cmake_argument_parser = regexp.MustCompile(`"([^"]*)"|[^\s"]+`)
+
+// Keywords in the argument lists of the supported commands, which aren't paths
+var cmake_keywords = map[string]bool{
+	"OPTIONAL": true, "NO_POLICY_SCOPE": true, "EXCLUDE_FROM_ALL": true, "SYSTEM": true,
+	"PRIVATE": true, "PUBLIC": true, "INTERFACE": true, "FILES": true, "HEADERS": true,
+	"CXX_MODULES": true, "STATIC": true, "SHARED": true, "MODULE": true, "OBJECT": true,
+	"IMPORTED": true, "ALIAS": true, "GLOBAL": true, "WIN32": true, "MACOSX_BUNDLE": true,
+}
+
+// Keywords followed by a value that isn't a source path
+var cmake_keywords_with_value = map[string]bool{
+	"RESULT_VARIABLE": true, "FILE_SET": true, "TYPE": true, "BASE_DIRS": true,
+}
+
+// Resolves the scripts and sources referenced by CMake files: `include()` (relative to the file,
+// or a module in `cmake_module_dirs`), `add_subdirectory()` (the subdirectory's CMakeLists.txt),
+// and the sources listed in `target_sources()`, `add_executable()` and `add_library()`.
+// Arguments using variables other than the source directory ones, or generator expressions, are
+// ignored, as are paths that don't exist.
+type CMakeResolver struct{}
+
+func (res *CMakeResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	dir := filepath.Dir(file)
+	paths := []string{}
+	// Adds the first existing candidate
+	addIfExists := func(candidates ...string) {
+		for _, candidate := range candidates {
+			if !strings.HasPrefix(candidate, "..") && fileExists(config, filepath.Join(base_dir, candidate)) {
+				paths = append(paths, candidate)
+				return
+			}
+		}
+	}
+
+	file_data = cmake_comment_parser.ReplaceAllString(file_data, "")
+	for _, match := range cmake_command_parser.FindAllStringSubmatch(file_data, -1) {
+		command := strings.ToLower(match[1])
+		args := []string{}
+		skip_value := false
+		for _, arg_match := range cmake_argument_parser.FindAllStringSubmatch(match[2], -1) {
+			arg := arg_match[0]
+			if arg_match[1] != "" {
+				arg = arg_match[1]
+			}
+			if skip_value {
+				skip_value = false
+				continue
+			}
+			if cmake_keywords_with_value[arg] {
+				skip_value = true
+				continue
+			}
+			if cmake_keywords[arg] {
+				continue
+			}
+			args = append(args, expandCMakeSourceDirs(arg, dir))
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch command {
+		case "include":
+			if !isPlainCMakePath(args[0]) {
+				continue
+			}
+			candidates := []string{filepath.Join(dir, args[0])}
+			for _, module_dir := range config.CMakeModuleDirs.items {
+				candidates = append(candidates, filepath.Join(module_dir, args[0]+".cmake"))
+			}
+			addIfExists(candidates...)
+		case "add_subdirectory":
+			if isPlainCMakePath(args[0]) {
+				addIfExists(filepath.Join(dir, args[0], "CMakeLists.txt"))
+			}
+		default:
+			// The first argument is the target name
+			for _, source := range args[1:] {
+				if isPlainCMakePath(source) {
+					addIfExists(filepath.Join(dir, source))
+				}
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Replace the source directory variables with paths relative to the current directory, like other
+// paths. The top-level CMakeLists.txt is assumed to be at the root of the repo.
+func expandCMakeSourceDirs(arg string, dir string) string {
+	for _, variable := range []string{"${CMAKE_CURRENT_SOURCE_DIR}", "${CMAKE_CURRENT_LIST_DIR}"} {
+		if rest, ok := strings.CutPrefix(arg, variable); ok {
+			return filepath.Join(".", strings.TrimPrefix(rest, "/"))
+		}
+	}
+	for _, variable := range []string{"${CMAKE_SOURCE_DIR}", "${PROJECT_SOURCE_DIR}"} {
+		if rest, ok := strings.CutPrefix(arg, variable); ok {
+			rel, err := filepath.Rel(dir, filepath.Join(".", strings.TrimPrefix(rest, "/")))
+			if err != nil {
+				return arg
+			}
+			return rel
+		}
+	}
+	return arg
+}
+
+// Returns whether the argument is a relative path without unknown variables or generator expressions
+func isPlainCMakePath(arg string) bool {
+	return !strings.Contains(arg, "${") && !strings.Contains(arg, "$<") && !filepath.IsAbs(arg)
+}
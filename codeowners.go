@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Where GitHub looks for the CODEOWNERS file, in order
+var default_codeowners_paths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type codeownersRule struct {
+	// Patterns matching the files (and the files under the directories) of the rule's pattern
+	patterns []string
+	owners   []string
+}
+
+// The rules of a CODEOWNERS file. The last matching rule decides the owners of a file.
+type Codeowners []codeownersRule
+
+// Converts a CODEOWNERS (gitignore-style) pattern to doublestar patterns
+func codeownersPatterns(pattern string) []string {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !anchored {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return []string{pattern + "**"}
+	}
+	// `docs/*` only matches the files directly in `docs`
+	if strings.HasSuffix(pattern, "/*") {
+		return []string{pattern}
+	}
+	return []string{pattern, pattern + "/**"}
+}
+
+func parseCodeowners(data string) (Codeowners, error) {
+	codeowners := Codeowners{}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := codeownersRule{patterns: codeownersPatterns(strings.ReplaceAll(fields[0], `\#`, "#"))}
+		for _, owner := range fields[1:] {
+			if strings.HasPrefix(owner, "#") {
+				break
+			}
+			rule.owners = append(rule.owners, owner)
+		}
+		for _, pattern := range rule.patterns {
+			if !doublestar.ValidatePattern(pattern) {
+				return nil, fmt.Errorf("invalid pattern '%s' on line %d", fields[0], i+1)
+			}
+		}
+		codeowners = append(codeowners, rule)
+	}
+	return codeowners, nil
+}
+
+// Reads the config's `codeowners` file, or the first CODEOWNERS file where GitHub looks for one
+func LoadCodeowners(config *Config, base_dir string) (Codeowners, error) {
+	path := config.Codeowners
+	if path == "" {
+		for _, candidate := range default_codeowners_paths {
+			if fileExists(config, filepath.Join(base_dir, candidate)) {
+				path = candidate
+				break
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no CODEOWNERS file found, set 'codeowners' in the config")
+		}
+	}
+	data, err := readRepoFile(config, filepath.Join(base_dir, path))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading CODEOWNERS file '%s': %v", path, err)
+	}
+	codeowners, err := parseCodeowners(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing CODEOWNERS file '%s': %v", path, err)
+	}
+	return codeowners, nil
+}
+
+// Returns the owners of a file, empty if it's unowned
+func (codeowners Codeowners) Owners(file string) []string {
+	for i := len(codeowners) - 1; i >= 0; i-- {
+		// These patterns were validated when the file was parsed
+		if matches, _ := checkExcludePatterns(codeowners[i].patterns, file); matches {
+			return codeowners[i].owners
+		}
+	}
+	return nil
+}
+
+// Returns, for each owner, the other owners whose files their files depend on directly, and
+// through how many edges. Edges between files of the same owner, and from or to unowned files
+// (or abstract nodes), aren't counted.
+func CalculateOwnerMatrix(file_relation_map map[string][]string, codeowners Codeowners) schema.OwnerMatrix {
+	owners_of := map[string][]string{}
+	ownersOf := func(file string) []string {
+		if owners, ok := owners_of[file]; ok {
+			return owners
+		}
+		owners_of[file] = codeowners.Owners(file)
+		return owners_of[file]
+	}
+
+	matrix := schema.OwnerMatrix{}
+	for file, deps := range file_relation_map {
+		file_owners := ownersOf(file)
+		if len(file_owners) == 0 {
+			continue
+		}
+		for _, dep := range deps {
+			for _, dep_owner := range ownersOf(dep) {
+				for _, owner := range file_owners {
+					if owner == dep_owner {
+						continue
+					}
+					if matrix[owner] == nil {
+						matrix[owner] = map[string]int{}
+					}
+					matrix[owner][dep_owner]++
+				}
+			}
+		}
+	}
+	return matrix
+}
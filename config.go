@@ -1,12 +1,16 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"os"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/wazzaps/repo_dagger/pkg/schema"
 )
 
 type StringOrStringArr struct {
@@ -30,11 +34,42 @@ func (res *StringOrStringArr) UnmarshalYAML(unmarshal func(interface{}) error) e
 
 type RuleActions struct {
 	Visit                       StringOrStringArr
+	VisitAbstractNodes          StringOrStringArr `yaml:"visit_abstract_nodes"`
 	VisitSiblings               StringOrStringArr `yaml:"visit_siblings"`
 	VisitGrandSiblings          StringOrStringArr `yaml:"visit_grand_siblings"`
 	VisitImportedPythonModules  bool              `yaml:"visit_imported_python_modules"`
 	VisitPythonAllSubmodulesFor StringOrStringArr `yaml:"visit_python_all_submodules_for"`
+	SkipPythonParentPackages    bool              `yaml:"skip_python_parent_packages"`
+	VisitPythonDynamicImports   bool              `yaml:"visit_python_dynamic_imports"`
+	VisitPythonReExports        bool              `yaml:"visit_python_reexports"`
+	PythonTypeCheckingImports   string            `yaml:"python_type_checking_imports"`
+	MarkOptionalImports         bool              `yaml:"mark_optional_imports"`
+	VisitImportedGoPackages     bool              `yaml:"visit_imported_go_packages"`
+	VisitImportedRustModules    bool              `yaml:"visit_imported_rust_modules"`
+	VisitCIncludes              bool              `yaml:"visit_c_includes"`
+	VisitImportedJavaClasses    bool              `yaml:"visit_imported_java_classes"`
+	VisitCMakeReferences        bool              `yaml:"visit_cmake_references"`
+	VisitDockerfileRefs         bool              `yaml:"visit_dockerfile_refs"`
+	VisitDbtRefs                bool              `yaml:"visit_dbt_refs"`
+	VisitJinjaTemplates         bool              `yaml:"visit_jinja_templates"`
+	VisitCsprojReferences       bool              `yaml:"visit_csproj_references"`
+	VisitSwiftPackageTargets    bool              `yaml:"visit_swift_package_targets"`
+	VisitGradleProjects         bool              `yaml:"visit_gradle_projects"`
+	VisitMarkdownLinks          bool              `yaml:"visit_markdown_links"`
+	VisitScssImports            bool              `yaml:"visit_scss_imports"`
+	VisitHelmChartFiles         bool              `yaml:"visit_helm_chart_files"`
+	VisitKustomizeResources     bool              `yaml:"visit_kustomize_resources"`
+	VisitMavenModules           bool              `yaml:"visit_maven_modules"`
+	VisitThriftIncludes         bool              `yaml:"visit_thrift_includes"`
+	VisitNixImports             bool              `yaml:"visit_nix_imports"`
+	VisitLockedPackages         bool              `yaml:"visit_locked_packages"`
+	VisitGraphqlImports         bool              `yaml:"visit_graphql_imports"`
+	VisitOpenapiRefs            bool              `yaml:"visit_openapi_refs"`
+	VisitAnsibleIncludes        bool              `yaml:"visit_ansible_includes"`
+	VisitPyprojectEntryPoints   bool              `yaml:"visit_pyproject_entry_points"`
 	Exclude                     StringOrStringArr
+	EdgeType                    string `yaml:"edge_type"`
+	InterfaceOnly               bool   `yaml:"interface_only"`
 }
 
 type PathRule struct {
@@ -43,33 +78,271 @@ type PathRule struct {
 }
 
 type Config struct {
-	BaseDir            string `yaml:"base_dir"`
-	Inputs             StringOrStringArr
-	GlobalDeps         StringOrStringArr   `yaml:"global_deps"`
-	GlobalExclude      StringOrStringArr   `yaml:"global_exclude"`
-	RootPythonPackages StringOrStringArr   `yaml:"root_python_packages"`
-	PathRules          map[string]PathRule `yaml:"path_rules"`
+	Include             StringOrStringArr
+	BaseDir             string `yaml:"base_dir"`
+	Inputs              StringOrStringArr
+	GlobalDeps          StringOrStringArr            `yaml:"global_deps"`
+	GlobalExclude       StringOrStringArr            `yaml:"global_exclude"`
+	TerminalDirs        StringOrStringArr            `yaml:"terminal_dirs"`
+	RootPythonPackages  StringOrStringArr            `yaml:"root_python_packages"`
+	PythonSourceRoots   map[string]StringOrStringArr `yaml:"python_source_roots"`
+	PythonModOverrides  map[string]string            `yaml:"python_module_overrides"`
+	PythonTestFiles     *StringOrStringArr           `yaml:"python_test_files"`
+	PythonImplStubs     bool                         `yaml:"python_implementation_stubs"`
+	PythonStubPackages  bool                         `yaml:"python_stub_packages"`
+	CIncludeDirs        StringOrStringArr            `yaml:"c_include_dirs"`
+	JavaSourceRoots     StringOrStringArr            `yaml:"java_source_roots"`
+	CMakeModuleDirs     StringOrStringArr            `yaml:"cmake_module_dirs"`
+	DockerBuildContexts map[string]string            `yaml:"docker_build_contexts"`
+	DockerLocalImages   map[string]string            `yaml:"docker_local_images"`
+	DbtModelDirs        StringOrStringArr            `yaml:"dbt_model_dirs"`
+	JinjaTemplateRoots  StringOrStringArr            `yaml:"jinja_template_roots"`
+	ScssIncludePaths    StringOrStringArr            `yaml:"scss_include_paths"`
+	ThriftIncludeDirs   StringOrStringArr            `yaml:"thrift_include_dirs"`
+	AnsibleRolesPaths   StringOrStringArr            `yaml:"ansible_roles_paths"`
+	PathAliases         map[string]string            `yaml:"path_aliases"`
+	GeneratedFiles      map[string]StringOrStringArr `yaml:"generated_files"`
+	ExternalInputs      map[string]ExternalInput     `yaml:"external_inputs"`
+	AbstractNodes       map[string]ExternalInput     `yaml:"abstract_nodes"`
+	Lockfiles           StringOrStringArr            `yaml:"lockfiles"`
+	PackageAliases      map[string]string            `yaml:"package_aliases"`
+	FederatedRepos      map[string]FederatedRepo     `yaml:"federated_repos"`
+	CrossRepoDeps       map[string]StringOrStringArr `yaml:"cross_repo_deps"`
+	HashEnvironments    map[string]EdgeFilter        `yaml:"hash_environments"`
+	TargetGroups        map[string]TargetGroup       `yaml:"target_groups"`
+	TargetGraph         map[string]StringOrStringArr `yaml:"target_graph"`
+	Codeowners          string                       `yaml:"codeowners"`
+	PathRules           map[string]PathRule          `yaml:"path_rules"`
+	Resolvers           []ResolverConfig             `yaml:"resolvers"`
+	NetworkFilesystem   bool                         `yaml:"network_filesystem"`
+	ReadConcurrency     int                          `yaml:"read_concurrency"`
+	FileHashAlgorithm   string                       `yaml:"file_hash_algorithm"`
+	IOErrors            IOErrorPolicy                `yaml:"io_errors"`
+
+	path_aliases      []PathMapping
+	generated_files   []PathMapping
+	python_test_files []string
+	terminal_dirs     []string
+	unreadable_files  *unreadableFiles
+	dir_listings      *dirListings
+	// Imports of `root_python_packages` that didn't resolve to any file
+	unresolved_imports []schema.UnresolvedImport
+	// The built-in resolvers that may run, in order (see `resolvers`)
+	active_resolvers []*builtinResolver
+	// Config file -> the config files it includes
+	include_graph map[string][]string
 }
 
-// Load the yaml config
-func LoadConfig(path string) (*Config, [32]byte, error) {
+// Python files whose imports create "test" edges, unless `python_test_files` is set
+var defaultPythonTestFiles = []string{"tests/**", "**/test_*.py", "**/*_test.py", "**/conftest.py"}
+
+// Compile the parts of the config that need it, after it was decoded
+func (config *Config) prepare() error {
+	// The state shared by everything reading the repo during the run
+	config.unreadable_files = &unreadableFiles{files: map[string]error{}}
+	config.dir_listings = &dirListings{dirs: map[string]*dirListing{}}
+
+	// First, since resolver options may set any of the keys below
+	err := validateResolvers(config)
+	if err != nil {
+		return fmt.Errorf("invalid resolvers: %v", err)
+	}
+
+	aliases := map[string][]string{}
+	for pattern, canonical := range config.PathAliases {
+		aliases[pattern] = []string{canonical}
+	}
+	path_aliases, err := compilePathMappings(aliases)
+	if err != nil {
+		return fmt.Errorf("invalid path_aliases: %v", err)
+	}
+	config.path_aliases = path_aliases
+
+	generated := map[string][]string{}
+	for pattern, sources := range config.GeneratedFiles {
+		generated[pattern] = sources.items
+	}
+	generated_files, err := compilePathMappings(generated)
+	if err != nil {
+		return fmt.Errorf("invalid generated_files: %v", err)
+	}
+	config.generated_files = generated_files
+
+	config.python_test_files = defaultPythonTestFiles
+	if config.PythonTestFiles != nil {
+		config.python_test_files = config.PythonTestFiles.items
+	}
+	for _, pattern := range config.python_test_files {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid python_test_files pattern '%s'", pattern)
+		}
+	}
+
+	for pattern, rule := range config.PathRules {
+		all_actions := []RuleActions{rule.Actions}
+		for _, regex_actions := range rule.RegexRules {
+			all_actions = append(all_actions, regex_actions)
+		}
+		for _, actions := range all_actions {
+			switch actions.PythonTypeCheckingImports {
+			case "", TYPE_CHECKING_IMPORTS_INCLUDE, TYPE_CHECKING_IMPORTS_EXCLUDE, TYPE_CHECKING_IMPORTS_WEAK:
+			default:
+				return fmt.Errorf(
+					"invalid python_type_checking_imports '%s' in rule '%s', expected include, exclude or weak",
+					actions.PythonTypeCheckingImports,
+					pattern,
+				)
+			}
+		}
+	}
+
+	err = validateTerminalDirs(config)
+	if err != nil {
+		return fmt.Errorf("invalid terminal_dirs: %v", err)
+	}
+
+	err = validatePythonSourceRoots(config)
+	if err != nil {
+		return fmt.Errorf("invalid python_source_roots: %v", err)
+	}
+
+	err = validatePythonModOverrides(config)
+	if err != nil {
+		return fmt.Errorf("invalid python_module_overrides: %v", err)
+	}
+
+	for pattern := range config.DockerBuildContexts {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid docker_build_contexts pattern '%s'", pattern)
+		}
+	}
+
+	if config.ReadConcurrency < 0 {
+		return fmt.Errorf("invalid read_concurrency %d", config.ReadConcurrency)
+	}
+
+	if _, ok := fileHashAlgorithms[config.FileHashAlgorithm]; !ok && config.FileHashAlgorithm != "" {
+		return fmt.Errorf("invalid file_hash_algorithm '%s', expected sha256 or sha512_256", config.FileHashAlgorithm)
+	}
+
+	err = validateIOErrorPolicy(config)
+	if err != nil {
+		return fmt.Errorf("invalid io_errors: %v", err)
+	}
+
+	err = validateExternalInputs(config)
+	if err != nil {
+		return fmt.Errorf("invalid external_inputs: %v", err)
+	}
+
+	err = validateAbstractNodes(config)
+	if err != nil {
+		return fmt.Errorf("invalid abstract_nodes: %v", err)
+	}
+
+	err = validateFederation(config)
+	if err != nil {
+		return fmt.Errorf("invalid federation config: %v", err)
+	}
+
+	err = validateTargetGraph(config)
+	if err != nil {
+		return fmt.Errorf("invalid target_graph: %v", err)
+	}
+
+	err = validateEdgeFilters(config)
+	if err != nil {
+		return fmt.Errorf("invalid edge filters: %v", err)
+	}
+
+	return nil
+}
+
+// Returns the canonical path of a (possibly generated) file, according to `path_aliases`
+func (config *Config) CanonicalPath(path string) string {
+	for _, alias := range config.path_aliases {
+		if canonical, ok := alias.Apply(path); ok {
+			return canonical[0]
+		}
+	}
+	return path
+}
+
+func isRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// The directory `base_dir` is relative to: the config's directory, or the working directory for
+// remote configs
+func ConfigDir(path string) string {
+	if isRemoteConfig(path) {
+		return "."
+	}
+	return filepath.Dir(path)
+}
+
+func fetchRemoteConfig(url string) ([]byte, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Load the yaml config (and the configs it includes), from a local path or an http(s) URL.
+// If `pinned_sha256` isn't empty, the hash of the config files (see `configLoader.hash`, without the
+// `-define` values) must match it.
+func LoadConfig(path string, pinned_sha256 string, defines configDefines) (*Config, [32]byte, error) {
 	// Read the config file
-	file_data, err := os.ReadFile(path)
+	file_data, err := readConfigFile(path)
 	if err != nil {
 		return nil, [32]byte{}, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Decode the YAML data
+	// Decode the YAML data, with the includes
 	var config Config
-	decoder := yaml.NewDecoder(bytes.NewReader(file_data))
-	decoder.KnownFields(true)
-	err = decoder.Decode(&config)
+	loader := configLoader{
+		graph:   map[string][]string{},
+		loading: map[string]bool{},
+		vars:    &configVars{defines: defines, used: map[string]string{}},
+	}
+	err = loader.load(path, file_data, &config)
 	if err != nil {
-		return nil, [32]byte{}, fmt.Errorf("failed to decode config file: %w", err)
+		return nil, [32]byte{}, err
 	}
+	config.include_graph = loader.graph
 
-	// Hash the config file
-	configHash := sha256.Sum256(file_data)
+	// Hash the config files, and then the values of their placeholders
+	files_hash := loader.hash()
+	if pinned_sha256 != "" && !strings.EqualFold(hex.EncodeToString(files_hash[:]), pinned_sha256) {
+		return nil, [32]byte{}, fmt.Errorf(
+			"config checksum mismatch: expected sha256 %s, got %x",
+			pinned_sha256,
+			files_hash,
+		)
+	}
+	configHash := loader.vars.hash(files_hash)
+
+	err = config.prepare()
+	if err != nil {
+		return nil, [32]byte{}, fmt.Errorf("failed to load config file: %w", err)
+	}
 
 	return &config, configHash, nil
 }
+
+// Returns the source globs of a generated file and the matching pattern, according to
+// `generated_files`
+func (config *Config) GeneratedSources(path string) ([]string, string, bool) {
+	for _, generated := range config.generated_files {
+		if sources, ok := generated.Apply(path); ok {
+			return sources, generated.name, true
+		}
+	}
+	return nil, "", false
+}
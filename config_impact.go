@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// The graph of a single config, for comparing configs
+type impactGraph struct {
+	config            *Config
+	base_dir          string
+	input_files       []string
+	all_files_set     map[string]bool
+	file_relation_map map[string][]string
+	edge_origins      EdgeOrigins
+	file_hashes       map[string][32]byte
+	dep_hash_params   DepHashParams
+}
+
+func buildImpactGraph(config_path string, config *Config, args *Args) (*impactGraph, error) {
+	graph := &impactGraph{
+		config:            config,
+		base_dir:          filepath.Join(ConfigDir(config_path), config.BaseDir),
+		all_files_set:     map[string]bool{},
+		file_relation_map: map[string][]string{},
+		edge_origins:      EdgeOrigins{},
+	}
+	input_files, err := CollectInputFiles(config, graph.base_dir)
+	if err != nil {
+		return nil, fmt.Errorf("error while collecting input files: %v", err)
+	}
+	graph.input_files = input_files
+	err = VisitRecursively(graph.all_files_set, graph.file_relation_map, graph.edge_origins, input_files, config, args, graph.base_dir)
+	if err != nil {
+		return nil, fmt.Errorf("error while visiting files: %v", err)
+	}
+	federated_graphs, err := LoadFederatedGraphs(config, ConfigDir(config_path), graph.base_dir)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading federated graphs: %v", err)
+	}
+	err = federated_graphs.Link(graph.file_relation_map, graph.edge_origins, config)
+	if err != nil {
+		return nil, fmt.Errorf("error while linking federated graphs: %v", err)
+	}
+
+	graph.file_hashes = map[string][32]byte{}
+	federated_graphs.MergeFileHashes(graph.file_hashes)
+	external_inputs, err := CaptureExternalInputs(config, graph.base_dir)
+	if err != nil {
+		return nil, fmt.Errorf("error while capturing external inputs: %v", err)
+	}
+	// The config hash is left out, since it always changes
+	graph.dep_hash_params = DepHashParams{
+		AlgorithmVersion: config.fileHashAlgorithm().version,
+		Salt:             args.HashSalt,
+		ToolFingerprint:  []byte(args.ToolchainFingerprint),
+		ExternalInputs:   external_inputs,
+	}
+	return graph, nil
+}
+
+// Hash the files of the graph, reusing the hashes of the other graph (if any) where possible
+func (graph *impactGraph) hashFiles(other *impactGraph) {
+	missing := map[string]bool{}
+	for file := range graph.all_files_set {
+		if _, _, is_generated := graph.config.GeneratedSources(file); is_generated {
+			continue
+		}
+		if other == nil || other.base_dir != graph.base_dir || other.dep_hash_params.AlgorithmVersion != graph.dep_hash_params.AlgorithmVersion {
+			missing[file] = true
+			continue
+		}
+		_, _, other_generated := other.config.GeneratedSources(file)
+		if file_hash, ok := other.file_hashes[file]; ok && !other_generated {
+			graph.file_hashes[file] = file_hash
+		} else {
+			missing[file] = true
+		}
+	}
+	CalculateFileHashes(graph.file_hashes, missing, graph.config, graph.base_dir)
+}
+
+// The dependency list that goes into the input's dependency hash
+func (graph *impactGraph) depList(file string) []string {
+	var follow func(edge Edge) bool
+	if group_filters := graph.config.TargetGroupFilters(file); len(group_filters) != 0 {
+		follow = combineEdgeFilters(group_filters, graph.edge_origins)
+	}
+	return BuildFilteredDepList(graph.file_relation_map, file, follow)
+}
+
+// Compare the graphs of the current and proposed configs, reporting which inputs' dependencies
+// and dependency hashes change
+func CompareConfigs(
+	config_path string,
+	config *Config,
+	config_hash [32]byte,
+	proposed_path string,
+	args *Args,
+) (*schema.ConfigImpact, error) {
+	proposed, proposed_hash, err := LoadConfig(proposed_path, "", args.Defines)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading proposed config: %v", err)
+	}
+	if len(args.InputFiles) > 0 && args.InputFiles[0] != "" {
+		proposed.Inputs.items = args.InputFiles
+	}
+	if args.NetworkFS {
+		proposed.NetworkFilesystem = true
+	}
+	if args.AssertReadOnly {
+		if err := checkReadOnlyConfig(proposed); err != nil {
+			return nil, fmt.Errorf("invalid proposed config: %v", err)
+		}
+	}
+
+	progress_log.Println("Generating dependency graph of the current config")
+	current_graph, err := buildImpactGraph(config_path, config, args)
+	if err != nil {
+		return nil, err
+	}
+	progress_log.Println("Generating dependency graph of the proposed config")
+	proposed_graph, err := buildImpactGraph(proposed_path, proposed, args)
+	if err != nil {
+		return nil, err
+	}
+	progress_log.Println("Calculating file hashes")
+	current_graph.hashFiles(nil)
+	proposed_graph.hashFiles(current_graph)
+
+	impact := &schema.ConfigImpact{
+		ConfigHashChanged: config_hash != proposed_hash,
+		Targets:           map[string]schema.TargetImpact{},
+	}
+	targets := slices.Concat(current_graph.input_files, proposed_graph.input_files)
+	slices.Sort(targets)
+	for _, target := range slices.Compact(targets) {
+		_, in_current := slices.BinarySearch(current_graph.input_files, target)
+		_, in_proposed := slices.BinarySearch(proposed_graph.input_files, target)
+		if !in_current {
+			impact.Targets[target] = schema.TargetImpact{Status: "added", HashChanged: true}
+			continue
+		} else if !in_proposed {
+			impact.Targets[target] = schema.TargetImpact{Status: "removed", HashChanged: true}
+			continue
+		}
+
+		current_deps := current_graph.depList(target)
+		proposed_deps := proposed_graph.depList(target)
+		target_impact := schema.TargetImpact{
+			Status:      "changed",
+			AddedDeps:   sortedDifference(proposed_deps, current_deps),
+			RemovedDeps: sortedDifference(current_deps, proposed_deps),
+		}
+		target_impact.HashChanged = CalculateDepHash(
+			target, current_deps, nil, current_graph.file_hashes, &current_graph.dep_hash_params,
+		) != CalculateDepHash(
+			target, proposed_deps, nil, proposed_graph.file_hashes, &proposed_graph.dep_hash_params,
+		)
+		if target_impact.HashChanged || len(target_impact.AddedDeps) != 0 || len(target_impact.RemovedDeps) != 0 {
+			impact.Targets[target] = target_impact
+		}
+	}
+	return impact, nil
+}
+
+// Returns the items of sorted list `a` that aren't in sorted list `b`
+func sortedDifference(a []string, b []string) []string {
+	out := []string{}
+	for _, item := range a {
+		if _, found := slices.BinarySearch(b, item); !found {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config file, or a fragment included by one
+type configFile struct {
+	path string
+	data []byte
+}
+
+// Loads a config and the fragments it includes, recursively
+type configLoader struct {
+	// In load order: the config itself first, then its includes depth first
+	files []configFile
+	// Config file -> the files it includes directly
+	graph   map[string][]string
+	loading map[string]bool
+	vars    *configVars
+}
+
+func readConfigFile(path string) ([]byte, error) {
+	if isRemoteConfig(path) {
+		return fetchRemoteConfig(path)
+	}
+	return os.ReadFile(path)
+}
+
+// Returns the path of a config included by another, relative to the including one
+func resolveConfigInclude(including string, include string) (string, error) {
+	if isRemoteConfig(include) || filepath.IsAbs(include) {
+		return include, nil
+	}
+	if isRemoteConfig(including) {
+		base, err := url.Parse(including)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(include)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	return filepath.Join(filepath.Dir(including), include), nil
+}
+
+// Read a config file and its includes, and decode them into `config`: the includes first (in
+// order), so the including file overlays them.
+func (loader *configLoader) load(path string, file_data []byte, config *Config) error {
+	if loader.loading[path] {
+		return fmt.Errorf("config '%s' includes itself", path)
+	}
+	loader.loading[path] = true
+	defer delete(loader.loading, path)
+	loader.files = append(loader.files, configFile{path, file_data})
+	loader.graph[path] = []string{}
+	file_data, err := configAsYaml(path, file_data)
+	if err != nil {
+		return fmt.Errorf("failed to decode config file '%s': %w", path, err)
+	}
+	file_data, err = loader.vars.expandConfig(file_data)
+	if err != nil {
+		return fmt.Errorf("failed to expand config file '%s': %w", path, err)
+	}
+
+	var includes struct {
+		Include StringOrStringArr `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(file_data, &includes); err != nil {
+		return fmt.Errorf("failed to decode config file '%s': %w", path, err)
+	}
+	for _, include := range includes.Include.items {
+		include_path, err := resolveConfigInclude(path, include)
+		if err != nil {
+			return fmt.Errorf("invalid include '%s' in '%s': %w", include, path, err)
+		}
+		include_data, err := readConfigFile(include_path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file '%s' included by '%s': %w", include_path, path, err)
+		}
+		loader.graph[path] = append(loader.graph[path], include_path)
+		if err := loader.load(include_path, include_data, config); err != nil {
+			return err
+		}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(file_data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("failed to decode config file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// The hash of the config and all of its includes. Without includes it's the hash of the config
+// file, like before includes existed.
+func (loader *configLoader) hash() [32]byte {
+	if len(loader.files) == 1 {
+		return sha256.Sum256(loader.files[0].data)
+	}
+	hasher := sha256.New()
+	hasher.Write(loader.files[0].data)
+	for _, file := range loader.files[1:] {
+		file_hash := sha256.Sum256(file.data)
+		hasher.Write(file_hash[:])
+	}
+	var config_hash [32]byte
+	copy(config_hash[:], hasher.Sum(nil))
+	return config_hash
+}
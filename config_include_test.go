@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigPinnedWithInclude(t *testing.T) {
+	config_data := "include: [common.yaml]\nglobal_deps: ['${FLAVOR}.txt']\n"
+	common_data := "root_python_packages: [pkg]\n"
+	base_dir := writeTestRepo(t, map[string]string{
+		"repo_dagger.yaml": config_data,
+		"common.yaml":      common_data,
+	})
+	config_path := filepath.Join(base_dir, "repo_dagger.yaml")
+	defines := configDefines{"FLAVOR": "prod"}
+
+	// The config's contents, then the hash of each include
+	common_hash := sha256.Sum256([]byte(common_data))
+	combined := fmt.Sprintf("%x", sha256.Sum256(append([]byte(config_data), common_hash[:]...)))
+	config, config_hash, err := LoadConfig(config_path, combined, defines)
+	if err != nil {
+		t.Fatalf("loading with the combined hash pinned failed: %v", err)
+	}
+	if len(config.RootPythonPackages.items) != 1 {
+		t.Errorf("the include wasn't applied: %v", config.RootPythonPackages.items)
+	}
+	// The `-define` values are in the config hash, but not in the pinned hash
+	if fmt.Sprintf("%x", config_hash) == combined {
+		t.Error("the config hash doesn't cover the -define values")
+	}
+
+	for _, pinned := range []string{
+		fmt.Sprintf("%x", sha256.Sum256([]byte(config_data))),
+		fmt.Sprintf("%x", config_hash),
+	} {
+		if _, _, err := LoadConfig(config_path, pinned, defines); err == nil {
+			t.Errorf("loading with %s pinned should have failed", pinned)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Returns a config file in a form the YAML decoder reads: YAML and JSON (a subset of YAML) as is,
+// and TOML (`.toml`) converted to JSON. So all formats are decoded with the same strictness.
+func configAsYaml(path string, file_data []byte) ([]byte, error) {
+	if isRemoteConfig(path) {
+		if parsed, err := url.Parse(path); err == nil {
+			path = parsed.Path
+		}
+	}
+	if strings.ToLower(filepath.Ext(path)) != ".toml" {
+		return file_data, nil
+	}
+	parsed, err := parseToml(string(file_data))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(parsed)
+}
+
+// Parses a TOML document into maps, arrays and scalars. Supports everything configs use: tables,
+// arrays of tables, dotted and quoted keys, inline tables, arrays, strings (basic, literal and
+// multi-line), integers, floats and booleans. Dates and times aren't supported.
+func parseToml(data string) (map[string]any, error) {
+	parser := tomlParser{data: data, kinds: map[uintptr]tomlTableKind{}}
+	root, err := parser.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOML at line %d: %v", strings.Count(data[:parser.pos], "\n")+1, err)
+	}
+	return root, nil
+}
+
+type tomlParser struct {
+	data string
+	pos  int
+	// How each table was created, which decides whether it can be defined or extended later
+	kinds map[uintptr]tomlTableKind
+}
+
+type tomlTableKind int
+
+const (
+	// A parent of a header's table, which a header of its own can still define
+	tomlTableImplicit tomlTableKind = iota
+	// Defined by a `[header]` or `[[header]]`
+	tomlTableHeader
+	// Created by a dotted key, which only other dotted keys can extend
+	tomlTableDotted
+	// An inline table, which can't be extended at all
+	tomlTableInline
+)
+
+// The tables of a `[[header]]`, unlike arrays of inline tables, which can't be extended
+type tomlTableArray []map[string]any
+
+func (parser *tomlParser) kind(table map[string]any) tomlTableKind {
+	return parser.kinds[reflect.ValueOf(table).Pointer()]
+}
+
+func (parser *tomlParser) setKind(table map[string]any, kind tomlTableKind) {
+	parser.kinds[reflect.ValueOf(table).Pointer()] = kind
+}
+
+func (parser *tomlParser) peek(prefix string) bool {
+	return strings.HasPrefix(parser.data[parser.pos:], prefix)
+}
+
+func (parser *tomlParser) eof() bool {
+	return parser.pos >= len(parser.data)
+}
+
+// Skips spaces and tabs, and a comment until the end of the line
+func (parser *tomlParser) skipSpaces() {
+	for !parser.eof() && (parser.data[parser.pos] == ' ' || parser.data[parser.pos] == '\t') {
+		parser.pos++
+	}
+	if parser.peek("#") {
+		for !parser.eof() && parser.data[parser.pos] != '\n' {
+			parser.pos++
+		}
+	}
+}
+
+// Skips whitespace, comments and newlines
+func (parser *tomlParser) skipBlank() {
+	for {
+		parser.skipSpaces()
+		if parser.peek("\n") || parser.peek("\r\n") {
+			parser.pos += strings.IndexByte(parser.data[parser.pos:], '\n') + 1
+			continue
+		}
+		return
+	}
+}
+
+func (parser *tomlParser) expect(token string) error {
+	if !parser.peek(token) {
+		return fmt.Errorf("expected '%s'", token)
+	}
+	parser.pos += len(token)
+	return nil
+}
+
+func (parser *tomlParser) expectLineEnd() error {
+	parser.skipSpaces()
+	if parser.eof() || parser.peek("\n") || parser.peek("\r\n") {
+		return nil
+	}
+	return fmt.Errorf("expected the end of the line")
+}
+
+func (parser *tomlParser) parse() (map[string]any, error) {
+	root := map[string]any{}
+	current := root
+	for {
+		parser.skipBlank()
+		if parser.eof() {
+			return root, nil
+		}
+		if parser.peek("[") {
+			is_array := parser.peek("[[")
+			parser.pos += 1
+			if is_array {
+				parser.pos += 1
+			}
+			parser.skipSpaces()
+			keys, err := parser.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			parser.skipSpaces()
+			if is_array {
+				err = parser.expect("]]")
+			} else {
+				err = parser.expect("]")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := parser.expectLineEnd(); err != nil {
+				return nil, err
+			}
+			parent, err := parser.table(root, keys[:len(keys)-1], false)
+			if err != nil {
+				return nil, err
+			}
+			last := keys[len(keys)-1]
+			current = map[string]any{}
+			switch existing := parent[last].(type) {
+			case nil:
+				if is_array {
+					parent[last] = tomlTableArray{current}
+				} else {
+					parent[last] = current
+				}
+			case tomlTableArray:
+				if !is_array {
+					return nil, fmt.Errorf("table '%s' is already defined", strings.Join(keys, "."))
+				}
+				parent[last] = append(existing, current)
+			case map[string]any:
+				if is_array || parser.kind(existing) != tomlTableImplicit {
+					return nil, fmt.Errorf("table '%s' is already defined", strings.Join(keys, "."))
+				}
+				current = existing
+			default:
+				return nil, fmt.Errorf("'%s' is already defined", strings.Join(keys, "."))
+			}
+			parser.setKind(current, tomlTableHeader)
+			continue
+		}
+		if err := parser.parseKeyValue(current); err != nil {
+			return nil, err
+		}
+		if err := parser.expectLineEnd(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Returns the table at a path of keys, creating missing tables. Arrays of tables lead to their last
+// table. The tables on the path of a dotted key must have been created by dotted keys.
+func (parser *tomlParser) table(table map[string]any, keys []string, dotted bool) (map[string]any, error) {
+	for i, key := range keys {
+		switch next := table[key].(type) {
+		case nil:
+			created := map[string]any{}
+			if dotted {
+				parser.setKind(created, tomlTableDotted)
+			}
+			table[key] = created
+			table = created
+		case map[string]any:
+			if kind := parser.kind(next); kind == tomlTableInline {
+				return nil, fmt.Errorf("'%s' is an inline table, which can't be extended", strings.Join(keys[:i+1], "."))
+			} else if dotted && kind != tomlTableDotted {
+				return nil, fmt.Errorf("table '%s' is already defined", strings.Join(keys[:i+1], "."))
+			}
+			table = next
+		case tomlTableArray:
+			if dotted {
+				return nil, fmt.Errorf("'%s' is an array of tables, which dotted keys can't extend", strings.Join(keys[:i+1], "."))
+			}
+			table = next[len(next)-1]
+		default:
+			return nil, fmt.Errorf("'%s' isn't a table", strings.Join(keys[:i+1], "."))
+		}
+	}
+	return table, nil
+}
+
+// Parses `key = value` (with a dotted key) into a table
+func (parser *tomlParser) parseKeyValue(table map[string]any) error {
+	keys, err := parser.parseKey()
+	if err != nil {
+		return err
+	}
+	parser.skipSpaces()
+	if err := parser.expect("="); err != nil {
+		return err
+	}
+	parser.skipSpaces()
+	value, err := parser.parseValue()
+	if err != nil {
+		return err
+	}
+	parent, err := parser.table(table, keys[:len(keys)-1], true)
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return fmt.Errorf("'%s' is already defined", strings.Join(keys, "."))
+	}
+	parent[last] = value
+	return nil
+}
+
+// Parses a dotted key of bare and quoted parts
+func (parser *tomlParser) parseKey() ([]string, error) {
+	keys := []string{}
+	for {
+		var key string
+		var err error
+		switch {
+		case parser.peek(`"`):
+			key, err = parser.parseBasicString()
+		case parser.peek("'"):
+			key, err = parser.parseLiteralString()
+		default:
+			start := parser.pos
+			for !parser.eof() && isTomlBareKeyByte(parser.data[parser.pos]) {
+				parser.pos++
+			}
+			key = parser.data[start:parser.pos]
+			if key == "" {
+				err = fmt.Errorf("expected a key")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		parser.skipSpaces()
+		if !parser.peek(".") {
+			return keys, nil
+		}
+		parser.pos++
+		parser.skipSpaces()
+	}
+}
+
+func isTomlBareKeyByte(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (parser *tomlParser) parseValue() (any, error) {
+	switch {
+	case parser.eof():
+		return nil, fmt.Errorf("expected a value")
+	case parser.peek(`"""`):
+		return parser.parseMultilineString(`"""`)
+	case parser.peek("'''"):
+		return parser.parseMultilineString("'''")
+	case parser.peek(`"`):
+		return parser.parseBasicString()
+	case parser.peek("'"):
+		return parser.parseLiteralString()
+	case parser.peek("["):
+		return parser.parseArray()
+	case parser.peek("{"):
+		return parser.parseInlineTable()
+	}
+	start := parser.pos
+	for !parser.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(parser.data[parser.pos])) {
+		parser.pos++
+	}
+	token := parser.data[start:parser.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.ReplaceAll(token, "_", "")
+	if tomlIntegerPattern.MatchString(token) {
+		if value, err := strconv.ParseInt(number, 0, 64); err == nil {
+			return value, nil
+		}
+		parser.pos = start
+		return nil, fmt.Errorf("integer '%s' is out of range", token)
+	}
+	if tomlFloatPattern.MatchString(token) {
+		if strings.TrimLeft(number, "+-") == "nan" {
+			return math.NaN(), nil
+		}
+		if value, err := strconv.ParseFloat(number, 64); err == nil {
+			return value, nil
+		}
+		parser.pos = start
+		return nil, fmt.Errorf("float '%s' is out of range", token)
+	}
+	parser.pos = start
+	return nil, fmt.Errorf("unsupported value '%s'", token)
+}
+
+// Integers and floats as TOML writes them: no leading zeros, underscores only between digits, and
+// a sign only on decimals
+var tomlIntegerPattern = regexp.MustCompile(`^(?:[+-]?(?:0|[1-9](?:_?[0-9])*)|0x[0-9A-Fa-f](?:_?[0-9A-Fa-f])*|0o[0-7](?:_?[0-7])*|0b[01](?:_?[01])*)$`)
+var tomlFloatPattern = regexp.MustCompile(`^[+-]?(?:(?:0|[1-9](?:_?[0-9])*)(?:\.[0-9](?:_?[0-9])*)?(?:[eE][+-]?[0-9](?:_?[0-9])*)?|inf|nan)$`)
+
+func (parser *tomlParser) parseArray() ([]any, error) {
+	parser.pos++
+	array := []any{}
+	for {
+		parser.skipBlank()
+		if parser.peek("]") {
+			parser.pos++
+			return array, nil
+		}
+		value, err := parser.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+		parser.skipBlank()
+		if parser.peek(",") {
+			parser.pos++
+		} else if !parser.peek("]") {
+			return nil, fmt.Errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (parser *tomlParser) parseInlineTable() (map[string]any, error) {
+	parser.pos++
+	table := map[string]any{}
+	parser.skipSpaces()
+	if parser.peek("}") {
+		parser.pos++
+		parser.setKind(table, tomlTableInline)
+		return table, nil
+	}
+	for {
+		parser.skipSpaces()
+		if err := parser.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+		parser.skipSpaces()
+		if parser.peek("}") {
+			parser.pos++
+			parser.setKind(table, tomlTableInline)
+			return table, nil
+		}
+		if err := parser.expect(","); err != nil {
+			return nil, fmt.Errorf("expected ',' or '}'")
+		}
+	}
+}
+
+func (parser *tomlParser) parseLiteralString() (string, error) {
+	parser.pos++
+	end := strings.IndexAny(parser.data[parser.pos:], "'\n")
+	if end == -1 || parser.data[parser.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	value := parser.data[parser.pos : parser.pos+end]
+	parser.pos += end + 1
+	return value, nil
+}
+
+func (parser *tomlParser) parseBasicString() (string, error) {
+	parser.pos++
+	value := strings.Builder{}
+	for {
+		if parser.eof() || parser.peek("\n") {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := parser.data[parser.pos]
+		if c == '"' {
+			parser.pos++
+			return value.String(), nil
+		}
+		if c == '\\' {
+			if err := parser.parseEscape(&value); err != nil {
+				return "", err
+			}
+			continue
+		}
+		value.WriteByte(c)
+		parser.pos++
+	}
+}
+
+// Parses a multi-line string, basic or literal by its quotes. A newline right after the opening
+// quotes is trimmed, and in basic strings, a backslash at the end of a line trims the whitespace
+// after it.
+func (parser *tomlParser) parseMultilineString(quotes string) (string, error) {
+	parser.pos += len(quotes)
+	if parser.peek("\r\n") {
+		parser.pos += 2
+	} else if parser.peek("\n") {
+		parser.pos++
+	}
+	value := strings.Builder{}
+	for {
+		if parser.eof() {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if parser.peek(quotes) {
+			// Up to two quotes may come right before the closing ones
+			for extra := 0; extra < 2 && parser.peek(quotes+quotes[:1]); extra++ {
+				value.WriteByte(quotes[0])
+				parser.pos++
+			}
+			parser.pos += len(quotes)
+			return value.String(), nil
+		}
+		c := parser.data[parser.pos]
+		if c == '\\' && quotes == `"""` {
+			rest := strings.TrimLeft(parser.data[parser.pos+1:], " \t")
+			if strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n") {
+				parser.pos = len(parser.data) - len(strings.TrimLeft(rest, " \t\r\n"))
+				continue
+			}
+			if err := parser.parseEscape(&value); err != nil {
+				return "", err
+			}
+			continue
+		}
+		value.WriteByte(c)
+		parser.pos++
+	}
+}
+
+// Parses a backslash escape of a basic string
+func (parser *tomlParser) parseEscape(value *strings.Builder) error {
+	parser.pos++
+	if parser.eof() {
+		return fmt.Errorf("unterminated string")
+	}
+	escape := parser.data[parser.pos]
+	parser.pos++
+	switch escape {
+	case 'b':
+		value.WriteByte('\b')
+	case 't':
+		value.WriteByte('\t')
+	case 'n':
+		value.WriteByte('\n')
+	case 'f':
+		value.WriteByte('\f')
+	case 'r':
+		value.WriteByte('\r')
+	case 'e':
+		value.WriteByte(0x1b)
+	case '"':
+		value.WriteByte('"')
+	case '\\':
+		value.WriteByte('\\')
+	case 'u', 'U':
+		size := 4
+		if escape == 'U' {
+			size = 8
+		}
+		if parser.pos+size > len(parser.data) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+		code, err := strconv.ParseUint(parser.data[parser.pos:parser.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+		value.WriteRune(rune(code))
+		parser.pos += size
+	default:
+		parser.pos -= 2
+		return fmt.Errorf("invalid escape '\\%c'", escape)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseToml(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		toml string
+		// The parsed document as JSON, or empty if it's invalid
+		expected string
+	}{
+		{"empty", "", `{}`},
+		{"scalars", "a = 'x'\nb = true\nc = 3\nd = 1.5", `{"a":"x","b":true,"c":3,"d":1.5}`},
+		{"comments", "# comment\na = 1 # trailing\n", `{"a":1}`},
+		{"crlf", "a = 1\r\n[b]\r\nc = 2\r\n", `{"a":1,"b":{"c":2}}`},
+
+		// Integers
+		{"integer_signs", "a = +1\nb = -1\nc = 0\nd = -0", `{"a":1,"b":-1,"c":0,"d":0}`},
+		{"integer_underscores", "a = 1_000_000", `{"a":1000000}`},
+		{"integer_prefixes", "a = 0xff\nb = 0o17\nc = 0b101\nd = 0xdead_BEEF", `{"a":255,"b":15,"c":5,"d":3735928559}`},
+		{"integer_leading_zero", "a = 010", ""},
+		{"integer_leading_zero_negative", "a = -01", ""},
+		{"integer_signed_hex", "a = +0xff", ""},
+		{"integer_uppercase_prefix", "a = 0XFF", ""},
+		{"integer_leading_underscore", "a = _1", ""},
+		{"integer_trailing_underscore", "a = 1_", ""},
+		{"integer_double_underscore", "a = 1__0", ""},
+		{"integer_prefix_underscore", "a = 0x_ff", ""},
+		{"integer_out_of_range", "a = 9223372036854775808", ""},
+
+		// Floats
+		{"floats", "a = 0.5\nb = -1e3\nc = 6.25E-2\nd = 1_0.2_5\ne = 1e05", `{"a":0.5,"b":-1000,"c":0.0625,"d":10.25,"e":100000}`},
+		{"float_leading_zero", "a = 01.5", ""},
+		{"float_no_fraction_digits", "a = 1.", ""},
+		{"float_no_integer_digits", "a = .5", ""},
+		{"float_dot_before_exponent", "a = 1.e5", ""},
+		{"float_capital_inf", "a = Inf", ""},
+		{"float_infinity", "a = infinity", ""},
+		{"float_hex", "a = 0x1p-2", ""},
+		{"float_underscore_at_dot", "a = 1_.5", ""},
+
+		// Tables
+		{"tables", "[a]\nb = 1\n[c.d]\ne = 2", `{"a":{"b":1},"c":{"d":{"e":2}}}`},
+		{"table_after_subtable", "[a.b]\nc = 1\n[a]\nd = 2", `{"a":{"b":{"c":1},"d":2}}`},
+		{"table_redefined", "[a]\nb = 1\n[a]\nc = 2", ""},
+		{"table_over_value", "a = 1\n[a]", ""},
+		{"table_over_inline_table", "a = {b = 1}\n[a]", ""},
+		{"subtable_of_inline_table", "a = {b = 1}\n[a.c]", ""},
+		{"quoted_keys", "[\"a.b\"]\n'c d' = 1", `{"a.b":{"c d":1}}`},
+
+		// Dotted keys
+		{"dotted_keys", "a.b = 1\na.c = 2", `{"a":{"b":1,"c":2}}`},
+		{"dotted_keys_in_table", "[a]\nb.c = 1\nb.d = 2", `{"a":{"b":{"c":1,"d":2}}}`},
+		{"subtable_of_dotted_table", "[a]\nb.c = 1\n[a.b.d]\ne = 2", `{"a":{"b":{"c":1,"d":{"e":2}}}}`},
+		{"table_over_dotted_table", "[a]\nb.c = 1\n[a.b]\nd = 2", ""},
+		{"root_table_over_dotted_table", "a.b = 1\n[a]", ""},
+		{"dotted_key_into_table", "[a.b]\nc = 1\n[a]\nb.d = 2", ""},
+		{"dotted_key_into_inline_table", "a = {b = 1}\na.c = 2", ""},
+		{"dotted_key_over_value", "a = 1\na.b = 2", ""},
+		{"key_redefined", "a = 1\na = 2", ""},
+
+		// Arrays of tables
+		{"array_of_tables", "[[a]]\nb = 1\n[[a]]\nb = 2", `{"a":[{"b":1},{"b":2}]}`},
+		{"subtables_of_array_of_tables", "[[a]]\n[a.b]\nc = 1\n[[a]]\n[a.b]\nc = 2", `{"a":[{"b":{"c":1}},{"b":{"c":2}}]}`},
+		{"array_of_tables_over_table", "[a]\n[[a]]", ""},
+		{"table_over_array_of_tables", "[[a]]\n[a]", ""},
+		{"array_of_tables_over_array", "a = [{b = 1}]\n[[a]]", ""},
+		{"subtable_of_array", "a = [{b = 1}]\n[a.c]", ""},
+		{"dotted_key_into_array_of_tables", "[[a]]\n[b]\n[c]\n[[a]]\nb = 1\n[d]\n", `{"a":[{},{"b":1}],"b":{},"c":{},"d":{}}`},
+
+		// Values
+		{"arrays", "a = [1, 'x', [true], {b = 2},]\nb = [\n  1, # one\n  2,\n]", `{"a":[1,"x",[true],{"b":2}],"b":[1,2]}`},
+		{"inline_tables", "a = {b.c = 1, d = {}}", `{"a":{"b":{"c":1},"d":{}}}`},
+		{"strings", `a = "x\ty\u00e9\"" ` + "\nb = 'c:\\dir'", `{"a":"x\tyé\"","b":"c:\\dir"}`},
+		{"multiline_strings", "a = \"\"\"\nx \\\n   y\"\"\"\nb = '''\nz\n'''", `{"a":"x y","b":"z\n"}`},
+		{"invalid_escape", `a = "\q"`, ""},
+		{"unterminated_string", "a = \"x\nb = 1", ""},
+		{"date", "a = 1979-05-27", ""},
+		{"missing_value", "a =", ""},
+		{"trailing_garbage", "a = 1 2", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := parseToml(test.toml)
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("parseToml(%q) should have failed", test.toml)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseToml(%q) failed: %v", test.toml, err)
+			}
+			got, err := json.Marshal(parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.expected {
+				t.Errorf("parseToml(%q) = %s, expected %s", test.toml, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseTomlSpecialFloats(t *testing.T) {
+	parsed, err := parseToml("a = inf\nb = -inf\nc = nan\nd = +nan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(parsed["a"].(float64), 1) || !math.IsInf(parsed["b"].(float64), -1) {
+		t.Errorf("expected infinities, got %v and %v", parsed["a"], parsed["b"])
+	}
+	if !math.IsNaN(parsed["c"].(float64)) || !math.IsNaN(parsed["d"].(float64)) {
+		t.Errorf("expected NaNs, got %v and %v", parsed["c"], parsed["d"])
+	}
+}
+
+func TestTomlConfigUnknownKey(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{})
+	for _, test := range []struct {
+		name  string
+		toml  string
+		valid bool
+	}{
+		{"known", "global_deps = ['requirements.txt']\n", true},
+		{"unknown", "global_deps = ['requirements.txt']\nnot_a_key = 1\n", false},
+		{"unknown_in_table", "[external_inputs.ext]\nnot_a_key = 1\n", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config_path := filepath.Join(base_dir, test.name+".toml")
+			if err := os.WriteFile(config_path, []byte(test.toml), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err := LoadConfig(config_path, "", nil)
+			if test.valid && err != nil {
+				t.Errorf("loading %q failed: %v", test.toml, err)
+			} else if !test.valid && (err == nil || !strings.Contains(err.Error(), "field not_a_key not found")) {
+				t.Errorf("loading %q should have failed on the unknown key, got: %v", test.toml, err)
+			}
+		})
+	}
+}
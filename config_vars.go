@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// `${NAME}` placeholders in config strings, and `$${` for a literal `${`
+var config_var_parser = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// The names a `${NAME}` placeholder can have
+var config_var_name = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Values of `-define key=value` flags, which may be repeated
+type configDefines map[string]string
+
+func (defines configDefines) String() string {
+	items := []string{}
+	for name, value := range defines {
+		items = append(items, name+"="+value)
+	}
+	slices.Sort(items)
+	return strings.Join(items, ",")
+}
+
+func (defines configDefines) Set(value string) error {
+	name, define_value, ok := strings.Cut(value, "=")
+	if !ok || !config_var_name.MatchString(name) {
+		return fmt.Errorf("invalid define '%s', expected 'NAME=value'", value)
+	}
+	defines[name] = define_value
+	return nil
+}
+
+// Resolves the `${NAME}` placeholders of config files, from the `-define` flags and then the
+// environment. Remembers the values used, since they're part of the config hash.
+type configVars struct {
+	defines configDefines
+	used    map[string]string
+}
+
+func (vars *configVars) expand(value string) (string, error) {
+	var err error
+	expanded := config_var_parser.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		name := match[2 : len(match)-1]
+		resolved, ok := vars.defines[name]
+		if !ok {
+			resolved, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			err = fmt.Errorf("'%s' is neither defined with -define nor set in the environment", name)
+		}
+		vars.used[name] = resolved
+		return resolved
+	})
+	return expanded, err
+}
+
+func (vars *configVars) expandNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		expanded, err := vars.expand(node.Value)
+		if err != nil {
+			return err
+		}
+		if expanded != node.Value && node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			// Plain values are typed by their expanded value (e.g. `${PORT}` as a number)
+			node.Tag = ""
+		}
+		node.Value = expanded
+	}
+	for _, child := range node.Content {
+		if err := vars.expandNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolves the placeholders in the strings of a config file. Files without placeholders are
+// returned as is.
+func (vars *configVars) expandConfig(file_data []byte) ([]byte, error) {
+	if !strings.Contains(string(file_data), "${") {
+		return file_data, nil
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(file_data, &document); err != nil {
+		return nil, err
+	}
+	if err := vars.expandNode(&document); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&document)
+}
+
+// Mixes the values of the placeholders used into the hash of the config files, so each build
+// flavor of a config hashes differently. Configs without placeholders keep the hash of their files.
+func (vars *configVars) hash(files_hash [32]byte) [32]byte {
+	if len(vars.used) == 0 {
+		return files_hash
+	}
+	hasher := sha256.New()
+	hasher.Write(files_hash[:])
+	names := make([]string, 0, len(vars.used))
+	for name := range vars.used {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(hasher, "\x00%s=%s", name, vars.used[name])
+	}
+	var config_hash [32]byte
+	copy(config_hash[:], hasher.Sum(nil))
+	return config_hash
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestConfigDefinesSet(t *testing.T) {
+	for _, test := range []struct {
+		define string
+		valid  bool
+	}{
+		{"NAME=value", true},
+		{"_name9=", true},
+		{"NAME=a=b", true},
+		{"=value", false},
+		{"NAME", false},
+		{"9NAME=value", false},
+		{"A.B=value", false},
+		{"A B=value", false},
+		{"x}${Y=value", false},
+		{"${NAME}=value", false},
+	} {
+		defines := configDefines{}
+		if err := defines.Set(test.define); (err == nil) != test.valid {
+			t.Errorf("Set(%q) = %v, expected valid: %v", test.define, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Returns the other inputs among the recursive dependencies of each input, sorted. Inputs that
+// depend on each other (through an import cycle) have no order, so those pairs are skipped, which
+// leaves no cycles.
+func InputDependencies(file_relation_map map[string][]string, input_files []string) map[string][]string {
+	is_input := map[string]bool{}
+	for _, input := range input_files {
+		is_input[input] = true
+	}
+	reaches := map[string]map[string]bool{}
+	for _, input := range input_files {
+		reaches[input] = map[string]bool{}
+		for _, dep := range BuildFullDepList(file_relation_map, input) {
+			if dep != input && is_input[dep] {
+				reaches[input][dep] = true
+			}
+		}
+	}
+	input_deps := map[string][]string{}
+	for _, input := range input_files {
+		input_deps[input] = []string{}
+		for dep := range reaches[input] {
+			if !reaches[dep][input] {
+				input_deps[input] = append(input_deps[input], dep)
+			}
+		}
+		slices.Sort(input_deps[input])
+	}
+	return input_deps
+}
+
+// Schedules each input as early as its dependency inputs allow, given the duration of each (in
+// seconds, 0 if unknown), and finds the critical path: the chain of inputs that takes the longest,
+// so no number of runners can finish sooner.
+func CalculateSchedule(input_deps map[string][]string, durations map[string]float64) schema.Schedule {
+	inputs := make([]string, 0, len(input_deps))
+	for input := range input_deps {
+		inputs = append(inputs, input)
+	}
+	slices.Sort(inputs)
+
+	schedule := schema.Schedule{Targets: map[string]schema.ScheduledTarget{}}
+	// The dependency each input waits for last, on its critical path
+	waits_for := map[string]string{}
+	var scheduleInput func(input string) float64
+	scheduleInput = func(input string) float64 {
+		if target, ok := schedule.Targets[input]; ok {
+			return target.Finish
+		}
+		start := 0.0
+		for _, dep := range input_deps[input] {
+			// Sorted, so ties wait for the first dependency by name
+			if finish := scheduleInput(dep); waits_for[input] == "" || finish > start {
+				start = finish
+				waits_for[input] = dep
+			}
+		}
+		schedule.Targets[input] = schema.ScheduledTarget{
+			Start:     start,
+			Finish:    start + durations[input],
+			DependsOn: input_deps[input],
+		}
+		return start + durations[input]
+	}
+
+	last := ""
+	for _, input := range inputs {
+		finish := scheduleInput(input)
+		schedule.TotalDuration += durations[input]
+		if last == "" || finish > schedule.Makespan {
+			schedule.Makespan = finish
+			last = input
+		}
+	}
+	for input := last; input != ""; input = waits_for[input] {
+		schedule.CriticalPath = append(schedule.CriticalPath, input)
+	}
+	slices.Reverse(schedule.CriticalPath)
+
+	// The most inputs running at once, ending before starting at equal times
+	type event struct {
+		time  float64
+		delta int
+	}
+	events := []event{}
+	for _, target := range schedule.Targets {
+		if target.Finish > target.Start {
+			events = append(events, event{target.Start, 1}, event{target.Finish, -1})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		return events[i].delta < events[j].delta
+	})
+	running := 0
+	for _, e := range events {
+		running += e.delta
+		schedule.MaxParallelism = max(schedule.MaxParallelism, running)
+	}
+	return schedule
+}
+
+// Reads the durations of inputs (input -> seconds) from a JSON file
+func LoadTargetDurations(path string, input_files []string) (map[string]float64, error) {
+	durations := map[string]float64{}
+	if err := readJsonFile(path, &durations); err != nil {
+		return nil, fmt.Errorf("error while reading target durations: %v", err)
+	}
+	missing := 0
+	for _, input := range input_files {
+		if _, ok := durations[input]; !ok {
+			missing++
+		}
+	}
+	if missing > 0 {
+		log.Printf("Warning: %d inputs have no duration, assuming 0\n", missing)
+	}
+	return durations, nil
+}
+
+// Prints the critical path and what it means for the runner pool
+func PrintSchedule(schedule schema.Schedule) {
+	progress_log.Printf("Critical path (%.1fs): %s\n", schedule.Makespan, strings.Join(schedule.CriticalPath, " -> "))
+	progress_log.Printf("Total duration: %.1fs, at most %d inputs run in parallel\n", schedule.TotalDuration, schedule.MaxParallelism)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Resolves the files of .NET project files (`.csproj` and other MSBuild files): `<ProjectReference>`
+// and `<Import>` projects (which should be matched by the rule too, to follow them transitively),
+// `<Compile>` items (globs, minus `Exclude`/`Remove`), the default `**/*.cs` items of SDK-style
+// projects, and the nearest `Directory.Build.props`/`Directory.Build.targets` MSBuild imports.
+// Paths using properties other than `$(MSBuildThisFileDirectory)`/`$(MSBuildProjectDirectory)`
+// are ignored. C# `using` directives aren't resolved, since namespaces don't map to files.
+type CsprojResolver struct{}
+
+// The parts of an MSBuild file we care about
+type msbuildProject struct {
+	is_sdk                bool
+	default_compile_items bool
+	project_references    []string
+	imports               []string
+	compile_includes      []string
+	compile_excludes      []string
+	compile_removes       []string
+}
+
+func parseMSBuildProject(file_data string) (*msbuildProject, error) {
+	project := &msbuildProject{default_compile_items: true}
+	decoder := xml.NewDecoder(strings.NewReader(file_data))
+	element := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			element = token.Name.Local
+			attrs := map[string]string{}
+			for _, attr := range token.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+			switch element {
+			case "Project":
+				if attrs["Sdk"] != "" {
+					project.is_sdk = true
+				}
+			case "Sdk":
+				project.is_sdk = true
+			case "ProjectReference":
+				project.project_references = append(project.project_references, splitMSBuildList(attrs["Include"])...)
+			case "Import":
+				project.imports = append(project.imports, splitMSBuildList(attrs["Project"])...)
+			case "Compile":
+				project.compile_includes = append(project.compile_includes, splitMSBuildList(attrs["Include"])...)
+				project.compile_excludes = append(project.compile_excludes, splitMSBuildList(attrs["Exclude"])...)
+				project.compile_removes = append(project.compile_removes, splitMSBuildList(attrs["Remove"])...)
+			}
+		case xml.CharData:
+			if element == "EnableDefaultCompileItems" || element == "EnableDefaultItems" {
+				if strings.EqualFold(strings.TrimSpace(string(token)), "false") {
+					project.default_compile_items = false
+				}
+			}
+		case xml.EndElement:
+			element = ""
+		}
+	}
+	return project, nil
+}
+
+// Split an MSBuild item list ("a.cs;b.cs") into paths relative to the project directory, dropping
+// the ones we can't evaluate
+func splitMSBuildList(value string) []string {
+	paths := []string{}
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(strings.ReplaceAll(item, "\\", "/"))
+		for _, property := range []string{"$(MSBuildThisFileDirectory)", "$(MSBuildProjectDirectory)/"} {
+			item = strings.TrimPrefix(item, property)
+		}
+		if item != "" && !strings.Contains(item, "$(") && !strings.Contains(item, "@(") && !filepath.IsAbs(item) {
+			paths = append(paths, item)
+		}
+	}
+	return paths
+}
+
+func (res *CsprojResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	project, err := parseMSBuildProject(file_data)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing MSBuild file: %v", err)
+	}
+	dir := filepath.Dir(file)
+	paths := []string{}
+	addIfExists := func(path string) {
+		path = filepath.Join(dir, path)
+		if !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+	for _, reference := range project.project_references {
+		addIfExists(reference)
+	}
+	for _, imported := range project.imports {
+		addIfExists(imported)
+	}
+
+	// Compile items
+	includes := project.compile_includes
+	if project.is_sdk && project.default_compile_items {
+		includes = append(includes, "**/*.cs")
+	}
+	excludes := slices.Concat(project.compile_excludes, project.compile_removes)
+	if project.is_sdk {
+		excludes = append(excludes, "bin/**", "obj/**")
+	}
+	dir_fs := config.repoFS(filepath.Join(base_dir, dir))
+	for _, include := range includes {
+		if strings.HasPrefix(filepath.Clean(include), "..") {
+			// Linked files outside the project directory
+			addIfExists(include)
+			continue
+		}
+		matches, err := doublestar.Glob(dir_fs, include, doublestar.WithFilesOnly(), doublestar.WithFailOnIOErrors())
+		if err != nil {
+			return nil, fmt.Errorf("error while globbing compile items '%s': %v", include, err)
+		}
+		for _, match := range matches {
+			if excluded, _ := checkExcludePatterns(excludes, match); !excluded {
+				paths = append(paths, filepath.Join(dir, match))
+			}
+		}
+	}
+
+	// MSBuild imports the nearest Directory.Build.props/targets of SDK-style projects implicitly
+	if project.is_sdk {
+		for _, name := range []string{"Directory.Build.props", "Directory.Build.targets"} {
+			for search_dir := dir; ; search_dir = filepath.Dir(search_dir) {
+				candidate := filepath.Join(search_dir, name)
+				if candidate != file && fileExists(config, filepath.Join(base_dir, candidate)) {
+					paths = append(paths, candidate)
+					break
+				}
+				if search_dir == "." {
+					break
+				}
+			}
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v3"
+)
+
+var dbt_call_parser = regexp.MustCompile(`\b(ref|source)\s*\(\s*['"]([^'"]+)['"]\s*(?:,\s*['"]([^'"]+)['"]\s*)?[,)]`)
+
+// The part of a dbt properties file that declares sources
+type dbtProperties struct {
+	Sources []struct {
+		Name string `yaml:"name"`
+	} `yaml:"sources"`
+}
+
+// Resolves `ref()` and `source()` calls in dbt models (SQL or Python) to files under the
+// `dbt_model_dirs`: `ref('model')` (or `ref('package', 'model')`) visits the model, seed or
+// snapshot files named after it, and `source('name', 'table')` visits the properties files
+// declaring that source.
+type DbtResolver struct {
+	// Model name -> files defining it, nil until loaded
+	models map[string][]string
+	// Source name -> properties files declaring it
+	sources map[string][]string
+}
+
+// Index the models and sources in the model dirs
+func (res *DbtResolver) loadProject(config *Config, base_dir string) error {
+	res.models = map[string][]string{}
+	res.sources = map[string][]string{}
+	for _, model_dir := range config.DbtModelDirs.items {
+		files, err := doublestar.Glob(
+			config.repoFS(base_dir),
+			filepath.Join(model_dir, "**/*.{sql,py,csv,yml,yaml}"),
+			doublestar.WithFilesOnly(),
+			doublestar.WithFailOnIOErrors(),
+		)
+		if err != nil {
+			return fmt.Errorf("error while finding dbt models in '%s': %v", model_dir, err)
+		}
+		for _, file := range files {
+			ext := filepath.Ext(file)
+			if ext != ".yml" && ext != ".yaml" {
+				name := strings.TrimSuffix(filepath.Base(file), ext)
+				res.models[name] = append(res.models[name], file)
+				continue
+			}
+
+			data, err := os.ReadFile(filepath.Join(base_dir, file))
+			if err != nil {
+				return fmt.Errorf("error while reading dbt properties '%s': %v", file, err)
+			}
+			var properties dbtProperties
+			if err := yaml.Unmarshal(data, &properties); err != nil {
+				return fmt.Errorf("error while parsing dbt properties '%s': %v", file, err)
+			}
+			for _, source := range properties.Sources {
+				res.sources[source.Name] = append(res.sources[source.Name], file)
+			}
+		}
+	}
+	return nil
+}
+
+func (res *DbtResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.models == nil {
+		if err := res.loadProject(config, base_dir); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := []string{}
+	for _, match := range dbt_call_parser.FindAllStringSubmatch(file_data, -1) {
+		if match[1] == "source" {
+			paths = append(paths, res.sources[match[2]]...)
+			continue
+		}
+		// The two argument form is `ref('package', 'model')`
+		model := match[2]
+		if match[3] != "" {
+			model = match[3]
+		}
+		paths = append(paths, res.models[model]...)
+	}
+	return slices.DeleteFunc(paths, func(path string) bool { return path == file }), nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Everything other than the dependency files that goes into a dependency hash
+type DepHashParams struct {
+	// `ALGORITHM_VERSION`, or the version of another `file_hash_algorithm`
+	AlgorithmVersion uint64
+	ConfigHash       [32]byte
+	Salt             string
+	ToolFingerprint  []byte
+	ExternalInputs   *ExternalInputValues
+	// Experimental, only set with `-experimental-interface-hashes`
+	InterfaceHashes map[string][32]byte
+}
+
+// Calculate the dependency hash of an input file, given its recursive dependency list.
+// Dependencies in `interface_only` are hashed by their interface hash, if they have one.
+func CalculateDepHash(
+	file_name string,
+	dep_list []string,
+	interface_only map[string]bool,
+	fileHashes map[string][32]byte,
+	params *DepHashParams,
+) string {
+	hasher := sha256.New()
+
+	algo_ver := new(bytes.Buffer)
+	binary.Write(algo_ver, binary.LittleEndian, params.AlgorithmVersion)
+
+	hasher.Write(algo_ver.Bytes())
+	hasher.Write([]byte(params.Salt))
+	hasher.Write(params.ConfigHash[:])
+	hasher.Write(params.ToolFingerprint)
+	params.ExternalInputs.HashInto(hasher, file_name)
+	hasher.Write([]byte(file_name))
+
+	for _, dep := range dep_list {
+		hasher.Write([]byte(dep))
+		dep_hash := fileHashes[dep]
+		if interface_hash, ok := params.InterfaceHashes[dep]; ok && interface_only[dep] {
+			dep_hash = interface_hash
+		}
+		hasher.Write(dep_hash[:])
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// Filters edges out of the dependency hash calculation
+type EdgeFilter struct {
+	// Ignore edges whose origins all have these types (e.g. "global", or a rule's `edge_type`)
+	ExcludeEdgeTypes StringOrStringArr `yaml:"exclude_edge_types"`
+	// Ignore edges to files matching these patterns
+	ExcludeDeps StringOrStringArr `yaml:"exclude_deps"`
+	// Ignore edges whose origins are all conditional imports (inside `if`/`try` blocks)
+	ExcludeConditional bool `yaml:"exclude_conditional"`
+	// Ignore edges whose origins are all weak `if TYPE_CHECKING:` imports or lead to type stubs
+	ExcludeTypeOnly bool `yaml:"exclude_type_only"`
+	// Ignore edges whose origins are all optional imports (see `mark_optional_imports`)
+	ExcludeOptional bool `yaml:"exclude_optional"`
+
+	// Follow only edges from files matching these patterns (a target group's targets and scope)
+	scope []string
+}
+
+// Returns whether the edge should be followed when building dependency lists
+func (filter *EdgeFilter) Follows(edge Edge, edge_origins EdgeOrigins) bool {
+	// These patterns were validated when the config was loaded
+	if excluded, _ := checkExcludePatterns(filter.ExcludeDeps.items, edge.To); excluded {
+		return false
+	}
+	if filter.scope != nil {
+		if in_scope, _ := checkExcludePatterns(filter.scope, edge.From); !in_scope {
+			return false
+		}
+	}
+	if len(filter.ExcludeEdgeTypes.items) == 0 && !filter.ExcludeConditional && !filter.ExcludeTypeOnly && !filter.ExcludeOptional {
+		return true
+	}
+	for _, origin := range edge_origins[edge] {
+		if slices.Contains(filter.ExcludeEdgeTypes.items, origin.Type) {
+			continue
+		}
+		if filter.ExcludeConditional && origin.Conditional {
+			continue
+		}
+		if filter.ExcludeTypeOnly && origin.TypeOnly {
+			continue
+		}
+		if filter.ExcludeOptional && origin.Optional {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (filter *EdgeFilter) validate() error {
+	for _, pattern := range filter.ExcludeDeps.items {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid exclude_deps pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// Returns an edge predicate that follows only edges that all of the filters follow
+func combineEdgeFilters(filters []*EdgeFilter, edge_origins EdgeOrigins) func(edge Edge) bool {
+	return func(edge Edge) bool {
+		for _, filter := range filters {
+			if !filter.Follows(edge, edge_origins) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// A group of input files whose dependency hashes ignore some edges
+type TargetGroup struct {
+	Targets StringOrStringArr
+	// Files outside the scope (other than the targets) are leaves: they are hashed, but not
+	// visited for this group, and their dependencies aren't part of its hashes
+	Scope  StringOrStringArr
+	Filter EdgeFilter `yaml:",inline"`
+}
+
+// Returns the edge filters of all target groups the input file belongs to, ordered by group name
+func (config *Config) TargetGroupFilters(file_name string) []*EdgeFilter {
+	names := make([]string, 0, len(config.TargetGroups))
+	for name := range config.TargetGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filters := []*EdgeFilter{}
+	for _, name := range names {
+		group := config.TargetGroups[name]
+		// These patterns were validated when the config was loaded
+		if match, _ := checkExcludePatterns(group.Targets.items, file_name); match {
+			filters = append(filters, &group.Filter)
+		}
+	}
+	return filters
+}
+
+func validateEdgeFilters(config *Config) error {
+	for name, filter := range config.HashEnvironments {
+		if err := filter.validate(); err != nil {
+			return fmt.Errorf("hash environment '%s': %v", name, err)
+		}
+	}
+	for name, group := range config.TargetGroups {
+		for _, pattern := range group.Targets.items {
+			if !doublestar.ValidatePattern(pattern) {
+				return fmt.Errorf("target group '%s': invalid target pattern '%s'", name, pattern)
+			}
+		}
+		if err := group.Filter.validate(); err != nil {
+			return fmt.Errorf("target group '%s': %v", name, err)
+		}
+		if len(group.Scope.items) != 0 {
+			for _, pattern := range group.Scope.items {
+				if !doublestar.ValidatePattern(pattern) {
+					return fmt.Errorf("target group '%s': invalid scope pattern '%s'", name, pattern)
+				}
+			}
+			group.Filter.scope = slices.Concat(group.Targets.items, group.Scope.items)
+			config.TargetGroups[name] = group
+		}
+	}
+	return nil
+}
+
+// Returns the filters of the scoped target groups the input file belongs to, and a key identifying
+// that combination of groups ("" if it isn't in any)
+func (config *Config) TargetScopes(file_name string) (string, []*EdgeFilter) {
+	names := make([]string, 0, len(config.TargetGroups))
+	for name := range config.TargetGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	key := []string{}
+	scopes := []*EdgeFilter{}
+	for _, name := range names {
+		group := config.TargetGroups[name]
+		if group.Filter.scope == nil {
+			continue
+		}
+		// These patterns were validated when the config was loaded
+		if match, _ := checkExcludePatterns(group.Targets.items, file_name); match {
+			key = append(key, name)
+			scopes = append(scopes, &group.Filter)
+		}
+	}
+	return strings.Join(key, ","), scopes
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Resolves the files a Dockerfile depends on: `COPY`/`ADD` sources (globs and directories) in its
+// build context, the context's `.dockerignore`, and the Dockerfiles of local images used in
+// `FROM` and `COPY --from` (see `docker_local_images`).
+type DockerfileResolver struct {
+	// Build context -> its `.dockerignore` patterns, nil if there's none
+	ignore_patterns map[string][]string
+}
+
+// A single instruction of a Dockerfile, with continuation lines joined
+type dockerInstruction struct {
+	command string
+	args    string
+}
+
+func parseDockerfile(file_data string) []dockerInstruction {
+	instructions := []dockerInstruction{}
+	current := ""
+	for _, line := range strings.Split(file_data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if continued, ok := strings.CutSuffix(trimmed, "\\"); ok {
+			current += continued + " "
+			continue
+		}
+		current += trimmed
+		if command, args, ok := strings.Cut(strings.TrimSpace(current), " "); ok {
+			instructions = append(instructions, dockerInstruction{strings.ToUpper(command), strings.TrimSpace(args)})
+		}
+		current = ""
+	}
+	return instructions
+}
+
+// Split instruction arguments into flags (e.g. "--from=x") and the other arguments, which may be
+// in JSON array form
+func splitDockerArgs(args string) (map[string]string, []string) {
+	flags := map[string]string{}
+	fields := strings.Fields(args)
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		name, value, _ := strings.Cut(fields[0][2:], "=")
+		flags[name] = value
+		fields = fields[1:]
+	}
+	rest := strings.Join(fields, " ")
+	if strings.HasPrefix(rest, "[") {
+		var json_args []string
+		if err := json.Unmarshal([]byte(rest), &json_args); err == nil {
+			return flags, json_args
+		}
+	}
+	return flags, fields
+}
+
+// Returns the build context of a Dockerfile
+func dockerBuildContext(file string, config *Config) string {
+	patterns := make([]string, 0, len(config.DockerBuildContexts))
+	for pattern := range config.DockerBuildContexts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		// These patterns were validated when the config was loaded
+		if match, _ := doublestar.Match(pattern, file); match {
+			return filepath.Clean(config.DockerBuildContexts[pattern])
+		}
+	}
+	return filepath.Dir(file)
+}
+
+// Returns the local Dockerfile building an image reference, if any
+func dockerLocalImage(image string, config *Config) (string, bool) {
+	name := image
+	if at := strings.IndexByte(name, '@'); at != -1 {
+		name = name[:at]
+	}
+	// Strip the tag, but not a registry port
+	if colon := strings.LastIndexByte(name, ':'); colon > strings.LastIndexByte(name, '/') {
+		name = name[:colon]
+	}
+	dockerfile, ok := config.DockerLocalImages[name]
+	return dockerfile, ok
+}
+
+func (res *DockerfileResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.ignore_patterns == nil {
+		res.ignore_patterns = map[string][]string{}
+	}
+	context := dockerBuildContext(file, config)
+	ignore_patterns, err := res.dockerignore(context, base_dir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	if ignore_patterns != nil {
+		paths = append(paths, filepath.Join(context, ".dockerignore"))
+	}
+	stages := map[string]bool{}
+	for _, instruction := range parseDockerfile(file_data) {
+		switch instruction.command {
+		case "FROM":
+			_, args := splitDockerArgs(instruction.args)
+			if len(args) == 0 {
+				continue
+			}
+			if len(args) >= 3 && strings.EqualFold(args[1], "AS") {
+				stages[args[2]] = true
+			}
+			if dockerfile, ok := dockerLocalImage(args[0], config); ok && !stages[args[0]] {
+				paths = append(paths, dockerfile)
+			}
+		case "COPY", "ADD":
+			flags, args := splitDockerArgs(instruction.args)
+			if from, ok := flags["from"]; ok {
+				// Copied from another stage or image, not from the context
+				if dockerfile, ok := dockerLocalImage(from, config); ok && !stages[from] {
+					paths = append(paths, dockerfile)
+				}
+				continue
+			}
+			if len(args) < 2 {
+				continue
+			}
+			// The last argument is the destination
+			for _, source := range args[:len(args)-1] {
+				if strings.Contains(source, "://") || strings.HasPrefix(source, "git@") {
+					continue
+				}
+				source_paths, err := res.resolveSource(context, source, ignore_patterns, config, base_dir)
+				if err != nil {
+					return nil, fmt.Errorf("error while resolving '%s %s': %v", instruction.command, source, err)
+				}
+				paths = append(paths, source_paths...)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Returns the files matched by a COPY/ADD source, recursively for directories
+func (res *DockerfileResolver) resolveSource(
+	context string, source string, ignore_patterns []string, config *Config, base_dir string,
+) ([]string, error) {
+	source = filepath.Clean(strings.TrimPrefix(source, "/"))
+	if strings.HasPrefix(source, "..") {
+		return nil, nil
+	}
+	context_fs := config.repoFS(filepath.Join(base_dir, context))
+	matches, err := doublestar.Glob(context_fs, source, doublestar.WithFailOnIOErrors())
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, match := range matches {
+		files := []string{match}
+		if dirExists(config, filepath.Join(base_dir, context, match)) {
+			files, err = doublestar.Glob(context_fs, match+"/**", doublestar.WithFilesOnly(), doublestar.WithFailOnIOErrors())
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			if !isDockerIgnored(file, ignore_patterns) {
+				paths = append(paths, filepath.Join(context, file))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Read the `.dockerignore` patterns of a build context, nil if it has none
+func (res *DockerfileResolver) dockerignore(context string, base_dir string) ([]string, error) {
+	if patterns, ok := res.ignore_patterns[context]; ok {
+		return patterns, nil
+	}
+	var patterns []string
+	data, err := os.ReadFile(filepath.Join(base_dir, context, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error while reading .dockerignore: %v", err)
+	} else if err == nil {
+		patterns = []string{}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+	res.ignore_patterns[context] = patterns
+	return patterns, nil
+}
+
+// Returns whether a path in the context is excluded by `.dockerignore`: the last matching pattern
+// decides, and patterns starting with "!" re-include files
+func isDockerIgnored(path string, ignore_patterns []string) bool {
+	ignored := false
+	for _, pattern := range ignore_patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = filepath.Clean(strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "/"))
+		// A pattern matching a directory excludes everything in it
+		match, _ := doublestar.Match(pattern, path)
+		if !match {
+			match, _ = doublestar.Match(pattern+"/**", path)
+		}
+		if match {
+			ignored = !negated
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// Edge origin types
+const (
+	EDGE_TYPE_RULE      = "rule"
+	EDGE_TYPE_GLOBAL    = "global"
+	EDGE_TYPE_GENERATED = "generated"
+	EDGE_TYPE_FEDERATED = "federated"
+	// Python imports in test files (see `python_test_files`)
+	EDGE_TYPE_TEST = "test"
+)
+
+// How a Python import created an edge
+const (
+	// `import pkg.mod`
+	PYTHON_IMPORT_PLAIN = "import"
+	// `from pkg import mod`
+	PYTHON_IMPORT_FROM = "from_import"
+	// `visit_python_all_submodules_for`
+	PYTHON_IMPORT_SUBMODULES = "submodule_expansion"
+	// `importlib.import_module("pkg.mod")` (see `visit_python_dynamic_imports`)
+	PYTHON_IMPORT_DYNAMIC = "dynamic_import"
+	// A module re-exported by an imported package (see `visit_python_reexports`)
+	PYTHON_IMPORT_RE_EXPORT = "re_export"
+)
+
+// Where an edge in the dependency graph came from
+type EdgeOrigin struct {
+	// What kind of config created the edge, one of the `EDGE_TYPE_*` values
+	Type string
+	// The rule that created the edge, e.g. the path_rule pattern
+	Rule string
+	// Whether the edge came from an import inside an `if`/`try` block (a soft dependency)
+	Conditional bool
+	// Whether only the public interface of the target matters (see `interface_only`)
+	InterfaceOnly bool
+	// Whether the edge came from an `if TYPE_CHECKING:` import tagged as weak (see
+	// `python_type_checking_imports`), or leads to a type stub (see `python_stub_packages`)
+	TypeOnly bool
+	// Whether the edge came from a `try`/`except ImportError` import marked as optional (see
+	// `mark_optional_imports`)
+	Optional bool
+	// How a Python import created the edge, one of the `PYTHON_IMPORT_*` values (empty for edges that
+	// didn't come from an import)
+	PythonImport string
+}
+
+// A single edge in the dependency graph: `From` depends on `To`
+type Edge struct {
+	From string
+	To   string
+}
+
+// The origins of each edge in the dependency graph
+type EdgeOrigins map[Edge][]EdgeOrigin
+
+// Adds an origin to an edge, keeping the origins sorted and unique
+func (origins EdgeOrigins) Add(edge Edge, origin EdgeOrigin) {
+	edge_origins := origins[edge]
+	idx, found := slices.BinarySearchFunc(edge_origins, origin, compareEdgeOrigins)
+	if !found {
+		origins[edge] = slices.Insert(edge_origins, idx, origin)
+	}
+}
+
+// Returns all edges, sorted
+func (origins EdgeOrigins) SortedEdges() []Edge {
+	edges := make([]Edge, 0, len(origins))
+	for edge := range origins {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+func compareEdgeOrigins(a, b EdgeOrigin) int {
+	if c := cmp.Compare(a.Type, b.Type); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Rule, b.Rule); c != 0 {
+		return c
+	}
+	if c := compareBools(a.Conditional, b.Conditional); c != 0 {
+		return c
+	}
+	if c := compareBools(a.InterfaceOnly, b.InterfaceOnly); c != 0 {
+		return c
+	}
+	if c := compareBools(a.TypeOnly, b.TypeOnly); c != 0 {
+		return c
+	}
+	if c := compareBools(a.Optional, b.Optional); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.PythonImport, b.PythonImport)
+}
+
+func compareBools(a, b bool) int {
+	if a == b {
+		return 0
+	} else if a {
+		return 1
+	}
+	return -1
+}
+
+// The direct dependencies of a single file, and where each of them came from
+type FileRelations struct {
+	paths   []string
+	origins map[string][]EdgeOrigin
+}
+
+// Adds paths with an origin. The paths are copied, so shared slices (like `global_deps` from the
+// config) may be passed, and later changes to the relations don't write into them.
+func (relations *FileRelations) Add(origin EdgeOrigin, paths ...string) {
+	if relations.origins == nil {
+		relations.origins = map[string][]EdgeOrigin{}
+	}
+	for _, path := range paths {
+		// Resolvers join paths with the OS separator, outputs always use forward slashes
+		path = filepath.ToSlash(path)
+		relations.paths = append(relations.paths, path)
+		relations.origins[path] = append(relations.origins[path], origin)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func writeTestRepo(t testing.TB, files map[string]string) string {
+	t.Helper()
+	base_dir := t.TempDir()
+	for path, data := range files {
+		full_path := filepath.Join(base_dir, path)
+		if err := os.MkdirAll(filepath.Dir(full_path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full_path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base_dir
+}
+
+func loadTestConfig(t testing.TB, base_dir string, config_data string) *Config {
+	t.Helper()
+	config_path := filepath.Join(base_dir, "repo_dagger.yaml")
+	if err := os.WriteFile(config_path, []byte(config_data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := LoadConfig(config_path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+const aliasingTestConfig = `
+global_deps: [requirements.txt, setup.cfg]
+root_python_packages: [pkg]
+path_rules:
+  "pkg/**/*.py":
+    visit_imported_python_modules: true
+`
+
+var aliasingTestFiles = map[string]string{
+	"requirements.txt":  "",
+	"setup.cfg":         "",
+	"pkg/__init__.py":   "",
+	"pkg/common.py":     "",
+	"pkg/one.py":        "from pkg import common\n",
+	"pkg/two.py":        "from pkg import common\n",
+	"pkg/three.py":      "import pkg.common\n",
+	"pkg/four.py":       "import pkg.common\n",
+	"pkg/extra/five.py": "",
+}
+
+// Visits a file the way `VisitRecursively` does, starting from the `global_deps`
+func visitTestFile(file string, resolvers *Resolvers, config *Config, base_dir string) (*FileRelations, error) {
+	file_relations := &FileRelations{}
+	file_relations.Add(EdgeOrigin{Type: EDGE_TYPE_GLOBAL}, config.GlobalDeps.items...)
+	err := visitFile(file, file_relations, resolvers, map[string]*regexp.Regexp{}, config, &Args{}, base_dir)
+	return file_relations, err
+}
+
+// Appending to the relations of one file must not write into the `global_deps` or the resolver
+// cache, which the relations of every other file start from
+func TestFileRelationsDontAlias(t *testing.T) {
+	base_dir := writeTestRepo(t, aliasingTestFiles)
+	config := loadTestConfig(t, base_dir, aliasingTestConfig)
+	// Spare capacity, so an append to an aliased slice would go unnoticed by its length
+	config.GlobalDeps.items = append(make([]string, 0, 16), config.GlobalDeps.items...)
+	global_deps := slices.Clone(config.GlobalDeps.items[:cap(config.GlobalDeps.items)])
+
+	resolvers := NewResolvers(config, base_dir)
+	one, err := visitTestFile("pkg/one.py", resolvers, config, base_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Resolved from the resolver cache filled by the first file
+	two, err := visitTestFile("pkg/two.py", resolvers, config, base_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two_paths := slices.Clone(two.paths)
+	cached := resolvers.python.cache["pkg.common"]
+	if cached == nil {
+		t.Fatal("pkg.common isn't in the resolver cache")
+	}
+	cached_paths := slices.Clone(cached.Paths[:cap(cached.Paths)])
+	if !slices.Contains(two_paths, "pkg/common.py") || !slices.Contains(two_paths, "requirements.txt") {
+		t.Fatalf("unexpected relations of pkg/two.py: %v", two_paths)
+	}
+
+	one.Add(EdgeOrigin{Type: EDGE_TYPE_RULE}, "pkg/extra/five.py")
+	one.paths = append(one.paths, "overwritten")
+	for i := range one.paths {
+		one.paths[i] = "overwritten"
+	}
+
+	if !slices.Equal(two.paths, two_paths) {
+		t.Errorf("relations of pkg/two.py changed: %v, expected %v", two.paths, two_paths)
+	}
+	if !slices.Equal(config.GlobalDeps.items[:cap(config.GlobalDeps.items)], global_deps) {
+		t.Errorf("global_deps changed: %v, expected %v", config.GlobalDeps.items, global_deps)
+	}
+	if !slices.Equal(cached.Paths[:cap(cached.Paths)], cached_paths) {
+		t.Errorf("cached resolution of pkg.common changed: %v, expected %v", cached.Paths, cached_paths)
+	}
+}
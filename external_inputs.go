@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// A value from outside the repo that affects the dependency hashes, e.g. a toolchain version.
+// Exactly one of `Command`, `Env` and `Value` should be set.
+type ExternalInput struct {
+	// Shell command whose output is captured (ran in the base directory)
+	Command string
+	// Environment variable to capture
+	Env string
+	// Literal value
+	Value string
+	// Only mix this input into the hashes of inputs matching these patterns (default: all)
+	Targets StringOrStringArr
+}
+
+type capturedExternalInput struct {
+	name    string
+	hash    [32]byte
+	targets []string
+}
+
+type ExternalInputValues struct {
+	inputs []capturedExternalInput
+}
+
+// Capture the values of all `external_inputs` in the config
+func CaptureExternalInputs(config *Config, base_dir string) (*ExternalInputValues, error) {
+	names := make([]string, 0, len(config.ExternalInputs))
+	for name := range config.ExternalInputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := &ExternalInputValues{}
+	for _, name := range names {
+		input := config.ExternalInputs[name]
+		value, err := input.capture(base_dir)
+		if err != nil {
+			return nil, fmt.Errorf("error while capturing external input '%s': %v", name, err)
+		}
+		out.inputs = append(out.inputs, capturedExternalInput{
+			name:    name,
+			hash:    sha256.Sum256(value),
+			targets: input.Targets.items,
+		})
+	}
+	return out, nil
+}
+
+// Checks that exactly one of `command`, `env` and `value` is set
+func (input *ExternalInput) validateSource() error {
+	set_count := 0
+	for _, field := range []string{input.Command, input.Env, input.Value} {
+		if field != "" {
+			set_count++
+		}
+	}
+	if set_count != 1 {
+		return fmt.Errorf("exactly one of 'command', 'env' and 'value' must be set")
+	}
+	return nil
+}
+
+// Capture the value of the input (validated when the config was loaded)
+func (input *ExternalInput) capture(base_dir string) ([]byte, error) {
+	switch {
+	case input.Command != "":
+		cmd := exec.Command("sh", "-c", input.Command)
+		cmd.Dir = base_dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("command '%s' failed: %v: %s", input.Command, err, output)
+		}
+		return output, nil
+	case input.Env != "":
+		return []byte(os.Getenv(input.Env)), nil
+	default:
+		return []byte(input.Value), nil
+	}
+}
+
+// Write the external inputs that apply to the given input file into the hasher
+func (values *ExternalInputValues) HashInto(hasher io.Writer, file_name string) {
+	for _, input := range values.inputs {
+		if len(input.targets) != 0 {
+			// These patterns were validated when the config was loaded
+			matched, _ := checkExcludePatterns(input.targets, file_name)
+			if !matched {
+				continue
+			}
+		}
+		hasher.Write([]byte(input.name))
+		hasher.Write(input.hash[:])
+	}
+}
+
+// The captured values, for a graph snapshot
+func (values *ExternalInputValues) Snapshot() []schema.SnapshotExternalInput {
+	snapshot := []schema.SnapshotExternalInput{}
+	for _, input := range values.inputs {
+		snapshot = append(snapshot, schema.SnapshotExternalInput{
+			Name:    input.name,
+			Hash:    fmt.Sprintf("%x", input.hash),
+			Targets: input.targets,
+		})
+	}
+	return snapshot
+}
+
+// Restore the captured values from a graph snapshot
+func externalInputsFromSnapshot(snapshot []schema.SnapshotExternalInput) (*ExternalInputValues, error) {
+	values := &ExternalInputValues{}
+	for _, input := range snapshot {
+		hash, err := decodeHexHash(input.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("external input '%s': %v", input.Name, err)
+		}
+		for _, target := range input.Targets {
+			if !doublestar.ValidatePattern(target) {
+				return nil, fmt.Errorf("external input '%s' has an invalid target pattern '%s'", input.Name, target)
+			}
+		}
+		values.inputs = append(values.inputs, capturedExternalInput{
+			name:    input.Name,
+			hash:    hash,
+			targets: input.Targets,
+		})
+	}
+	return values, nil
+}
+
+func validateExternalInputs(config *Config) error {
+	for name, input := range config.ExternalInputs {
+		if err := input.validateSource(); err != nil {
+			return fmt.Errorf("external input '%s': %v", name, err)
+		}
+		for _, target := range input.Targets.items {
+			if !doublestar.ValidatePattern(target) {
+				return fmt.Errorf("external input '%s' has an invalid target pattern '%s'", name, target)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExternalInputSources(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{})
+	for _, test := range []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"command", "command: 'python --version'", true},
+		{"env", "env: PYTHON_VERSION", true},
+		{"value", "value: '3.12'", true},
+		{"none", "targets: 'tests/**'", false},
+		{"command_and_env", "command: 'python --version'\n    env: PYTHON_VERSION", false},
+		{"env_and_value", "env: PYTHON_VERSION\n    value: '3.12'", false},
+		{"all", "command: 'python --version'\n    env: PYTHON_VERSION\n    value: '3.12'", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config_path := filepath.Join(base_dir, test.name+".yaml")
+			config_data := "external_inputs:\n  python:\n    " + test.input + "\n"
+			if err := os.WriteFile(config_path, []byte(config_data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err := LoadConfig(config_path, "", nil)
+			if test.valid && err != nil {
+				t.Errorf("loading %q failed: %v", config_data, err)
+			} else if !test.valid && (err == nil || !strings.Contains(err.Error(), "exactly one of")) {
+				t.Errorf("loading %q should have failed, got: %v", config_data, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// A dependency graph exported by another repository (using `-out-relations` and
+// `-out-file-hashes`), whose files are linked into this graph under a path prefix
+type FederatedRepo struct {
+	// Prepended to every path in the other repository, e.g. "@api/"
+	Prefix string
+	// Path of the exported relations, relative to the config's directory
+	Relations string
+	// Path of the exported file hashes, relative to the config's directory
+	FileHashes string `yaml:"file_hashes"`
+	// The `file_hash_algorithm` of the other repository, which must be this config's (default:
+	// "sha256"), since hashes of different algorithms can't be told apart in the artifacts
+	FileHashAlgorithm string `yaml:"file_hash_algorithm"`
+}
+
+type FederatedGraphs struct {
+	nodes       []string
+	relations   map[string][]string
+	file_hashes map[string][32]byte
+}
+
+func validateFederation(config *Config) error {
+	prefixes := map[string]string{}
+	for name, repo := range config.FederatedRepos {
+		if repo.Prefix == "" || repo.Relations == "" || repo.FileHashes == "" {
+			return fmt.Errorf("federated repo '%s' must set 'prefix', 'relations' and 'file_hashes'", name)
+		}
+		if other, ok := prefixes[repo.Prefix]; ok {
+			return fmt.Errorf("federated repos '%s' and '%s' share the same prefix", name, other)
+		}
+		prefixes[repo.Prefix] = name
+		algorithm := repo.FileHashAlgorithm
+		if algorithm == "" {
+			algorithm = "sha256"
+		}
+		if _, ok := fileHashAlgorithms[algorithm]; !ok {
+			return fmt.Errorf("invalid file_hash_algorithm '%s' of federated repo '%s'", algorithm, name)
+		}
+		if algorithm != config.fileHashAlgorithm().name {
+			return fmt.Errorf(
+				"federated repo '%s' is hashed with %s, but this config's file_hash_algorithm is %s",
+				name, algorithm, config.fileHashAlgorithm().name,
+			)
+		}
+	}
+	for pattern, deps := range config.CrossRepoDeps {
+		for _, p := range append([]string{pattern}, deps.items...) {
+			if !doublestar.ValidatePattern(p) {
+				return fmt.Errorf("invalid cross_repo_deps pattern '%s'", p)
+			}
+		}
+	}
+	return nil
+}
+
+func readJsonFile(path string, value any) error {
+	file_data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(file_data, value)
+}
+
+// Decode a hex hash of a `file_hash_algorithm`, as written in the artifacts
+func decodeHexHash(hex_hash string) ([32]byte, error) {
+	var hash [32]byte
+	decoded, err := hex.DecodeString(hex_hash)
+	if err != nil || len(decoded) != len(hash) {
+		return hash, fmt.Errorf("invalid hash '%s'", hex_hash)
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// Load the graphs of all `federated_repos`, with their paths prefixed. Fails if a prefixed path
+// (or the prefix itself, like "@api/") is a local path, since the graphs would overwrite each other.
+func LoadFederatedGraphs(config *Config, config_dir string, base_dir string) (*FederatedGraphs, error) {
+	graphs := &FederatedGraphs{
+		relations:   map[string][]string{},
+		file_hashes: map[string][32]byte{},
+	}
+	for name, repo := range config.FederatedRepos {
+		if prefix_dir, ok := strings.CutSuffix(repo.Prefix, "/"); ok && pathExists(config, filepath.Join(base_dir, prefix_dir)) {
+			return nil, fmt.Errorf("prefix '%s' of federated repo '%s' is a local path", repo.Prefix, name)
+		}
+		relations := schema.Relations{}
+		err := readJsonFile(filepath.Join(config_dir, repo.Relations), &relations)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading relations of federated repo '%s': %v", name, err)
+		}
+		for file, deps := range relations {
+			prefixed_deps := make([]string, 0, len(deps))
+			for _, dep := range deps {
+				prefixed_deps = append(prefixed_deps, repo.Prefix+dep)
+			}
+			if pathExists(config, filepath.Join(base_dir, repo.Prefix+file)) {
+				return nil, fmt.Errorf("file '%s' of federated repo '%s' is a local path", repo.Prefix+file, name)
+			}
+			graphs.relations[repo.Prefix+file] = prefixed_deps
+		}
+
+		file_hashes := schema.FileHashes{}
+		err = readJsonFile(filepath.Join(config_dir, repo.FileHashes), &file_hashes)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading file hashes of federated repo '%s': %v", name, err)
+		}
+		for file, hex_hash := range file_hashes {
+			file_hash, err := decodeHexHash(hex_hash)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hash of '%s' in federated repo '%s'", file, name)
+			}
+			graphs.file_hashes[repo.Prefix+file] = file_hash
+		}
+	}
+
+	for node := range graphs.relations {
+		graphs.nodes = append(graphs.nodes, node)
+	}
+	sort.Strings(graphs.nodes)
+	return graphs, nil
+}
+
+// Add the `cross_repo_deps` edges to the local files, and merge the federated graphs into the
+// relations map. Fails if a federated file is also a local one.
+func (graphs *FederatedGraphs) Link(
+	file_relation_map map[string][]string,
+	edge_origins EdgeOrigins,
+	config *Config,
+) error {
+	if len(graphs.nodes) == 0 {
+		return nil
+	}
+	for _, file := range graphs.nodes {
+		if _, ok := file_relation_map[file]; ok {
+			return fmt.Errorf("federated file '%s' is also a local file", file)
+		}
+	}
+	for file, deps := range file_relation_map {
+		linked := []string{}
+		for pattern, federated_deps := range config.CrossRepoDeps {
+			// The patterns were validated when the config was loaded
+			if match, _ := doublestar.Match(pattern, file); !match {
+				continue
+			}
+			for _, node := range graphs.nodes {
+				if match, _ := checkExcludePatterns(federated_deps.items, node); match {
+					linked = append(linked, node)
+					edge_origins.Add(
+						Edge{From: file, To: node},
+						EdgeOrigin{Type: EDGE_TYPE_FEDERATED, Rule: pattern},
+					)
+				}
+			}
+		}
+		if len(linked) != 0 {
+			deps = append(slices.Clone(deps), linked...)
+			slices.Sort(deps)
+			file_relation_map[file] = slices.Compact(deps)
+		}
+	}
+	for file, deps := range graphs.relations {
+		file_relation_map[file] = deps
+		for _, dep := range deps {
+			edge_origins.Add(Edge{From: file, To: dep}, EdgeOrigin{Type: EDGE_TYPE_FEDERATED})
+		}
+	}
+	return nil
+}
+
+// Add the hashes of the federated files to the file hashes
+func (graphs *FederatedGraphs) MergeFileHashes(fileHashes map[string][32]byte) {
+	for file, file_hash := range graphs.file_hashes {
+		fileHashes[file] = file_hash
+	}
+}
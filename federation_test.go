@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFederationHashAlgorithm(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{})
+	for _, test := range []struct {
+		name      string
+		algorithm string
+		federated string
+		error     string
+	}{
+		{"default", "", "", ""},
+		{"same", "sha512_256", "sha512_256", ""},
+		{"declared_default", "sha256", "sha256", ""},
+		{"other_declared", "", "sha512_256", "hashed with sha512_256"},
+		{"other_default", "sha512_256", "", "hashed with sha256"},
+		{"invalid", "", "md5", "invalid file_hash_algorithm"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config_path := filepath.Join(base_dir, test.name+".yaml")
+			config_data := fmt.Sprintf(`
+file_hash_algorithm: "%s"
+federated_repos:
+  api:
+    prefix: "@api/"
+    relations: api/relations.json
+    file_hashes: api/file_hashes.json
+    file_hash_algorithm: "%s"
+`, test.algorithm, test.federated)
+			if err := os.WriteFile(config_path, []byte(config_data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err := LoadConfig(config_path, "", nil)
+			if test.error == "" && err != nil {
+				t.Errorf("loading failed: %v", err)
+			} else if test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+				t.Errorf("expected an error containing %q, got: %v", test.error, err)
+			}
+		})
+	}
+}
+
+func TestFederationLocalCollision(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		prefix string
+		files  map[string]string
+		error  string
+	}{
+		{"separate", "@api/", map[string]string{"b.py": ""}, ""},
+		{"prefix_dir", "@api/", map[string]string{"@api/other.py": ""}, "prefix '@api/' of federated repo 'api' is a local path"},
+		{"prefix_file", "@api/", map[string]string{"@api": ""}, "prefix '@api/' of federated repo 'api' is a local path"},
+		{"prefixed_file", "api_", map[string]string{"api_a.py": ""}, "file 'api_a.py' of federated repo 'api' is a local path"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.files["api/relations.json"] = `{"a.py": []}`
+			test.files["api/file_hashes.json"] = `{}`
+			base_dir := writeTestRepo(t, test.files)
+			config := loadTestConfig(t, base_dir, fmt.Sprintf(`
+federated_repos:
+  api:
+    prefix: "%s"
+    relations: api/relations.json
+    file_hashes: api/file_hashes.json
+`, test.prefix))
+			_, err := LoadFederatedGraphs(config, base_dir, base_dir)
+			if test.error == "" && err != nil {
+				t.Errorf("loading failed: %v", err)
+			} else if test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+				t.Errorf("expected an error containing %q, got: %v", test.error, err)
+			}
+		})
+	}
+}
+
+func TestFederationLinkCollision(t *testing.T) {
+	graphs := &FederatedGraphs{
+		nodes:     []string{"@api/a.py"},
+		relations: map[string][]string{"@api/a.py": {}},
+	}
+	// e.g. a generated file, which doesn't exist locally
+	file_relation_map := map[string][]string{"b.py": {"@api/a.py"}, "@api/a.py": {"b.py"}}
+	if err := graphs.Link(file_relation_map, EdgeOrigins{}, &Config{}); err == nil {
+		t.Error("expected an error linking a federated file that is also a local file")
+	}
+	if !slices.Equal(file_relation_map["@api/a.py"], []string{"b.py"}) {
+		t.Errorf("the local relations were overwritten: %v", file_relation_map["@api/a.py"])
+	}
+}
@@ -1,24 +1,152 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // ctx, fileHashes, all_files_set, base_dir
 func CalculateFileHashes(
 	fileHashes map[string][32]byte,
 	all_files_set map[string]bool,
+	config *Config,
 	base_dir string,
 ) {
+	// Files are handed to the workers in batches, and each worker reuses its hasher and buffer, so
+	// hashing many small files isn't dominated by per-file overhead
+	batches := make(chan []string)
+	hashes_lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < config.readConcurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasher := config.fileHashAlgorithm().new()
+			buf := make([]byte, HASH_BUFFER_SIZE)
+			batch_hashes := map[string][32]byte{}
+			for batch := range batches {
+				for _, file_name := range batch {
+					file_hash, err := hashRepoFile(config, filepath.Join(base_dir, file_name), hasher, buf)
+					if err != nil {
+						if err := config.addUnreadableFile(file_name, err); err != nil {
+							log.Fatalf("Error while hashing file: %v", err)
+						}
+						// Random, so the dependents' hashes never match a previous run
+						rand.Read(file_hash[:])
+					}
+					batch_hashes[file_name] = file_hash
+				}
+				hashes_lock.Lock()
+				maps.Copy(fileHashes, batch_hashes)
+				hashes_lock.Unlock()
+				clear(batch_hashes)
+			}
+		}()
+	}
+	abstract_nodes := []string{}
+	batch := make([]string, 0, HASH_BATCH_SIZE)
 	for file_name := range all_files_set {
-		file_path := filepath.Join(base_dir, file_name)
-		file_data_bytes, err := os.ReadFile(file_path)
+		if _, _, ok := config.GeneratedSources(file_name); ok {
+			// Generated files are hashed through their sources, they may not exist locally
+			continue
+		}
+		if isAbstractNode(file_name) {
+			abstract_nodes = append(abstract_nodes, file_name)
+			continue
+		}
+		batch = append(batch, file_name)
+		if len(batch) == HASH_BATCH_SIZE {
+			batches <- batch
+			batch = make([]string, 0, HASH_BATCH_SIZE)
+		}
+	}
+	if len(batch) != 0 {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+
+	for _, node := range abstract_nodes {
+		value, err := abstractNodeValue(node, config, base_dir)
 		if err != nil {
-			log.Fatalf("Error while reading file '%s': %v", file_path, err)
+			log.Fatalf("Error while hashing abstract node '%s': %v", node, err)
 		}
-		fileHashes[file_name] = sha256.Sum256(file_data_bytes)
+		hasher := config.fileHashAlgorithm().new()
+		hasher.Write(value)
+		fileHashes[node] = [32]byte(hasher.Sum(nil))
+	}
+}
+
+// A hash function of `file_hash_algorithm`, whose digests are 32 bytes
+type fileHashAlgorithm struct {
+	// Also its name in in-toto digests
+	name    string
+	new     func() hash.Hash
+	version uint64
+}
+
+// SHA-256 is hardware accelerated on most CPUs (SHA-NI, ARMv8), SHA-512/256 is faster without it
+var fileHashAlgorithms = map[string]fileHashAlgorithm{
+	"sha256":     {name: "sha256", new: sha256.New, version: ALGORITHM_VERSION},
+	"sha512_256": {name: "sha512_256", new: sha512.New512_256, version: ALGORITHM_VERSION_SHA512_256},
+}
+
+// The configured `file_hash_algorithm` (validated when the config was loaded)
+func (config *Config) fileHashAlgorithm() fileHashAlgorithm {
+	if config.FileHashAlgorithm == "" {
+		return fileHashAlgorithms["sha256"]
+	}
+	return fileHashAlgorithms[config.FileHashAlgorithm]
+}
+
+// The number of files handed to a hashing worker at once
+const HASH_BATCH_SIZE = 64
+
+// The read buffer of each hashing worker
+const HASH_BUFFER_SIZE = 64 * 1024
+
+// Hash a file of the repo by streaming it through `hasher` (reset first), retrying transient errors
+func hashRepoFile(config *Config, path string, hasher hash.Hash, buf []byte) ([32]byte, error) {
+	return withIORetries(config, func() ([32]byte, error) {
+		var file_hash [32]byte
+		hasher.Reset()
+		f, err := os.Open(path)
+		if err != nil {
+			return file_hash, err
+		}
+		defer f.Close()
+		for {
+			n, err := f.Read(buf)
+			hasher.Write(buf[:n])
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return file_hash, err
+			}
+		}
+		hasher.Sum(file_hash[:0])
+		return file_hash, nil
+	})
+}
+
+// Hash the currently running repo_dagger binary, to act as its build ID
+func HashOwnBinary() ([32]byte, error) {
+	exe_path, err := os.Executable()
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to locate executable: %w", err)
+	}
+	exe_data, err := os.ReadFile(exe_path)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to read executable: %w", err)
 	}
+	return sha256.Sum256(exe_data), nil
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The batched and streamed hashes must be the plain hashes of the files
+func TestCalculateFileHashes(t *testing.T) {
+	files := map[string]string{
+		"empty.txt": "",
+		"large.bin": strings.Repeat("0123456789abcdef", 3*HASH_BUFFER_SIZE/16+5),
+	}
+	for i := 0; i < 3*HASH_BATCH_SIZE+1; i++ {
+		files[fmt.Sprintf("pkg/file%d.py", i)] = fmt.Sprintf("def f():\n    return %d\n", i)
+	}
+	all_files_set := map[string]bool{}
+	for file := range files {
+		all_files_set[file] = true
+	}
+	base_dir := writeTestRepo(t, files)
+
+	for _, test := range []struct {
+		algorithm string
+		sum       func(data []byte) [32]byte
+	}{
+		{"sha256", sha256.Sum256},
+		{"sha512_256", sha512.Sum512_256},
+	} {
+		for _, concurrency := range []int{1, 4} {
+			config := loadTestConfig(t, base_dir, fmt.Sprintf(
+				"read_concurrency: %d\nfile_hash_algorithm: %s\n", concurrency, test.algorithm,
+			))
+			fileHashes := map[string][32]byte{}
+			CalculateFileHashes(fileHashes, all_files_set, config, base_dir)
+			if len(fileHashes) != len(files) {
+				t.Errorf("%s: hashed %d files, expected %d", test.algorithm, len(fileHashes), len(files))
+			}
+			for file := range files {
+				data, err := os.ReadFile(filepath.Join(base_dir, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fileHashes[file] != test.sum(data) {
+					t.Errorf("%s with %d readers: wrong hash of '%s'", test.algorithm, concurrency, file)
+				}
+			}
+		}
+	}
+}
+
+// Many small files, where per-file overhead dominates the hashing itself
+func BenchmarkCalculateFileHashes(b *testing.B) {
+	files := map[string]string{}
+	all_files_set := map[string]bool{}
+	for i := 0; i < 2000; i++ {
+		file := fmt.Sprintf("pkg/mod%d/file%d.py", i%50, i)
+		files[file] = fmt.Sprintf("import os\n\n\ndef f%d():\n    return %d\n", i, i)
+		all_files_set[file] = true
+	}
+	base_dir := writeTestRepo(b, files)
+
+	for _, bench := range []struct {
+		name        string
+		concurrency int
+		algorithm   string
+	}{
+		{"sequential", 1, "sha256"},
+		{"parallel", 0, "sha256"},
+		{"parallel_sha512_256", 0, "sha512_256"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			config := loadTestConfig(b, base_dir, fmt.Sprintf(
+				"read_concurrency: %d\nfile_hash_algorithm: %s\n", bench.concurrency, bench.algorithm,
+			))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				CalculateFileHashes(map[string][32]byte{}, all_files_set, config, base_dir)
+			}
+		})
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 	"regexp"
 	"slices"
@@ -12,9 +11,188 @@ import (
 	"github.com/bmatcuk/doublestar/v4"
 )
 
-var python_import_parser_simple = regexp.MustCompile(`(?m:^ *import ([^ \n]+)( as [A-Za-z_][A-Za-z0-9_]*)?)`)
-var python_import_parser_from = regexp.MustCompile(`(?m:^ *from ([^ \n]+) import (\([^)]+\)|[^\n]+))`)
-var python_import_parser_ident = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)( as [A-Za-z_][A-Za-z0-9_]*)?`)
+var cython_include_parser = regexp.MustCompile(`(?m:^[ \t]*include[ \t]+["']([^"'\n]+\.pxi)["'])`)
+var python_dynamic_import_parser = regexp.MustCompile(`\b(?:import_module|__import__)\(\s*["']([A-Za-z_][A-Za-z0-9_.]*)["']`)
+
+type pythonImport struct {
+	module string
+	// Inside an `if`/`try` block, so it might not be imported at runtime
+	conditional bool
+	// Inside an `if TYPE_CHECKING:` block, so it's only imported by type checkers
+	type_only bool
+	// Inside a `try`/`except ImportError` block, so the program works without it
+	optional bool
+	// Offset of the import in the file
+	offset int
+	// A name of `from ... import` or a re-exported module, which might not be a module at all
+	implied bool
+	// How the module is imported, one of the `PYTHON_IMPORT_*` values
+	kind string
+}
+
+// Values of `python_type_checking_imports`
+const (
+	TYPE_CHECKING_IMPORTS_INCLUDE = "include"
+	TYPE_CHECKING_IMPORTS_EXCLUDE = "exclude"
+	TYPE_CHECKING_IMPORTS_WEAK    = "weak"
+)
+
+// Returns whether the line at `offset` is nested in an `if` or `try` statement (including their
+// other branches), by looking for a less indented line starting such a block
+func isInConditionalBlock(data string, offset int) bool {
+	line_start := strings.LastIndexByte(data[:offset], '\n') + 1
+	line := data[line_start:]
+	indent := len(line) - len(strings.TrimLeft(line, " \t"))
+	for indent > 0 && line_start > 0 {
+		line_end := line_start - 1
+		line_start = strings.LastIndexByte(data[:line_end], '\n') + 1
+		line = data[line_start:line_end]
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.TrimSpace(trimmed) == "" || trimmed[0] == '#' || len(line)-len(trimmed) >= indent {
+			continue
+		}
+		indent = len(line) - len(trimmed)
+		keyword, _, _ := strings.Cut(strings.TrimRight(strings.Fields(trimmed)[0], ":"), "(")
+		switch keyword {
+		case "if", "elif", "else", "try", "except", "finally":
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the line at `offset` is nested in an `if TYPE_CHECKING:` block (not its `else`)
+func isInTypeCheckingBlock(data string, offset int) bool {
+	line_start := strings.LastIndexByte(data[:offset], '\n') + 1
+	line := data[line_start:]
+	indent := len(line) - len(strings.TrimLeft(line, " \t"))
+	for indent > 0 && line_start > 0 {
+		line_end := line_start - 1
+		line_start = strings.LastIndexByte(data[:line_end], '\n') + 1
+		line = data[line_start:line_end]
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.TrimSpace(trimmed) == "" || trimmed[0] == '#' || len(line)-len(trimmed) >= indent {
+			continue
+		}
+		indent = len(line) - len(trimmed)
+		if condition, ok := strings.CutPrefix(strings.TrimSpace(trimmed), "if "); ok {
+			condition = strings.TrimSpace(strings.TrimSuffix(condition, ":"))
+			if condition == "TYPE_CHECKING" || condition == "typing.TYPE_CHECKING" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether an `except` clause catches a failed import
+func catchesImportError(except_line string) bool {
+	return strings.Contains(except_line, "ImportError") || strings.Contains(except_line, "ModuleNotFoundError")
+}
+
+// The byte ranges of a Python file nested in a `try` block with an `except ImportError` (or
+// `ModuleNotFoundError`) clause, or in such a clause, like alternative backends are imported.
+// Sorted and disjoint, so each import's offset is looked up by binary search.
+type importErrorBlocks [][2]int
+
+// Finds the `try` blocks and `except` clauses of a file in one pass over its lines, tracking the
+// blocks each line is nested in by indentation
+func findImportErrorBlocks(data string) importErrorBlocks {
+	type openBlock struct {
+		indent   int
+		keyword  string
+		catches  bool
+		body_end int
+	}
+	// The enclosing blocks of the current line, innermost last, with their bodies starting after
+	// `body_end` (the end of their line)
+	open := []openBlock{}
+	// `try` blocks whose bodies ended, while their `except`, `else` and `finally` clauses follow
+	type tryClauses struct {
+		indent  int
+		body    [2]int
+		catches bool
+	}
+	pending := []tryClauses{}
+	blocks := importErrorBlocks{}
+	closeBlocks := func(indent int, pos int) {
+		for len(open) != 0 && open[len(open)-1].indent >= indent {
+			block := open[len(open)-1]
+			open = open[:len(open)-1]
+			body := [2]int{min(block.body_end+1, pos), pos}
+			if block.keyword == "try" {
+				pending = append(pending, tryClauses{indent: block.indent, body: body})
+			} else if block.keyword == "except" && block.catches {
+				blocks = append(blocks, body)
+			}
+		}
+	}
+	for line_start := 0; line_start < len(data); {
+		line_end := strings.IndexByte(data[line_start:], '\n')
+		if line_end == -1 {
+			line_end = len(data)
+		} else {
+			line_end += line_start
+		}
+		line := data[line_start:line_end]
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.TrimSpace(trimmed) == "" || trimmed[0] == '#' {
+			line_start = line_end + 1
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		closeBlocks(indent, line_start)
+		keyword, _, _ := strings.Cut(strings.TrimRight(strings.Fields(trimmed)[0], ":"), "(")
+		catches := keyword == "except" && catchesImportError(trimmed)
+
+		// The clauses of a `try` are at its indentation, and end at any other statement there
+		pending = slices.DeleteFunc(pending, func(try tryClauses) bool { return try.indent > indent })
+		for i := range pending {
+			if pending[i].indent != indent {
+				continue
+			}
+			if keyword != "except" && keyword != "else" && keyword != "finally" {
+				pending = slices.Delete(pending, i, i+1)
+			} else if catches && !pending[i].catches {
+				pending[i].catches = true
+				blocks = append(blocks, pending[i].body)
+			}
+			break
+		}
+		open = append(open, openBlock{indent: indent, keyword: keyword, catches: catches, body_end: line_end})
+		line_start = line_end + 1
+	}
+	// As if another line started after the end
+	closeBlocks(0, len(data)+1)
+
+	// Merge nested and overlapping blocks
+	slices.SortFunc(blocks, func(a, b [2]int) int { return a[0] - b[0] })
+	merged := importErrorBlocks{}
+	for _, block := range blocks {
+		if block[0] >= block[1] {
+			continue
+		}
+		if len(merged) != 0 && block[0] <= merged[len(merged)-1][1] {
+			merged[len(merged)-1][1] = max(merged[len(merged)-1][1], block[1])
+			continue
+		}
+		merged = append(merged, block)
+	}
+	return merged
+}
+
+// Returns whether the line at `offset` is in one of the blocks
+func (blocks importErrorBlocks) contains(offset int) bool {
+	idx, _ := slices.BinarySearchFunc(blocks, offset, func(block [2]int, offset int) int {
+		if block[1] <= offset {
+			return -1
+		} else if block[0] > offset {
+			return 1
+		}
+		return 0
+	})
+	return idx < len(blocks) && blocks[idx][0] <= offset && offset < blocks[idx][1]
+}
 
 type RegexResult []string
 
@@ -37,8 +215,9 @@ func applyActions(
 	actions *RuleActions,
 	file string,
 	file_data **string,
-	file_relations *[]string,
-	python_mod_resolver *PythonModuleResolver,
+	file_relations *FileRelations,
+	origin EdgeOrigin,
+	resolvers *Resolvers,
 	config *Config,
 	args *Args,
 	base_dir string,
@@ -46,44 +225,37 @@ func applyActions(
 ) error {
 	// Visit files
 	for _, visit := range regex_result.applyOnTemplates(actions.Visit.items) {
-		visit_files_chunk, err := doublestar.Glob(
-			os.DirFS(base_dir),
-			visit,
-			doublestar.WithFilesOnly(),
-			doublestar.WithFailOnIOErrors(),
-		)
+		visit_files_chunk, err := resolvers.globs.Glob(".", visit)
 		if err != nil {
 			return fmt.Errorf("error while visiting '%s': %v", visit, err)
 		}
-		*file_relations = append(*file_relations, visit_files_chunk...)
+		file_relations.Add(origin, visit_files_chunk...)
+	}
+
+	// Visit abstract nodes
+	for _, node := range regex_result.applyOnTemplates(actions.VisitAbstractNodes.items) {
+		if !isAbstractNode(node) {
+			return fmt.Errorf("abstract node '%s' must be named like a URL (e.g. 'env://NAME')", node)
+		}
+		file_relations.Add(origin, node)
 	}
 
 	// Visit siblings
 	path_iter := filepath.Dir(file)
 	for _, visit := range regex_result.applyOnTemplates(actions.VisitSiblings.items) {
-		visit_files_chunk, err := doublestar.Glob(
-			os.DirFS(filepath.Join(base_dir, path_iter)),
-			visit,
-			doublestar.WithFilesOnly(),
-			doublestar.WithFailOnIOErrors(),
-		)
+		visit_files_chunk, err := resolvers.globs.Glob(path_iter, visit)
 		if err != nil {
 			return fmt.Errorf("error while visiting sibling '%s': %v", visit, err)
 		}
 		for _, visit_file := range visit_files_chunk {
-			*file_relations = append(*file_relations, filepath.Join(path_iter, visit_file))
+			file_relations.Add(origin, filepath.Join(path_iter, visit_file))
 		}
 	}
 
 	// Visit grand siblings
 	for path_iter != "." {
 		for _, visit := range regex_result.applyOnTemplates(actions.VisitGrandSiblings.items) {
-			visit_files_chunk, err := doublestar.Glob(
-				os.DirFS(filepath.Join(base_dir, path_iter)),
-				visit,
-				doublestar.WithFilesOnly(),
-				doublestar.WithFailOnIOErrors(),
-			)
+			visit_files_chunk, err := resolvers.globs.Glob(path_iter, visit)
 			if err != nil {
 				return fmt.Errorf(
 					"error while visiting grand sibling '%s' at '%s': %v",
@@ -93,10 +265,7 @@ func applyActions(
 				)
 			}
 			for _, visit_file := range visit_files_chunk {
-				*file_relations = append(
-					*file_relations,
-					filepath.Join(path_iter, visit_file),
-				)
+				file_relations.Add(origin, filepath.Join(path_iter, visit_file))
 			}
 		}
 		path_iter = filepath.Dir(path_iter)
@@ -105,45 +274,83 @@ func applyActions(
 	// Visit imported Python modules
 	if actions.VisitImportedPythonModules || len(actions.VisitPythonAllSubmodulesFor.items) != 0 {
 		// Read file
-		if *file_data == nil {
-			file_data_bytes, err := os.ReadFile(filepath.Join(base_dir, file))
-			if err != nil {
-				return fmt.Errorf("error while reading python file: %v", err)
+		err := loadFileData(file_data, file, config, base_dir)
+		if err != nil {
+			return fmt.Errorf("error while reading python file: %v", err)
+		}
+
+		// Imports in test files are test edges, unless the rule has its own edge type
+		import_origin := origin
+		if origin.Type == EDGE_TYPE_RULE {
+			// These patterns were validated when the config was loaded
+			if is_test, _ := checkExcludePatterns(config.python_test_files, file); is_test {
+				import_origin.Type = EDGE_TYPE_TEST
 			}
-			file_data_str := string(file_data_bytes)
-			*file_data = &file_data_str
 		}
 
 		// Parse all import statements
-		pyimports := []string{}
+		pyimports := []pythonImport{}
 		pyimports_idents := map[string]string{}
-		for _, match := range python_import_parser_simple.FindAllStringSubmatch(**file_data, -1) {
-			pyimports = append(pyimports, match[1])
-			if match[2] != "" {
-				// "import ... as ..."
-				pyimports_idents[match[2][4:]] = match[1]
-			} else {
-				// "import ..."
-				pyimports_idents[match[1]] = match[1]
+		import_error_blocks := findImportErrorBlocks(**file_data)
+		for _, statement := range parsePythonImports(**file_data) {
+			conditional := isInConditionalBlock(**file_data, statement.offset)
+			type_only := isInTypeCheckingBlock(**file_data, statement.offset)
+			optional := import_error_blocks.contains(statement.offset)
+			if statement.from == "" {
+				for _, imported := range statement.names {
+					pyimports = append(pyimports, pythonImport{imported.name, conditional, type_only, optional, statement.offset, false, PYTHON_IMPORT_PLAIN})
+					if imported.alias != "" {
+						// "import ... as ..."
+						pyimports_idents[imported.alias] = imported.name
+					} else {
+						// "import ..."
+						pyimports_idents[imported.name] = imported.name
+					}
+				}
+				continue
 			}
-		}
-		for _, match := range python_import_parser_from.FindAllStringSubmatch(**file_data, -1) {
-			pyimports = append(pyimports, match[1])
-			for _, import_ident := range python_import_parser_ident.FindAllStringSubmatch(
-				match[2], -1,
-			) {
-				full_mod_name := match[1] + "." + import_ident[1]
-				pyimports = append(pyimports, full_mod_name)
-				if import_ident[2] != "" {
+
+			mod_name := statement.from
+			pyimports = append(pyimports, pythonImport{mod_name, conditional, type_only, optional, statement.offset, false, PYTHON_IMPORT_FROM})
+			names := []string{}
+			for _, imported := range statement.names {
+				names = append(names, imported.name)
+				if imported.name == "*" {
+					continue
+				}
+				full_mod_name := mod_name + "." + imported.name
+				pyimports = append(pyimports, pythonImport{full_mod_name, conditional, type_only, optional, statement.offset, true, PYTHON_IMPORT_FROM})
+				if imported.alias != "" {
 					// "from ... import ... as ..."
-					pyimports_idents[import_ident[2][4:]] = full_mod_name
+					pyimports_idents[imported.alias] = full_mod_name
 				} else {
 					// "from ... import ..."
-					pyimports_idents[import_ident[1]] = full_mod_name
+					pyimports_idents[imported.name] = full_mod_name
+				}
+			}
+
+			// Names re-exported by the package's `__init__.py` come from the modules it imports them from
+			if actions.VisitPythonReExports && !strings.HasPrefix(mod_name, ".") {
+				modules, err := resolvers.python.ReExportedFrom(mod_name, names, config, base_dir)
+				if err != nil {
+					return fmt.Errorf("error while expanding re-exports of '%s': %v", mod_name, err)
+				}
+				for _, module := range modules {
+					pyimports = append(pyimports, pythonImport{module, conditional, type_only, optional, statement.offset, true, PYTHON_IMPORT_RE_EXPORT})
 				}
 			}
 		}
 
+		// `importlib.import_module("pkg.mod")` and `__import__("pkg")` with literal module names
+		if actions.VisitPythonDynamicImports {
+			for _, match := range python_dynamic_import_parser.FindAllStringSubmatchIndex(**file_data, -1) {
+				conditional := isInConditionalBlock(**file_data, match[0])
+				type_only := isInTypeCheckingBlock(**file_data, match[0])
+				optional := import_error_blocks.contains(match[0])
+				pyimports = append(pyimports, pythonImport{(**file_data)[match[2]:match[3]], conditional, type_only, optional, match[0], false, PYTHON_IMPORT_DYNAMIC})
+			}
+		}
+
 		// Visit all submodules of a given python module by name
 		if len(actions.VisitPythonAllSubmodulesFor.items) != 0 {
 			for _, mod_name := range regex_result.applyOnTemplates(
@@ -169,32 +376,94 @@ func applyActions(
 				if args.Verbose {
 					log.Println("Visiting all submodules of:", mod_name, "->", full_mod_name)
 				}
-				dir_path := strings.ReplaceAll(full_mod_name, ".", "/")
+				submodules_origin := import_origin
+				submodules_origin.PythonImport = PYTHON_IMPORT_SUBMODULES
+				for _, dir_path := range pythonModulePaths(full_mod_name, config) {
+					visit_files_chunk, err := resolvers.globs.Glob(".", dir_path+"/**/*.py")
+					if err != nil {
+						return fmt.Errorf("error while visiting submodule '%s': %v", full_mod_name, err)
+					}
+					file_relations.Add(submodules_origin, visit_files_chunk...)
+				}
+			}
+		}
+
+		// Cython files: the `.pxd` declarations of the module itself are implicitly cimported, and
+		// `include "*.pxi"` is textual
+		if ext := filepath.Ext(file); ext == ".pyx" || ext == ".py" || ext == ".pxd" {
+			if ext != ".pxd" {
+				if pxd_path, ok := resolveCandidate(strings.TrimSuffix(file, ext)+".pxd", config, base_dir); ok {
+					file_relations.Add(import_origin, pxd_path)
+				}
+			}
+			if ext != ".py" {
+				for _, match := range cython_include_parser.FindAllStringSubmatch(**file_data, -1) {
+					include_path := filepath.Join(filepath.Dir(file), match[1])
+					if fileExists(config, filepath.Join(base_dir, include_path)) {
+						file_relations.Add(import_origin, include_path)
+					}
+				}
+			}
+		}
 
-				visit_files_chunk, err := doublestar.Glob(
-					os.DirFS(base_dir),
-					dir_path+"/**/*.py",
-					doublestar.WithFilesOnly(),
-					doublestar.WithFailOnIOErrors(),
-				)
-				if err != nil {
-					return fmt.Errorf("error while visiting submodule '%s': %v", full_mod_name, err)
+		// Type stubs: a `.pyi` only describes its implementation, so it depends on it. The other way
+		// around is opt-in with `python_implementation_stubs`.
+		if ext := filepath.Ext(file); ext == ".pyi" {
+			for _, impl_ext := range []string{".py", ".pyx"} {
+				if impl_path, ok := resolveCandidate(strings.TrimSuffix(file, ext)+impl_ext, config, base_dir); ok {
+					file_relations.Add(import_origin, impl_path)
 				}
-				*file_relations = append(*file_relations, visit_files_chunk...)
+			}
+		} else if config.PythonImplStubs && (ext == ".py" || ext == ".pyx") {
+			if stub_path, ok := resolveCandidate(strings.TrimSuffix(file, ext)+".pyi", config, base_dir); ok {
+				stub_origin := import_origin
+				stub_origin.TypeOnly = config.PythonStubPackages
+				file_relations.Add(stub_origin, stub_path)
 			}
 		}
 
 		// Resolve the imports
-		for _, module := range pyimports {
-			paths, err := python_mod_resolver.Resolve(module, config, base_dir)
+		for _, pyimport := range pyimports {
+			if pyimport.type_only && actions.PythonTypeCheckingImports == TYPE_CHECKING_IMPORTS_EXCLUDE {
+				continue
+			}
+			paths, err := resolvers.python.Resolve(pyimport.module, config, base_dir)
 			if err != nil {
-				return fmt.Errorf("error while resolving python module '%s': %v", module, err)
+				return fmt.Errorf("error while resolving python module '%s': %v", pyimport.module, err)
+			}
+			pyimport_origin := import_origin
+			pyimport_origin.Conditional = pyimport.conditional
+			pyimport_origin.TypeOnly = pyimport.type_only && actions.PythonTypeCheckingImports == TYPE_CHECKING_IMPORTS_WEAK
+			pyimport_origin.Optional = pyimport.optional && actions.MarkOptionalImports
+			pyimport_origin.PythonImport = pyimport.kind
+			file_relations.Add(pyimport_origin, paths.Paths...)
+			// Type stubs only matter to type checkers
+			stub_origin := pyimport_origin
+			stub_origin.TypeOnly = true
+			file_relations.Add(stub_origin, paths.StubPaths...)
+			if !actions.SkipPythonParentPackages {
+				file_relations.Add(pyimport_origin, paths.ParentPaths...)
+			}
+			if len(paths.Paths) == 0 && len(paths.StubPaths) == 0 && !pyimport.implied && isRootPythonModule(pyimport.module, config) {
+				config.addUnresolvedImport(file, **file_data, pyimport)
+			}
+
+			// Third-party packages depend on their locked version
+			if len(paths.Paths) == 0 && len(paths.StubPaths) == 0 && len(config.Lockfiles.items) != 0 && !strings.HasPrefix(pyimport.module, ".") {
+				top_level, _, _ := strings.Cut(pyimport.module, ".")
+				node, ok, err := resolvers.lockfiles.LockedPackage(file, "pypi", top_level, config, base_dir)
+				if err != nil {
+					return err
+				}
+				if ok {
+					file_relations.Add(pyimport_origin, node)
+				}
 			}
-			*file_relations = append(*file_relations, paths.Paths...)
 		}
 	}
 
-	return nil
+	// Run the built-in resolvers of other languages
+	return resolvers.resolveAll(actions, file, file_data, file_relations, origin, config, base_dir)
 }
 
 func checkExcludePatterns(exclude_patterns []string, file string) (bool, error) {
@@ -217,8 +486,8 @@ func checkExcludePatterns(exclude_patterns []string, file string) (bool, error)
 
 func visitFile(
 	file string,
-	file_relations *[]string,
-	python_mod_resolver *PythonModuleResolver,
+	file_relations *FileRelations,
+	resolvers *Resolvers,
 	regex_cache map[string]*regexp.Regexp,
 	config *Config,
 	args *Args,
@@ -237,6 +506,20 @@ func visitFile(
 		log.Println("Visiting:", file)
 	}
 
+	// Generated files only depend on their sources, as they may not exist locally
+	if sources, pattern, ok := config.GeneratedSources(file); ok {
+		origin := EdgeOrigin{Type: EDGE_TYPE_GENERATED, Rule: pattern}
+		for _, source := range sources {
+			source_files, err := resolvers.globs.Glob(".", source)
+			if err != nil {
+				return fmt.Errorf("error while visiting generated file source '%s': %v", source, err)
+			}
+			file_relations.Add(origin, source_files...)
+		}
+		cleanupRelations(file_relations, config)
+		return nil
+	}
+
 	for rule_pattern, path_rules := range config.PathRules {
 		match, err := doublestar.Match(rule_pattern, file)
 		var file_data *string
@@ -247,13 +530,18 @@ func visitFile(
 			if args.Verbose {
 				log.Println("Matched rule:", rule_pattern)
 			}
+			edge_type := EDGE_TYPE_RULE
+			if path_rules.Actions.EdgeType != "" {
+				edge_type = path_rules.Actions.EdgeType
+			}
 
 			err := applyActions(
 				&path_rules.Actions,
 				file,
 				&file_data,
 				file_relations,
-				python_mod_resolver,
+				EdgeOrigin{Type: edge_type, Rule: rule_pattern, InterfaceOnly: path_rules.Actions.InterfaceOnly},
+				resolvers,
 				config,
 				args,
 				base_dir,
@@ -282,18 +570,18 @@ func visitFile(
 				if excluded {
 					continue
 				}
+				regex_edge_type := edge_type
+				if regex_actions.EdgeType != "" {
+					regex_edge_type = regex_actions.EdgeType
+				}
 				// Read file
-				if file_data == nil {
-					file_data_bytes, err := os.ReadFile(filepath.Join(base_dir, file))
-					if err != nil {
-						return fmt.Errorf(
-							"error while running path_rule '%s': error while reading python file: %v",
-							rule_pattern,
-							err,
-						)
-					}
-					file_data_str := string(file_data_bytes)
-					file_data = &file_data_str
+				err = loadFileData(&file_data, file, config, base_dir)
+				if err != nil {
+					return fmt.Errorf(
+						"error while running path_rule '%s': error while reading python file: %v",
+						rule_pattern,
+						err,
+					)
 				}
 				// Compile the regex pattern
 				if _, ok := regex_cache[regex_rule_pattern]; !ok {
@@ -320,7 +608,12 @@ func visitFile(
 						file,
 						&file_data,
 						file_relations,
-						python_mod_resolver,
+						EdgeOrigin{
+							Type:          regex_edge_type,
+							Rule:          rule_pattern + " | " + regex_rule_pattern,
+							InterfaceOnly: path_rules.Actions.InterfaceOnly || regex_actions.InterfaceOnly,
+						},
+						resolvers,
 						config,
 						args,
 						base_dir,
@@ -339,62 +632,135 @@ func visitFile(
 		}
 	}
 
+	cleanupRelations(file_relations, config)
+
+	return nil
+}
+
+func cleanupRelations(file_relations *FileRelations, config *Config) {
+	// Depend on the canonical source of generated duplicates
+	for i, related_file := range file_relations.paths {
+		canonical := config.CanonicalPath(related_file)
+		if canonical != related_file {
+			file_relations.paths[i] = canonical
+			file_relations.origins[canonical] = append(
+				file_relations.origins[canonical],
+				file_relations.origins[related_file]...,
+			)
+		}
+	}
+
 	// Ignore globally excluded files from the files we just added
-	*file_relations = slices.DeleteFunc(*file_relations, func(related_file string) bool {
+	file_relations.paths = slices.DeleteFunc(file_relations.paths, func(related_file string) bool {
 		// These patterns were already ran above, assume they can't fail
 		excluded, _ := checkExcludePatterns(config.GlobalExclude.items, related_file)
 		return excluded
 	})
-
-	return nil
 }
 
 func VisitRecursively(
 	all_files_set map[string]bool,
 	file_relation_map map[string][]string,
+	edge_origins EdgeOrigins,
 	input_files []string,
 	config *Config,
 	args *Args,
 	base_dir string,
 ) error {
 	regex_cache := map[string]*regexp.Regexp{}
-	python_mod_resolver := PythonModuleResolver{
-		cache: map[string]*PythonModuleResolverResult{},
+	resolvers := NewResolvers(config, base_dir)
+
+	// Files are reached with the scope of the inputs reaching them (see `TargetScopes`), and only
+	// visited if they're in one of those scopes. Without scopes, each file is reached with "".
+	scopes := map[string][]*EdgeFilter{}
+	queue := map[string]map[string]bool{}
+	for _, file := range input_files {
+		scope_key, scope := config.TargetScopes(file)
+		scopes[scope_key] = scope
+		if queue[file] == nil {
+			queue[file] = map[string]bool{}
+		}
+		queue[file][scope_key] = true
+	}
+	reached := map[string]map[string]bool{}
+	visited := map[string]bool{}
+	inScope := func(file string, scope_key string) bool {
+		for _, filter := range scopes[scope_key] {
+			if in_scope, _ := checkExcludePatterns(filter.scope, file); !in_scope {
+				return false
+			}
+		}
+		return true
 	}
 
 	// Loop until we have no more files to visit
 	for {
-		related_files := []string{}
+		next_queue := map[string]map[string]bool{}
 		if args.Verbose {
 			log.Println("---")
 		}
 
 		// Visit each file
-		for _, file := range input_files {
-			if all_files_set[file] {
-				continue
+		files := make([]string, 0, len(queue))
+		for file := range queue {
+			files = append(files, file)
+		}
+		slices.Sort(files)
+		for _, file := range files {
+			if reached[file] == nil {
+				reached[file] = map[string]bool{}
 			}
 			all_files_set[file] = true
-			file_relations := config.GlobalDeps.items
+			for scope_key := range queue[file] {
+				// Reaching a file unscoped already reaches everything any scope could
+				if reached[file][scope_key] || reached[file][""] {
+					continue
+				}
+				reached[file][scope_key] = true
+				if !inScope(file, scope_key) {
+					// A leaf of this scope
+					if !visited[file] {
+						file_relation_map[file] = nil
+					}
+					continue
+				}
 
-			err := visitFile(file, &file_relations, &python_mod_resolver, regex_cache, config, args, base_dir)
-			if err != nil {
-				return fmt.Errorf("error while visiting file '%s': %v", file, err)
-			}
+				if !visited[file] && (isAbstractNode(file) || config.IsTerminal(file)) {
+					// Abstract nodes have no dependencies, and files in `terminal_dirs` aren't followed
+					visited[file] = true
+					file_relation_map[file] = nil
+				} else if !visited[file] {
+					visited[file] = true
+					file_relations := FileRelations{}
+					file_relations.Add(EdgeOrigin{Type: EDGE_TYPE_GLOBAL}, config.GlobalDeps.items...)
 
-			// Sort, dedup, and save the related files
-			slices.Sort(file_relations)
-			file_relations = slices.Compact(file_relations)
-			file_relation_map[file] = file_relations
-			related_files = append(related_files, file_relations...)
+					err := visitFile(file, &file_relations, resolvers, regex_cache, config, args, base_dir)
+					if err != nil {
+						return fmt.Errorf("error while visiting file '%s': %v", file, err)
+					}
+
+					// Sort, dedup, and save the related files
+					slices.Sort(file_relations.paths)
+					file_relations.paths = slices.Compact(file_relations.paths)
+					file_relation_map[file] = file_relations.paths
+					for _, related_file := range file_relations.paths {
+						for _, origin := range file_relations.origins[related_file] {
+							edge_origins.Add(Edge{From: file, To: related_file}, origin)
+						}
+					}
+				}
+				for _, related_file := range file_relation_map[file] {
+					if next_queue[related_file] == nil {
+						next_queue[related_file] = map[string]bool{}
+					}
+					next_queue[related_file][scope_key] = true
+				}
+			}
 		}
 
-		if len(related_files) != 0 {
-			// Sort, dedup, and send the slice to the queue
-			slices.Sort(related_files)
-			input_files = slices.Compact(related_files)
-		} else {
+		if len(next_queue) == 0 {
 			return nil
 		}
+		queue = next_queue
 	}
 }
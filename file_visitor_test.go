@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindImportErrorBlocks(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// Imports on lines marked with ` !` are expected to be optional
+		source string
+	}{
+		{"try_except", "try:\n    import a !\nexcept ImportError:\n    import b !\nimport c\n"},
+		{"module_not_found", "try:\n    import a !\nexcept ModuleNotFoundError:\n    a = None\n"},
+		{"tuple", "try:\n    import a !\nexcept (ImportError, OSError) as e:\n    pass\n"},
+		{"other_exception", "try:\n    import a\nexcept ValueError:\n    import b\n"},
+		{"later_clause", "try:\n    import a !\nexcept ValueError:\n    import b\nexcept ImportError:\n    pass\n"},
+		{"else_finally", "try:\n    import a !\nexcept ImportError:\n    pass\nelse:\n    import b\nfinally:\n    import c\n"},
+		{"nested", "def f():\n    try:\n        if x:\n            import a !\n        import b !\n    except ImportError:\n        pass\n    import c\n"},
+		{"nested_in_clause", "try:\n    import a !\nexcept ImportError:\n    try:\n        import b !\n    except ValueError:\n        import c !\n"},
+		{"inner_try", "try:\n    try:\n        import a !\n    except ValueError:\n        pass\nexcept ImportError:\n    pass\n"},
+		{"one_line", "try: import a\nexcept ImportError: pass\n"},
+		{"top_level", "import a\n"},
+		{"comments_and_blanks", "try:\n\n    # a comment\n    import a !\n\n# comment\nexcept ImportError:\n    pass\n"},
+		{"crlf", "try:\r\n    import a !\r\nexcept ImportError:\r\n    pass\r\n"},
+		{"unterminated", "try:\n    import a\n"},
+		{"clause_at_end", "try:\n    import a !\nexcept ImportError:\n    import b !"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			source := strings.ReplaceAll(test.source, " !", "")
+			blocks := findImportErrorBlocks(source)
+			offset := 0
+			for _, line := range strings.SplitAfter(test.source, "\n") {
+				if import_at := strings.Index(line, "import"); import_at != -1 {
+					expected := strings.Contains(line, " !")
+					if got := blocks.contains(offset + import_at); got != expected {
+						t.Errorf("line %q: optional = %v, expected %v", strings.TrimSpace(line), got, expected)
+					}
+				}
+				offset += len(strings.ReplaceAll(line, " !", ""))
+			}
+		})
+	}
+}
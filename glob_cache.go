@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+type globKey struct {
+	dir     string
+	pattern string
+}
+
+// Evaluates file globs against an index of the files under each glob's static prefix (e.g. `src/`
+// for `src/**/*.py`), built the first time a glob needs it, instead of hitting the filesystem for
+// each glob. Glob results are cached for the lifetime of the run, since many files share the same
+// siblings and grand siblings.
+// Globally excluded files aren't indexed, as they would be dropped from the relations anyway.
+type GlobCache struct {
+	config   *Config
+	base_dir string
+	// Indexed dir (relative to the base dir) -> sorted paths of the files under it, relative to the
+	// base dir
+	indexes map[string][]string
+	results map[globKey][]string
+}
+
+func NewGlobCache(config *Config, base_dir string) *GlobCache {
+	return &GlobCache{
+		config:   config,
+		base_dir: base_dir,
+		indexes:  map[string][]string{},
+		results:  map[globKey][]string{},
+	}
+}
+
+// Returns the files matching the pattern in `dir` (relative to the base dir), relative to `dir`.
+// The returned slice is shared, and must not be modified.
+func (cache *GlobCache) Glob(dir string, pattern string) ([]string, error) {
+	key := globKey{dir, pattern}
+	if files, ok := cache.results[key]; ok {
+		return files, nil
+	}
+	if !doublestar.ValidatePattern(pattern) {
+		return nil, doublestar.ErrBadPattern
+	}
+
+	// Only scan the files under the static part of the pattern
+	prefix, _ := doublestar.SplitPattern(pattern)
+	if strings.ContainsAny(prefix, "*?[{\\") {
+		prefix = "."
+	}
+	dir = filepath.ToSlash(dir)
+	prefix = filepath.ToSlash(filepath.Join(dir, prefix))
+	indexed, err := cache.index(prefix)
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if prefix != "." {
+		prefix += "/"
+		start = sort.SearchStrings(indexed, prefix)
+	}
+	dir_prefix := ""
+	if dir != "." {
+		dir_prefix = dir + "/"
+	}
+
+	files := []string{}
+	for _, file := range indexed[start:] {
+		if !strings.HasPrefix(file, prefix) && prefix != "." {
+			break
+		}
+		rel_file := file[len(dir_prefix):]
+		if match, _ := doublestar.Match(pattern, rel_file); match {
+			files = append(files, rel_file)
+		}
+	}
+	cache.results[key] = files
+	return files, nil
+}
+
+// Returns the index of a directory or of one of its ancestors (which covers it), walking the
+// directory if neither was indexed yet
+func (cache *GlobCache) index(dir string) ([]string, error) {
+	if isOutsideRepo(dir) {
+		return []string{}, nil
+	}
+	for ancestor := dir; ; ancestor = path.Dir(ancestor) {
+		if indexed, ok := cache.indexes[ancestor]; ok {
+			return indexed, nil
+		}
+		if ancestor == "." {
+			break
+		}
+	}
+	indexed, err := cache.buildIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	cache.indexes[dir] = indexed
+	return indexed, nil
+}
+
+// Walk a directory and index all files under it, following symlinks to directories like globbing
+// does. Unreadable directories count as unreadable files (see `io_errors`), and are left out.
+func (cache *GlobCache) buildIndex(root string) ([]string, error) {
+	files := []string{}
+	for ancestor := root; ancestor != "."; ancestor = path.Dir(ancestor) {
+		if cache.isExcludedDir(ancestor) {
+			return files, nil
+		}
+	}
+	ancestor_dirs := map[string]bool{}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		real_dir, err := withIORetries(cache.config, func() (string, error) {
+			return filepath.EvalSymlinks(filepath.Join(cache.base_dir, dir))
+		})
+		if err == nil {
+			// Avoid symlink loops, a directory may still be reachable through several paths
+			if ancestor_dirs[real_dir] {
+				return nil
+			}
+			ancestor_dirs[real_dir] = true
+			defer delete(ancestor_dirs, real_dir)
+		}
+
+		entries, err := readRepoDir(cache.config, filepath.Join(cache.base_dir, dir))
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			// Globs may start in a directory that doesn't exist (or isn't one)
+			return nil
+		} else if err != nil {
+			return cache.config.addUnreadableFile(dir, err)
+		}
+		for _, entry := range entries {
+			path := filepath.ToSlash(filepath.Join(dir, entry.Name()))
+			is_dir := entry.IsDir()
+			if entry.Type()&os.ModeSymlink != 0 {
+				file_type, ok := cache.config.statRepoPath(filepath.Join(cache.base_dir, path))
+				if !ok {
+					// Dangling symlink
+					continue
+				}
+				is_dir = file_type.IsDir()
+			}
+			if is_dir {
+				if cache.isExcludedDir(path) {
+					continue
+				}
+				if err := walk(path); err != nil {
+					return err
+				}
+			} else if excluded, _ := checkExcludePatterns(cache.config.GlobalExclude.items, path); !excluded {
+				files = append(files, path)
+			}
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return nil, fmt.Errorf("error while indexing files: %v", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Returns whether a path (relative to the base dir) is outside the repo, which globs never match
+func isOutsideRepo(path string) bool {
+	return filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, "../")
+}
+
+// Returns whether everything in the directory is excluded (e.g. by "node_modules/**")
+func (cache *GlobCache) isExcludedDir(dir string) bool {
+	for _, pattern := range cache.config.GlobalExclude.items {
+		if dir_pattern, ok := strings.CutSuffix(pattern, "/**"); ok {
+			if match, _ := doublestar.Match(dir_pattern, dir); match {
+				return true
+			}
+		}
+	}
+	return false
+}
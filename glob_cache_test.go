@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestGlobCache(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{
+		"a.py":                  "",
+		"src/b.py":              "",
+		"src/sub/c.py":          "",
+		"src/sub/d.txt":         "",
+		"node_modules/e.py":     "",
+		"other/f.py":            "",
+		"other/locked/g.py":     "",
+		"other/locked/h.txt":    "",
+		"src/node_modules/i.py": "",
+	})
+	config := loadTestConfig(t, base_dir, "global_exclude: ['**/node_modules/**']\nio_errors:\n  max_unreadable_files: 1\nnetwork_filesystem: true\n")
+	// An unreadable directory, through the listings shared with the rest of the run
+	config.dir_listings.dirs[filepath.Join(base_dir, "other/locked")] = &dirListing{err: syscall.EACCES}
+	cache := NewGlobCache(config, base_dir)
+
+	for _, test := range []struct {
+		dir      string
+		pattern  string
+		expected []string
+	}{
+		{".", "src/**/*.py", []string{"src/b.py", "src/sub/c.py"}},
+		{"src", "sub/*", []string{"sub/c.py", "sub/d.txt"}},
+		{".", "missing/**", []string{}},
+		{".", "node_modules/*.py", []string{}},
+		{".", "../**", []string{}},
+	} {
+		got, err := cache.Glob(test.dir, test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, test.expected) {
+			t.Errorf("Glob(%q, %q) = %v, expected %v", test.dir, test.pattern, got, test.expected)
+		}
+	}
+	// Only the directories under the globs were indexed
+	if config.unreadableFileCount() != 0 {
+		t.Errorf("%d unreadable files, expected none before globbing other/", config.unreadableFileCount())
+	}
+
+	got, err := cache.Glob(".", "**/*.py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.py", "other/f.py", "src/b.py", "src/sub/c.py"}; !slices.Equal(got, expected) {
+		t.Errorf("Glob(\"**/*.py\") = %v, expected %v", got, expected)
+	}
+	if config.unreadableFileCount() != 1 {
+		t.Errorf("%d unreadable files, expected other/locked", config.unreadableFileCount())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+type goModule struct {
+	path string
+	dir  string
+}
+
+// Resolves the imports of Go files to the files of the imported packages, for packages in the Go
+// modules inside the repo (found by their `go.mod` files)
+type GoPackageResolver struct {
+	// Sorted by descending module path length, so the longest matching module is found first
+	modules []goModule
+	loaded  bool
+	cache   map[string][]string
+}
+
+func (res *GoPackageResolver) loadModules(config *Config, base_dir string) error {
+	res.loaded = true
+	res.cache = map[string][]string{}
+	go_mod_files, err := doublestar.Glob(
+		config.repoFS(base_dir),
+		"**/go.mod",
+		doublestar.WithFilesOnly(),
+		doublestar.WithFailOnIOErrors(),
+	)
+	if err != nil {
+		return fmt.Errorf("error while finding go.mod files: %v", err)
+	}
+	for _, go_mod_file := range go_mod_files {
+		excluded, err := checkExcludePatterns(config.GlobalExclude.items, go_mod_file)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			continue
+		}
+		go_mod_data, err := os.ReadFile(filepath.Join(base_dir, go_mod_file))
+		if err != nil {
+			return fmt.Errorf("error while reading '%s': %v", go_mod_file, err)
+		}
+		module_path := parseGoModulePath(string(go_mod_data))
+		if module_path == "" {
+			return fmt.Errorf("no module directive in '%s'", go_mod_file)
+		}
+		res.modules = append(res.modules, goModule{
+			path: module_path,
+			dir:  filepath.Dir(go_mod_file),
+		})
+	}
+	sort.SliceStable(res.modules, func(i, j int) bool {
+		return len(res.modules[i].path) > len(res.modules[j].path)
+	})
+	return nil
+}
+
+// Returns the module path declared in a go.mod file
+func parseGoModulePath(go_mod string) string {
+	for _, line := range strings.Split(go_mod, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			if unquoted, err := strconv.Unquote(fields[1]); err == nil {
+				return unquoted
+			}
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+func (res *GoPackageResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if !res.loaded {
+		err := res.loadModules(config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	parsed, err := parser.ParseFile(token.NewFileSet(), file, file_data, parser.ImportsOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing go file: %v", err)
+	}
+
+	paths := []string{}
+	for _, import_spec := range parsed.Imports {
+		import_path, err := strconv.Unquote(import_spec.Path.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid import path %s", import_spec.Path.Value)
+		}
+		pkg_files, err := res.resolvePackage(import_path, config, base_dir)
+		if err != nil {
+			return nil, fmt.Errorf("error while resolving go package '%s': %v", import_path, err)
+		}
+		paths = append(paths, pkg_files...)
+	}
+	return paths, nil
+}
+
+// Returns the non-test `.go` files of an imported package, if it's inside the repo
+func (res *GoPackageResolver) resolvePackage(import_path string, config *Config, base_dir string) ([]string, error) {
+	if cached, ok := res.cache[import_path]; ok {
+		return cached, nil
+	}
+
+	pkg_files := []string{}
+	for _, module := range res.modules {
+		if import_path != module.path && !strings.HasPrefix(import_path, module.path+"/") {
+			continue
+		}
+		pkg_dir := filepath.Join(module.dir, strings.TrimPrefix(import_path, module.path))
+		entries, err := readRepoDir(config, filepath.Join(base_dir, pkg_dir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.Type().IsRegular() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+				pkg_files = append(pkg_files, filepath.Join(pkg_dir, name))
+			}
+		}
+		break
+	}
+
+	slices.Sort(pkg_files)
+	res.cache[import_path] = pkg_files
+	return pkg_files, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var gradle_comment_parser = regexp.MustCompile(`(?s:/\*.*?\*/)|(?m:^[ \t]*//.*$)`)
+var gradle_include_parser = regexp.MustCompile(`(?s:\binclude\s*\(([^)]*)\))|(?m:\binclude[ \t]+([^\n(][^\n]*))`)
+var gradle_string_parser = regexp.MustCompile(`["']([^"'\n]*)["']`)
+var gradle_project_dir_parser = regexp.MustCompile(`\bproject\s*\(\s*["'](:?[^"'\n]*)["']\s*\)\s*\.projectDir\s*=\s*(?:new\s+)?(?:file|File)\s*\(\s*(?:(?:settingsDir|rootDir|rootProject\.projectDir)\s*,\s*)?["']([^"'\n]+)["']`)
+var gradle_project_parser = regexp.MustCompile(`\bproject\s*\(\s*(?:path\s*[:=]\s*)?["'](:[^"'\n]*)["']`)
+var gradle_accessor_parser = regexp.MustCompile(`\bprojects((?:\.[A-Za-z_][A-Za-z0-9_]*)+)`)
+
+var gradle_build_files = []string{"build.gradle.kts", "build.gradle"}
+var gradle_settings_files = []string{"settings.gradle.kts", "settings.gradle"}
+
+// Resolves Gradle build files (`build.gradle` and `build.gradle.kts`) to the build files of the
+// subprojects they depend on, with `project(":a:b")` or type-safe `projects.a.b` accessors, and to
+// the settings file mapping the subprojects to directories (`include` and `projectDir`).
+type GradleResolver struct {
+	// Directory -> the settings of the nearest settings file, nil if there's none
+	settings map[string]*gradleSettings
+}
+
+type gradleSettings struct {
+	file string
+	// Project path (":a:b") -> its directory
+	projects map[string]string
+}
+
+func parseGradleSettings(file string, file_data string) *gradleSettings {
+	data := gradle_comment_parser.ReplaceAllString(file_data, "")
+	dir := filepath.Dir(file)
+	settings := &gradleSettings{file: file, projects: map[string]string{":": dir}}
+	for _, match := range gradle_include_parser.FindAllStringSubmatch(data, -1) {
+		for _, project := range gradle_string_parser.FindAllStringSubmatch(match[1]+match[2], -1) {
+			project_path := ":" + strings.TrimPrefix(project[1], ":")
+			settings.projects[project_path] = filepath.Join(dir, strings.ReplaceAll(strings.TrimPrefix(project_path, ":"), ":", "/"))
+		}
+	}
+	for _, match := range gradle_project_dir_parser.FindAllStringSubmatch(data, -1) {
+		project_path := ":" + strings.TrimPrefix(match[1], ":")
+		settings.projects[project_path] = filepath.Join(dir, match[2])
+	}
+	return settings
+}
+
+// Returns the settings of the nearest settings file in `dir` or above, loading it on first use
+func (res *GradleResolver) loadSettings(dir string, config *Config, base_dir string) (*gradleSettings, error) {
+	if settings, ok := res.settings[dir]; ok {
+		return settings, nil
+	}
+	var settings *gradleSettings
+	for _, name := range gradle_settings_files {
+		file := filepath.Join(dir, name)
+		if !fileExists(config, filepath.Join(base_dir, file)) {
+			continue
+		}
+		data, err := readRepoFile(config, filepath.Join(base_dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading '%s': %v", file, err)
+		}
+		settings = parseGradleSettings(file, string(data))
+		break
+	}
+	if settings == nil && dir != "." {
+		var err error
+		settings, err = res.loadSettings(filepath.Dir(dir), config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.settings[dir] = settings
+	return settings, nil
+}
+
+// The name of a project in type-safe accessors: "foo-bar" -> "fooBar"
+func gradleAccessorName(name string) string {
+	accessor := []rune{}
+	upper := false
+	for _, char := range name {
+		if char == '-' || char == '_' || char == '.' {
+			upper = len(accessor) != 0
+			continue
+		}
+		if upper {
+			char = unicode.ToUpper(char)
+			upper = false
+		}
+		accessor = append(accessor, char)
+	}
+	return string(accessor)
+}
+
+// The build file of a project directory, "" if it has none
+func gradleBuildFile(dir string, config *Config, base_dir string) string {
+	for _, name := range gradle_build_files {
+		file := filepath.Join(dir, name)
+		if fileExists(config, filepath.Join(base_dir, file)) {
+			return file
+		}
+	}
+	return ""
+}
+
+func (res *GradleResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.settings == nil {
+		res.settings = map[string]*gradleSettings{}
+	}
+	name := filepath.Base(file)
+	if name != "build.gradle" && name != "build.gradle.kts" {
+		return nil, nil
+	}
+	settings, err := res.loadSettings(filepath.Dir(file), config, base_dir)
+	if err != nil || settings == nil {
+		return nil, err
+	}
+
+	data := gradle_comment_parser.ReplaceAllString(file_data, "")
+	project_dirs := []string{}
+	for _, match := range gradle_project_parser.FindAllStringSubmatch(data, -1) {
+		if project_dir, ok := settings.projects[match[1]]; ok {
+			project_dirs = append(project_dirs, project_dir)
+		}
+	}
+	accessors := map[string]string{}
+	for project_path, project_dir := range settings.projects {
+		accessor := ""
+		for _, segment := range strings.Split(strings.TrimPrefix(project_path, ":"), ":") {
+			accessor += "." + gradleAccessorName(segment)
+		}
+		accessors[accessor] = project_dir
+	}
+	for _, match := range gradle_accessor_parser.FindAllStringSubmatch(data, -1) {
+		// The longest prefix naming a project, e.g. `projects.core.dependencyProject`
+		for accessor := match[1]; accessor != ""; accessor = accessor[:strings.LastIndex(accessor, ".")] {
+			if project_dir, ok := accessors[accessor]; ok {
+				project_dirs = append(project_dirs, project_dir)
+				break
+			}
+		}
+	}
+
+	paths := []string{settings.file}
+	for _, project_dir := range project_dirs {
+		if build_file := gradleBuildFile(project_dir, config, base_dir); build_file != "" && build_file != file {
+			paths = append(paths, build_file)
+		}
+	}
+	return paths, nil
+}
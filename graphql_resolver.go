@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// `#import "./fragment.graphql"` and `# import Query.*, User from "./user.graphql"`
+var graphql_import_parser = regexp.MustCompile(`(?m:^\s*#\s*import\s+(?:[^"'\n]*\s+from\s+)?["']([^"'\n]+)["'])`)
+
+// GraphQL config files whose `schema` and `documents` are followed
+var graphql_config_files = map[string]bool{
+	"codegen.yml": true, "codegen.yaml": true, ".graphqlrc.yml": true, ".graphqlrc.yaml": true,
+}
+
+// Resolves GraphQL files to the files of their `#import "..."` comments (graphql-tag loaders) and
+// `# import ... from "..."` comments (schema stitching with graphql-import), relative to the file.
+// GraphQL Code Generator and `.graphqlrc` configs resolve to their local `schema` and `documents`
+// files (globs included, also in the per-output overrides of `generates`), relative to the config.
+// URLs and negated globs are ignored.
+type GraphqlResolver struct{}
+
+// Collects the local file patterns of a `schema` or `documents` value: a string, a list of
+// strings, or a list of single-key maps (the key being the pattern, the value its options)
+func graphqlConfigPatterns(value any) []string {
+	patterns := []string{}
+	switch value := value.(type) {
+	case string:
+		patterns = append(patterns, value)
+	case []any:
+		for _, item := range value {
+			patterns = append(patterns, graphqlConfigPatterns(item)...)
+		}
+	case map[string]any:
+		for pattern := range value {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func (res *GraphqlResolver) resolveConfig(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	graphql_config := map[string]any{}
+	if err := yaml.Unmarshal([]byte(file_data), &graphql_config); err != nil {
+		return nil, fmt.Errorf("error while parsing graphql config: %v", err)
+	}
+	sections := []map[string]any{graphql_config}
+	if generates, ok := graphql_config["generates"].(map[string]any); ok {
+		for _, output := range generates {
+			if output, ok := output.(map[string]any); ok {
+				sections = append(sections, output)
+			}
+		}
+	}
+	patterns := []string{}
+	for _, section := range sections {
+		patterns = append(patterns, graphqlConfigPatterns(section["schema"])...)
+		patterns = append(patterns, graphqlConfigPatterns(section["documents"])...)
+	}
+
+	dir := filepath.Dir(file)
+	config_fs := config.repoFS(filepath.Join(base_dir, dir))
+	paths := []string{}
+	for _, pattern := range patterns {
+		pattern = filepath.Clean(strings.TrimPrefix(pattern, "./"))
+		if strings.Contains(pattern, "://") || strings.HasPrefix(pattern, "!") || strings.HasPrefix(pattern, "..") {
+			continue
+		}
+		matches, err := doublestar.Glob(config_fs, pattern, doublestar.WithFilesOnly(), doublestar.WithFailOnIOErrors())
+		if err != nil {
+			return nil, fmt.Errorf("error while globbing graphql files '%s': %v", pattern, err)
+		}
+		for _, match := range matches {
+			paths = append(paths, filepath.Join(dir, match))
+		}
+	}
+	return paths, nil
+}
+
+func (res *GraphqlResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if graphql_config_files[filepath.Base(file)] {
+		return res.resolveConfig(file, file_data, config, base_dir)
+	}
+	paths := []string{}
+	for _, match := range graphql_import_parser.FindAllStringSubmatch(file_data, -1) {
+		path := filepath.Join(filepath.Dir(file), match[1])
+		if path != file && !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// The files of a chart that go into its package (other than `Chart.yaml`)
+var helm_chart_files = []string{"templates/**", "crds/**", "values*.yaml", "values.schema.json", "Chart.lock", ".helmignore"}
+
+// Resolves a Helm chart's `Chart.yaml` to the files of the chart: templates, CRDs, values files and
+// the values schema, and to the `Chart.yaml` of its local dependencies (`repository: file://...`)
+// and of the unpacked subcharts in `charts/`, so that matching the rule recurses into them.
+type HelmResolver struct{}
+
+type helmChart struct {
+	Dependencies []struct {
+		Name       string `yaml:"name"`
+		Repository string `yaml:"repository"`
+	} `yaml:"dependencies"`
+}
+
+func (res *HelmResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if filepath.Base(file) != "Chart.yaml" {
+		return nil, nil
+	}
+	chart := helmChart{}
+	if err := yaml.Unmarshal([]byte(file_data), &chart); err != nil {
+		return nil, fmt.Errorf("error while parsing Chart.yaml: %v", err)
+	}
+	dir := filepath.Dir(file)
+	paths := []string{}
+	addIfExists := func(path string) {
+		if !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+
+	chart_fs := config.repoFS(filepath.Join(base_dir, dir))
+	for _, pattern := range slices.Concat(helm_chart_files, []string{"charts/*/Chart.yaml"}) {
+		matches, err := doublestar.Glob(chart_fs, pattern, doublestar.WithFilesOnly(), doublestar.WithFailOnIOErrors())
+		if err != nil {
+			return nil, fmt.Errorf("error while globbing chart files '%s': %v", pattern, err)
+		}
+		for _, match := range matches {
+			paths = append(paths, filepath.Join(dir, match))
+		}
+	}
+
+	for _, dependency := range chart.Dependencies {
+		if chart_dir, ok := strings.CutPrefix(dependency.Repository, "file://"); ok {
+			addIfExists(filepath.Join(dir, chart_dir, "Chart.yaml"))
+		}
+	}
+	return paths, nil
+}
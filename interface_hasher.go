@@ -0,0 +1,139 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Experimental: dependencies reached only through `interface_only` edges are hashed by their
+// public interface instead of their whole content, so implementation-only changes don't
+// invalidate their consumers.
+
+var python_interface_def_parser = regexp.MustCompile(`^(?:async\s+)?(def|class)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+var python_interface_const_parser = regexp.MustCompile(`^[A-Z][A-Z0-9_]*\s*(?::[^=]*)?=`)
+
+// Returns whether all of the edge's origins are `interface_only` rules
+func (origins EdgeOrigins) isInterfaceOnly(edge Edge) bool {
+	edge_origins := origins[edge]
+	for _, origin := range edge_origins {
+		if !origin.InterfaceOnly {
+			return false
+		}
+	}
+	return len(edge_origins) != 0
+}
+
+// Returns the dependencies that are reachable only through `interface_only` edges
+func interfaceOnlyDeps(
+	file_relation_map map[string][]string,
+	file_name string,
+	dep_list []string,
+	follow func(edge Edge) bool,
+	edge_origins EdgeOrigins,
+) map[string]bool {
+	fully_reachable := map[string]bool{}
+	for _, dep := range BuildFilteredDepList(file_relation_map, file_name, func(edge Edge) bool {
+		return (follow == nil || follow(edge)) && !edge_origins.isInterfaceOnly(edge)
+	}) {
+		fully_reachable[dep] = true
+	}
+	interface_only := map[string]bool{}
+	for _, dep := range dep_list {
+		if !fully_reachable[dep] {
+			interface_only[dep] = true
+		}
+	}
+	return interface_only
+}
+
+// Calculate the interface hash of every Python file. Other files are hashed by their content.
+func CalculateInterfaceHashes(
+	all_files_set map[string]bool,
+	config *Config,
+	base_dir string,
+) (map[string][32]byte, error) {
+	interfaceHashes := map[string][32]byte{}
+	for file := range all_files_set {
+		if _, _, is_generated := config.GeneratedSources(file); is_generated {
+			continue
+		}
+		if !strings.HasSuffix(file, ".py") && !strings.HasSuffix(file, ".pyi") {
+			continue
+		}
+		file_data, err := readRepoFile(config, filepath.Join(base_dir, file))
+		if err != nil {
+			if err := config.addUnreadableFile(file, err); err != nil {
+				return nil, err
+			}
+			// Falls back to the (random) file hash
+			continue
+		}
+		hasher := config.fileHashAlgorithm().new()
+		hasher.Write([]byte(extractPythonInterface(string(file_data))))
+		interfaceHashes[file] = [32]byte(hasher.Sum(nil))
+	}
+	return interfaceHashes, nil
+}
+
+// Extract the public interface of a Python file: the signatures of public functions, classes and
+// methods (with their decorators), top-level constants and imports (which may be re-exported).
+// Based on indentation, like the import parser it might break on unusual formatting.
+func extractPythonInterface(data string) string {
+	type block struct {
+		indent   int
+		is_class bool
+	}
+	blocks := []block{}
+	out := strings.Builder{}
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		for len(blocks) > 0 && blocks[len(blocks)-1].indent >= indent {
+			blocks = blocks[:len(blocks)-1]
+		}
+		if len(blocks) > 0 && !blocks[len(blocks)-1].is_class {
+			// Inside a function body
+			continue
+		}
+
+		if match := python_interface_def_parser.FindStringSubmatch(trimmed); match != nil {
+			blocks = append(blocks, block{indent, match[1] == "class"})
+			is_public := !strings.HasPrefix(match[2], "_") || strings.HasSuffix(match[2], "__")
+			// Signatures may span multiple lines, until the brackets are balanced
+			depth := 0
+			for {
+				if is_public {
+					out.WriteString(line)
+					out.WriteByte('\n')
+				}
+				code := stripPythonComment(line)
+				depth += strings.Count(code, "(") + strings.Count(code, "[") -
+					strings.Count(code, ")") - strings.Count(code, "]")
+				if depth <= 0 || i+1 >= len(lines) {
+					break
+				}
+				i++
+				line = strings.TrimRight(lines[i], " \t\r")
+			}
+		} else if trimmed[0] == '@' ||
+			python_interface_const_parser.MatchString(trimmed) ||
+			len(blocks) == 0 && (strings.HasPrefix(trimmed, "import ") || strings.HasPrefix(trimmed, "from ")) {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+func stripPythonComment(line string) string {
+	if idx := strings.IndexByte(line, '#'); idx != -1 {
+		return line[:idx]
+	}
+	return line
+}
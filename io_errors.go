@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How to handle errors reading the repo's files
+type IOErrorPolicy struct {
+	// How many times to retry transient errors. Default: 0, or 3 in `network_filesystem` mode.
+	Retries *int `yaml:"retries"`
+	// The delay before the first retry, doubled on each retry. Default: 50ms.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// Unreadable files are tolerated (with a warning) up to this number, and fail the run beyond it
+	MaxUnreadableFiles int `yaml:"max_unreadable_files"`
+}
+
+// The files that couldn't be read during the run
+type unreadableFiles struct {
+	lock  sync.Mutex
+	files map[string]error
+}
+
+func validateIOErrorPolicy(config *Config) error {
+	if config.IOErrors.Retries != nil && *config.IOErrors.Retries < 0 {
+		return fmt.Errorf("invalid retries %d", *config.IOErrors.Retries)
+	}
+	if config.IOErrors.RetryBackoff < 0 {
+		return fmt.Errorf("invalid retry_backoff %v", config.IOErrors.RetryBackoff)
+	}
+	if config.IOErrors.MaxUnreadableFiles < 0 {
+		return fmt.Errorf("invalid max_unreadable_files %d", config.IOErrors.MaxUnreadableFiles)
+	}
+	return nil
+}
+
+// Returns whether an IO error may go away on retry (e.g. a stale NFS handle or a FUSE timeout)
+func isTransientIOError(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EIO, syscall.ESTALE, syscall.ETIMEDOUT, syscall.EAGAIN, syscall.EINTR, syscall.EBUSY,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run an IO operation, retrying transient errors according to `io_errors`
+func withIORetries[T any](config *Config, op func() (T, error)) (T, error) {
+	retries := 0
+	if config.IOErrors.Retries != nil {
+		retries = *config.IOErrors.Retries
+	} else if config.NetworkFilesystem {
+		retries = NETWORK_FS_READ_RETRIES
+	}
+	backoff := config.IOErrors.RetryBackoff
+	if backoff == 0 {
+		backoff = NETWORK_FS_RETRY_BACKOFF
+	}
+
+	result, err := op()
+	for retry := 0; retry < retries && err != nil && isTransientIOError(err); retry++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		result, err = op()
+	}
+	return result, err
+}
+
+// Read a file of the repo, retrying transient errors
+func readRepoFile(config *Config, path string) ([]byte, error) {
+	return withIORetries(config, func() ([]byte, error) { return os.ReadFile(path) })
+}
+
+// Returns whether the path is an existing regular file, retrying transient errors
+func fileExists(config *Config, path string) bool {
+	file_type, ok := config.statRepoPath(path)
+	return ok && file_type.IsRegular()
+}
+
+// Returns whether an error reading a file counts towards `max_unreadable_files` (permission and IO
+// errors). Other errors, like a missing file, are mistakes in the config or the repo.
+func isUnreadableError(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || isTransientIOError(err)
+}
+
+// Record a file that couldn't be read. Returns an error if this exceeds `max_unreadable_files`, or
+// if it isn't a permission or IO error, otherwise the run continues without the file's contents.
+func (config *Config) addUnreadableFile(file string, err error) error {
+	if !isUnreadableError(err) {
+		return fmt.Errorf("error while reading '%s': %v", file, err)
+	}
+	config.unreadable_files.lock.Lock()
+	defer config.unreadable_files.lock.Unlock()
+	config.unreadable_files.files[file] = err
+	if len(config.unreadable_files.files) > config.IOErrors.MaxUnreadableFiles {
+		return fmt.Errorf(
+			"error while reading '%s' (%d files unreadable, more than max_unreadable_files): %v",
+			file,
+			len(config.unreadable_files.files),
+			err,
+		)
+	}
+	log.Printf("Warning: ignoring unreadable file '%s': %v", file, err)
+	return nil
+}
+
+// The number of files that couldn't be read so far
+func (config *Config) unreadableFileCount() int {
+	config.unreadable_files.lock.Lock()
+	defer config.unreadable_files.lock.Unlock()
+	return len(config.unreadable_files.files)
+}
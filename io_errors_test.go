@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestUnreadableFileBudget(t *testing.T) {
+	config := loadTestConfig(t, writeTestRepo(t, map[string]string{}), "io_errors:\n  max_unreadable_files: 2\n")
+	for _, test := range []struct {
+		err     error
+		counted bool
+	}{
+		{&fs.PathError{Op: "open", Path: "a", Err: syscall.EACCES}, true},
+		{&fs.PathError{Op: "read", Path: "b", Err: syscall.EIO}, true},
+		{&fs.PathError{Op: "open", Path: "c", Err: syscall.ENOENT}, false},
+		{fmt.Errorf("resolving alias: %w", os.ErrNotExist), false},
+	} {
+		before := config.unreadableFileCount()
+		err := config.addUnreadableFile(fmt.Sprintf("file%d", before), test.err)
+		if counted := config.unreadableFileCount() > before; counted != test.counted || (err == nil) != test.counted {
+			t.Errorf("%v: counted %v (%v), expected %v", test.err, counted, err, test.counted)
+		}
+	}
+	if err := config.addUnreadableFile("over", syscall.EACCES); err == nil {
+		t.Error("expected an error beyond max_unreadable_files")
+	}
+}
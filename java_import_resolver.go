@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+var java_import_parser = regexp.MustCompile(`(?m:^[ \t]*import[ \t]+(?:static[ \t]+)?([A-Za-z_][A-Za-z0-9_.]*(?:\.\*)?)(?:[ \t]+as[ \t]+[A-Za-z_][A-Za-z0-9_]*)?[ \t]*(?:;|$))`)
+var kotlin_top_level_parser = regexp.MustCompile(`(?m:^(?:[a-z]+[ \t]+)*(?:fun|val|var|typealias|object|class|interface)[ \t]+(?:<[^>\n]*>[ \t]*)?(?:[A-Za-z_][A-Za-z0-9_.<>]*\.)?([A-Za-z_][A-Za-z0-9_]*))`)
+
+var jvm_source_extensions = []string{".java", ".kt", ".kts"}
+
+// Resolves `import` statements of Java and Kotlin files to source files of either language under
+// the `java_source_roots`.
+// Wildcard imports visit all files of the package, and imports of nested classes or static
+// members visit the file of the outermost class. Kotlin declarations that aren't in a file
+// named after them (e.g. top-level functions) are found by scanning the package's Kotlin files.
+type JavaImportResolver struct {
+	source_roots []string
+	cache        map[string][]string
+	// Package directory -> top-level declaration name -> Kotlin files declaring it
+	kotlin_declarations map[string]map[string][]string
+}
+
+// Find the source root directories, which may be glob patterns (e.g. "**/src/main/java")
+func (res *JavaImportResolver) loadSourceRoots(config *Config, base_dir string) error {
+	res.source_roots = []string{}
+	res.cache = map[string][]string{}
+	res.kotlin_declarations = map[string]map[string][]string{}
+	for _, pattern := range config.JavaSourceRoots.items {
+		matches, err := doublestar.Glob(config.repoFS(base_dir), pattern, doublestar.WithFailOnIOErrors())
+		if err != nil {
+			return fmt.Errorf("error while finding java source roots '%s': %v", pattern, err)
+		}
+		for _, match := range matches {
+			if dirExists(config, filepath.Join(base_dir, match)) {
+				res.source_roots = append(res.source_roots, match)
+			}
+		}
+	}
+	slices.Sort(res.source_roots)
+	res.source_roots = slices.Compact(res.source_roots)
+	return nil
+}
+
+func (res *JavaImportResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.source_roots == nil {
+		err := res.loadSourceRoots(config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	paths := []string{}
+	for _, match := range java_import_parser.FindAllStringSubmatch(file_data, -1) {
+		import_paths, err := res.resolveImport(match[1], config, base_dir)
+		if err != nil {
+			return nil, fmt.Errorf("error while resolving java import '%s': %v", match[1], err)
+		}
+		paths = append(paths, import_paths...)
+	}
+	return paths, nil
+}
+
+func (res *JavaImportResolver) resolveImport(import_name string, config *Config, base_dir string) ([]string, error) {
+	if cached, ok := res.cache[import_name]; ok {
+		return cached, nil
+	}
+
+	paths := []string{}
+	name, is_wildcard := strings.CutSuffix(import_name, ".*")
+	segments := strings.Split(name, ".")
+	for _, root := range res.source_roots {
+		// A wildcard import of a package
+		if is_wildcard {
+			package_dir := filepath.Join(root, filepath.Join(segments...))
+			entries, err := readRepoDir(config, filepath.Join(base_dir, package_dir))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if entry.Type().IsRegular() && slices.Contains(jvm_source_extensions, filepath.Ext(entry.Name())) {
+					paths = append(paths, filepath.Join(package_dir, entry.Name()))
+				}
+			}
+		}
+		// A class, or a member of one (nested class, static member or a wildcard of those)
+		for i := len(segments); i > 0; i-- {
+			found := false
+			for _, ext := range jvm_source_extensions {
+				candidate := filepath.Join(root, filepath.Join(segments[:i]...)) + ext
+				if fileExists(config, filepath.Join(base_dir, candidate)) {
+					paths = append(paths, candidate)
+					found = true
+				}
+			}
+			if !found {
+				package_dir := filepath.Join(root, filepath.Join(segments[:i-1]...))
+				declarations, err := res.kotlinDeclarations(package_dir, config, base_dir)
+				if err != nil {
+					return nil, err
+				}
+				declaring_files := declarations[segments[i-1]]
+				paths = append(paths, declaring_files...)
+				found = len(declaring_files) != 0
+			}
+			if found {
+				break
+			}
+		}
+	}
+
+	res.cache[import_name] = paths
+	return paths, nil
+}
+
+// Returns the top-level declarations of the Kotlin files in a package directory
+func (res *JavaImportResolver) kotlinDeclarations(package_dir string, config *Config, base_dir string) (map[string][]string, error) {
+	if declarations, ok := res.kotlin_declarations[package_dir]; ok {
+		return declarations, nil
+	}
+	declarations := map[string][]string{}
+	entries, err := readRepoDir(config, filepath.Join(base_dir, package_dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || (filepath.Ext(entry.Name()) != ".kt" && filepath.Ext(entry.Name()) != ".kts") {
+			continue
+		}
+		file := filepath.Join(package_dir, entry.Name())
+		file_data, err := os.ReadFile(filepath.Join(base_dir, file))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range kotlin_top_level_parser.FindAllStringSubmatch(string(file_data), -1) {
+			if !slices.Contains(declarations[match[1]], file) {
+				declarations[match[1]] = append(declarations[match[1]], file)
+			}
+		}
+	}
+	res.kotlin_declarations[package_dir] = declarations
+	return declarations, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var jinja_tag_parser = regexp.MustCompile(`\{%[-+]?\s*(include|extends|import|from)\s+((?s:.*?))[-+]?%\}`)
+var jinja_string_parser = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+
+// Resolves the templates referenced by `{% include %}`, `{% extends %}`, `{% import %}` and
+// `{% from ... import %}` tags of Jinja templates. Like Jinja's FileSystemLoader, names are
+// searched in the `jinja_template_roots` in order, and the first root containing the template wins.
+// All string literals in the tag are visited (e.g. lists in `include`, or both branches of a
+// conditional `extends`), and templates chosen by variables are ignored.
+type JinjaResolver struct{}
+
+func (res *JinjaResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	paths := []string{}
+	for _, match := range jinja_tag_parser.FindAllStringSubmatch(file_data, -1) {
+		expression := match[2]
+		// Only the template name, not the imported names or the context modifiers
+		for _, separator := range []string{" import ", " as ", " with ", " without ", " ignore "} {
+			expression, _, _ = strings.Cut(expression, separator)
+		}
+		for _, name_match := range jinja_string_parser.FindAllStringSubmatch(expression, -1) {
+			name := name_match[1] + name_match[2]
+			for _, root := range config.JinjaTemplateRoots.items {
+				path := filepath.Join(root, name)
+				if !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+					paths = append(paths, path)
+					break
+				}
+			}
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var kustomization_files = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// Resolves a `kustomization.yaml` to the files it composes: `resources`, `bases` and `components`
+// (files, or the kustomization of directories, which should be matched by the rule too to recurse),
+// patches, replacements, generator/transformer configs, and the `files`/`envs` of
+// `configMapGenerator` and `secretGenerator`. Remote resources (URLs) are ignored.
+type KustomizeResolver struct{}
+
+type kustomizePathEntry struct {
+	Path string `yaml:"path"`
+}
+
+type kustomizeGenerator struct {
+	Files []string `yaml:"files"`
+	Envs  []string `yaml:"envs"`
+	Env   string   `yaml:"env"`
+}
+
+type kustomization struct {
+	Resources             []string             `yaml:"resources"`
+	Bases                 []string             `yaml:"bases"`
+	Components            []string             `yaml:"components"`
+	Crds                  []string             `yaml:"crds"`
+	Generators            []string             `yaml:"generators"`
+	Transformers          []string             `yaml:"transformers"`
+	Validators            []string             `yaml:"validators"`
+	Configurations        []string             `yaml:"configurations"`
+	Patches               []kustomizePathEntry `yaml:"patches"`
+	PatchesJson6902       []kustomizePathEntry `yaml:"patchesJson6902"`
+	Replacements          []kustomizePathEntry `yaml:"replacements"`
+	PatchesStrategicMerge []string             `yaml:"patchesStrategicMerge"`
+	ConfigMapGenerator    []kustomizeGenerator `yaml:"configMapGenerator"`
+	SecretGenerator       []kustomizeGenerator `yaml:"secretGenerator"`
+}
+
+// Returns the kustomization file of a directory, "" if there's none
+func kustomizationFile(dir string, config *Config, base_dir string) string {
+	for _, name := range kustomization_files {
+		file := filepath.Join(dir, name)
+		if fileExists(config, filepath.Join(base_dir, file)) {
+			return file
+		}
+	}
+	return ""
+}
+
+func (res *KustomizeResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if !strings.HasPrefix(filepath.Base(file), "kustomization.") && filepath.Base(file) != "Kustomization" {
+		return nil, nil
+	}
+	k := kustomization{}
+	if err := yaml.Unmarshal([]byte(file_data), &k); err != nil {
+		return nil, fmt.Errorf("error while parsing kustomization: %v", err)
+	}
+	dir := filepath.Dir(file)
+	paths := []string{}
+	// Adds a file, or the kustomization of a directory
+	addReference := func(reference string) {
+		if reference == "" || strings.Contains(reference, "://") || strings.Contains(reference, "\n") {
+			// A URL, or an inline patch
+			return
+		}
+		path := filepath.Join(dir, reference)
+		if strings.HasPrefix(path, "..") {
+			return
+		}
+		if dirExists(config, filepath.Join(base_dir, path)) {
+			if kustomization := kustomizationFile(path, config, base_dir); kustomization != "" {
+				paths = append(paths, kustomization)
+			}
+		} else if fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, references := range [][]string{
+		k.Resources, k.Bases, k.Components, k.Crds, k.Generators, k.Transformers, k.Validators,
+		k.Configurations, k.PatchesStrategicMerge,
+	} {
+		for _, reference := range references {
+			addReference(reference)
+		}
+	}
+	for _, entries := range [][]kustomizePathEntry{k.Patches, k.PatchesJson6902, k.Replacements} {
+		for _, entry := range entries {
+			addReference(entry.Path)
+		}
+	}
+	for _, generator := range append(k.ConfigMapGenerator, k.SecretGenerator...) {
+		for _, generator_file := range generator.Files {
+			// `key=path` sets the key of the file in the ConfigMap
+			if _, path, ok := strings.Cut(generator_file, "="); ok {
+				generator_file = path
+			}
+			addReference(generator_file)
+		}
+		for _, env := range append(generator.Envs, generator.Env) {
+			addReference(env)
+		}
+	}
+	return paths, nil
+}
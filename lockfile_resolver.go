@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var poetry_lock_package_parser = regexp.MustCompile(`(?m:^\[\[package\]\]\s*\nname\s*=\s*"([^"]+)"\s*\nversion\s*=\s*"([^"]+)")`)
+var requirements_pin_parser = regexp.MustCompile(`(?m:^\s*([A-Za-z0-9][A-Za-z0-9._-]*)\s*(?:\[[^\]]*\])?\s*===?\s*([^\s;#\\]+))`)
+var go_mod_require_parser = regexp.MustCompile(`(?m:^(?:require\s+|\t)([^\s()]+)\s+(v[^\s]+))`)
+var js_import_parser = regexp.MustCompile(`(?:\bfrom\s*|\bimport\s*\(?\s*|\brequire\s*\(\s*)["']([^"'./][^"']*)["']`)
+
+// Lockfile ecosystems, by the extensions of the files importing their packages
+var lockfile_ecosystems = map[string]string{
+	".py": "pypi", ".pyi": "pypi", ".pyx": "pypi",
+	".js": "npm", ".jsx": "npm", ".mjs": "npm", ".cjs": "npm", ".ts": "npm", ".tsx": "npm", ".mts": "npm", ".cts": "npm",
+	".go": "go",
+}
+
+// Resolves the third-party packages imported by Python, JavaScript/TypeScript and Go files to
+// abstract nodes of their locked versions (e.g. `pkg://pypi/numpy==1.26.0`), from the nearest
+// of the `lockfiles` above the file (`poetry.lock`, `uv.lock`, pinned `requirements*.txt`,
+// `package-lock.json`, `go.sum` or `go.mod`).
+// Bumping a package only changes the hashes of the files that import it. Imports of packages that
+// aren't in the lockfile (e.g. first-party or standard library ones) are ignored.
+type LockfileResolver struct {
+	lockfiles []*lockfilePackages
+}
+
+type lockfilePackages struct {
+	ecosystem string
+	dir       string
+	// Package name -> locked version
+	versions map[string]string
+}
+
+// Normalize a Python distribution name (PEP 503)
+func normalizePythonPackage(name string) string {
+	return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(name))
+}
+
+func parseLockfile(path string, data []byte) (*lockfilePackages, error) {
+	lockfile := &lockfilePackages{dir: filepath.Dir(path), versions: map[string]string{}}
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, "requirements") && strings.HasSuffix(name, ".txt") {
+		name = "requirements.txt"
+	}
+	switch name {
+	case "poetry.lock", "uv.lock":
+		// Both list packages as `[[package]]` tables starting with their name and version
+		lockfile.ecosystem = "pypi"
+		for _, match := range poetry_lock_package_parser.FindAllStringSubmatch(string(data), -1) {
+			lockfile.versions[normalizePythonPackage(match[1])] = match[2]
+		}
+	case "requirements.txt":
+		// Only pinned requirements (`name==version`), as from `pip freeze` or `pip-compile`
+		lockfile.ecosystem = "pypi"
+		for _, match := range requirements_pin_parser.FindAllStringSubmatch(string(data), -1) {
+			lockfile.versions[normalizePythonPackage(match[1])] = match[2]
+		}
+	case "package-lock.json":
+		lockfile.ecosystem = "npm"
+		var lock struct {
+			Packages map[string]struct {
+				Version string `json:"version"`
+			} `json:"packages"`
+			Dependencies map[string]struct {
+				Version string `json:"version"`
+			} `json:"dependencies"`
+		}
+		if err := json.Unmarshal(data, &lock); err != nil {
+			return nil, err
+		}
+		// Lockfile v1 only has `dependencies`, v2 has both, and v3 only `packages`
+		for name, dependency := range lock.Dependencies {
+			lockfile.versions[name] = dependency.Version
+		}
+		for package_path, dependency := range lock.Packages {
+			// Only the top-level packages, the ones imports resolve to
+			name, ok := strings.CutPrefix(package_path, "node_modules/")
+			if ok && !strings.Contains(name, "/node_modules/") {
+				lockfile.versions[name] = dependency.Version
+			}
+		}
+	case "go.sum":
+		lockfile.ecosystem = "go"
+		// Sorted by version, so the last one is the highest (the selected one)
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 3 && !strings.HasSuffix(fields[1], "/go.mod") {
+				lockfile.versions[fields[0]] = fields[1]
+			}
+		}
+	case "go.mod":
+		lockfile.ecosystem = "go"
+		for _, match := range go_mod_require_parser.FindAllStringSubmatch(string(data), -1) {
+			lockfile.versions[match[1]] = match[2]
+		}
+	default:
+		return nil, fmt.Errorf("unknown lockfile type, expected poetry.lock, uv.lock, requirements*.txt, package-lock.json, go.sum or go.mod")
+	}
+	return lockfile, nil
+}
+
+// Returns the nearest lockfile of the ecosystem above the file
+func (res *LockfileResolver) findLockfile(file string, ecosystem string) *lockfilePackages {
+	var nearest *lockfilePackages
+	for _, lockfile := range res.lockfiles {
+		if lockfile.ecosystem != ecosystem {
+			continue
+		}
+		if lockfile.dir != "." && !strings.HasPrefix(file, lockfile.dir+"/") {
+			continue
+		}
+		if nearest == nil || len(lockfile.dir) > len(nearest.dir) {
+			nearest = lockfile
+		}
+	}
+	return nearest
+}
+
+// The packages a file imports, by their import names
+func importedPackages(file string, file_data string, ecosystem string) ([]string, error) {
+	packages := []string{}
+	switch ecosystem {
+	case "pypi":
+		for _, statement := range parsePythonImports(file_data) {
+			modules := []string{statement.from}
+			if statement.from == "" {
+				modules = []string{}
+				for _, imported := range statement.names {
+					modules = append(modules, imported.name)
+				}
+			}
+			for _, module := range modules {
+				if !strings.HasPrefix(module, ".") {
+					top_level, _, _ := strings.Cut(module, ".")
+					packages = append(packages, top_level)
+				}
+			}
+		}
+	case "npm":
+		for _, match := range js_import_parser.FindAllStringSubmatch(file_data, -1) {
+			segments := strings.SplitN(match[1], "/", 3)
+			if strings.HasPrefix(match[1], "@") && len(segments) >= 2 {
+				packages = append(packages, segments[0]+"/"+segments[1])
+			} else {
+				packages = append(packages, segments[0])
+			}
+		}
+	case "go":
+		parsed, err := parser.ParseFile(token.NewFileSet(), file, file_data, parser.ImportsOnly)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing go file: %v", err)
+		}
+		for _, import_spec := range parsed.Imports {
+			if import_path, err := strconv.Unquote(import_spec.Path.Value); err == nil {
+				packages = append(packages, import_path)
+			}
+		}
+	}
+	return packages, nil
+}
+
+// Parses the `lockfiles` on first use
+func (res *LockfileResolver) load(config *Config, base_dir string) error {
+	if res.lockfiles != nil {
+		return nil
+	}
+	res.lockfiles = []*lockfilePackages{}
+	for _, path := range config.Lockfiles.items {
+		data, err := readRepoFile(config, filepath.Join(base_dir, path))
+		if err != nil {
+			return fmt.Errorf("error while reading lockfile '%s': %v", path, err)
+		}
+		lockfile, err := parseLockfile(path, data)
+		if err != nil {
+			return fmt.Errorf("error while parsing lockfile '%s': %v", path, err)
+		}
+		res.lockfiles = append(res.lockfiles, lockfile)
+	}
+	return nil
+}
+
+// Returns the abstract node of the locked version of an imported package, if it's in the nearest
+// lockfile of the ecosystem above the file
+func (res *LockfileResolver) LockedPackage(
+	file string, ecosystem string, name string, config *Config, base_dir string,
+) (string, bool, error) {
+	if err := res.load(config, base_dir); err != nil {
+		return "", false, err
+	}
+	lockfile := res.findLockfile(file, ecosystem)
+	if lockfile == nil {
+		return "", false, nil
+	}
+	if alias, ok := config.PackageAliases[name]; ok {
+		name = alias
+	}
+	switch ecosystem {
+	case "pypi":
+		name = normalizePythonPackage(name)
+		if version, ok := lockfile.versions[name]; ok {
+			return "pkg://pypi/" + name + "==" + version, true, nil
+		}
+	case "npm":
+		if version, ok := lockfile.versions[name]; ok {
+			return "pkg://npm/" + name + "@" + version, true, nil
+		}
+	case "go":
+		// The module is the longest prefix of the package path in the lockfile
+		for module := name; module != "." && module != "/"; module = filepath.Dir(module) {
+			if version, ok := lockfile.versions[module]; ok {
+				return "pkg://go/" + module + "@" + version, true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+func (res *LockfileResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	ecosystem, ok := lockfile_ecosystems[filepath.Ext(file)]
+	if !ok {
+		return nil, nil
+	}
+	if err := res.load(config, base_dir); err != nil {
+		return nil, err
+	}
+	if res.findLockfile(file, ecosystem) == nil {
+		return nil, nil
+	}
+	imports, err := importedPackages(file, file_data, ecosystem)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []string{}
+	for _, name := range imports {
+		node, ok, err := res.LockedPackage(file, ecosystem, name, config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
@@ -1,13 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/binary"
-	"encoding/json"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -21,11 +19,15 @@ import (
 
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/wazzaps/repo_dagger/pkg/schema"
 	"golang.org/x/sync/semaphore"
 )
 
 // This value is bumped any time the program may output different output given the same input
 const ALGORITHM_VERSION uint64 = 1
+
+// The version with `file_hash_algorithm: sha512_256`, which has its own file hashes
+const ALGORITHM_VERSION_SHA512_256 uint64 = 1<<32 | ALGORITHM_VERSION
 const VERSION = "1.4.0"
 
 type StatsSortVal int
@@ -45,18 +47,95 @@ func StatsSortValFromString(val string) (StatsSortVal, error) {
 }
 
 type Args struct {
-	Config              string
-	Verbose             bool
-	InputFiles          []string
-	PrintDepStats       bool
-	PrintRevDepStats    bool
-	StatsSort           StatsSortVal
-	SelfProfile         bool
-	OutDepHashes        string
-	OutRelations        string
-	OutRecursiveDeps    string
-	OutRecursiveDepsFor string
-	HashSalt            string
+	Config               string
+	ConfigSha256         string
+	Verbose              bool
+	InputFiles           []string
+	PrintDepStats        bool
+	PrintRevDepStats     bool
+	PrintRuleStats       bool
+	StatsSort            StatsSortVal
+	StatsHashEnv         string
+	SelfProfile          bool
+	OutDepHashes         string
+	OutEnvDepHashes      string
+	OutRelations         string
+	OutRelationsCounts   string
+	OutReducedRelations  string
+	OutFileHashes        string
+	OutParquetNodes      string
+	OutParquetEdges      string
+	OutRecursiveDeps     string
+	OutRecursiveDepsFor  string
+	ProposedConfig       string
+	OutConfigImpact      string
+	HashSalt             string
+	HashToolBinary       bool
+	ToolchainFingerprint string
+	InterfaceHashes      bool
+	NetworkFS            bool
+	AssertReadOnly       bool
+	SignKey              string
+	OutDepHashesSig      string
+	OutGraphSnapshot     string
+	OutConfigGraph       string
+	ChangedFiles         []string
+	OutAffected          string
+	SimulateChange       string
+	TargetDurations      string
+	OutSchedule          string
+	PrintOptionalImports bool
+	OutTargetGraph       string
+	OutOwnerMatrix       string
+	PathRewrites         []PathRewrite
+	MaxOutputBytes       int64
+	OutRunManifest       string
+	OutUnresolvedImports string
+	OutProvenance        string
+	ProvenanceBuilderID  string
+	Defines              configDefines
+}
+
+// The output files given on the command line, by flag name
+func (args *Args) outputPaths() map[string]string {
+	return map[string]string{
+		"out-dep-hashes":            args.OutDepHashes,
+		"out-dep-hashes-sig":        args.OutDepHashesSig,
+		"out-env-dep-hashes":        args.OutEnvDepHashes,
+		"out-relations":             args.OutRelations,
+		"out-relations-with-counts": args.OutRelationsCounts,
+		"out-reduced-relations":     args.OutReducedRelations,
+		"out-file-hashes":           args.OutFileHashes,
+		"out-parquet-nodes":         args.OutParquetNodes,
+		"out-parquet-edges":         args.OutParquetEdges,
+		"out-recursive-deps":        args.OutRecursiveDeps,
+		"out-config-impact":         args.OutConfigImpact,
+		"out-graph-snapshot":        args.OutGraphSnapshot,
+		"out-config-graph":          args.OutConfigGraph,
+		"out-affected":              args.OutAffected,
+		"out-schedule":              args.OutSchedule,
+		"out-target-graph":          args.OutTargetGraph,
+		"out-owner-matrix":          args.OutOwnerMatrix,
+		"out-run-manifest":          args.OutRunManifest,
+		"out-unresolved-imports":    args.OutUnresolvedImports,
+		"out-provenance":            args.OutProvenance,
+	}
+}
+
+// The flags of the outputs written to stdout (`-`), sorted
+func (args *Args) stdoutOutputs() []string {
+	flag_names := []string{}
+	for flag_name, path := range args.outputPaths() {
+		if path == STDOUT_OUTPUT {
+			flag_names = append(flag_names, flag_name)
+		}
+	}
+	slices.Sort(flag_names)
+	return flag_names
+}
+
+func (args *Args) needsDepHashes() bool {
+	return args.OutDepHashes != "" || args.OutEnvDepHashes != ""
 }
 
 func parseArgs() (*Args, error) {
@@ -64,24 +143,61 @@ func parseArgs() (*Args, error) {
 	version := false
 	flag.BoolVar(&version, "v", false, "Print version and exit")
 	flag.BoolVar(&version, "version", false, "Print version and exit")
-	config := flag.String("config", "", "Path to config file")
+	config := flag.String("config", "", "Path or http(s) URL of config file")
+	config_sha256 := flag.String("config-sha256", "", "Fail unless the config file has this sha256 checksum, combined with the checksums of the configs it includes (pinning for remote configs)")
+	defines := configDefines{}
+	flag.Var(defines, "define", "Resolve '${NAME}' in the config to this value, as 'NAME=value' (may be repeated, overrides the environment)")
 	verbose := flag.Bool("verbose", false, "Verbose output")
 	input_files := flag.String("input-files", "", "Comma separated list of input files (overrides config)")
 	print_dep_stats := flag.Bool("print-dep-stats", false, "Print forward dependency statistics")
 	print_rev_stats := flag.Bool("print-rev-dep-stats", false, "Print reverse dependency statistics")
-	stats_sort := flag.String("stats-sort", "count", "Sort statistics by 'count' or 'name'")
+	print_rule_stats := flag.Bool("print-rule-stats", false, "Print how many edges and files each rule produced")
+	print_optional_imports := flag.Bool("print-optional-imports", false, "Print the edges created by imports marked optional ('mark_optional_imports' rules)")
+	stats_sort := flag.String("stats-sort", "count", "Sort statistics by 'count' (ties by name) or 'name'")
+	stats_hash_env := flag.String("stats-hash-env", "", "Calculate statistics with the edge filter of this hash environment (e.g. to ignore test edges)")
 	self_profile := flag.Bool("self-profile", false, "Profile the program into 'repo_dagger.prof'")
 	out_dep_hashes := flag.String("out-dep-hashes", "", "Output dependency hashes to the specified file")
+	out_env_dep_hashes := flag.String("out-env-dep-hashes", "", "Output dependency hashes of each of the config's 'hash_environments' to the specified file")
 	out_relations := flag.String("out-relations", "", "Output relations to the specified file")
+	out_relations_counts := flag.String("out-relations-with-counts", "", "Output relations with the direct dependency and dependent counts of each file to the specified file")
+	out_target_graph := flag.String("out-target-graph", "", "Output which targets of the config's 'target_graph' depend on which (in the relations format) to the specified file")
+	out_owner_matrix := flag.String("out-owner-matrix", "", "Output how many edges lead from the files of each CODEOWNERS owner to the files of each other owner to the specified file")
+	out_unresolved_imports := flag.String("out-unresolved-imports", "", "Output the imports of 'root_python_packages' that didn't resolve to any file, with the importing file and line, to the specified file")
+	rewrite_path_prefixes := flag.String("rewrite-path-prefixes", "", "Comma separated 'from=to' path prefixes to replace in all outputs (an empty 'to' strips the prefix), e.g. to hide internal structure in shared outputs")
+	max_output_bytes := flag.Int64("max-output-bytes", 0, "Split JSON and parquet outputs larger than this into numbered chunks, writing an index of the chunks in place of the output (0 for no limit)")
+	out_run_manifest := flag.String("out-run-manifest", "", "Output a manifest of the run (flags, config hash, inputs, the hashes of the outputs written, timings and warnings) to the specified file")
+	out_reduced_relations := flag.String("out-reduced-relations", "", "Output the transitive reduction of the relations (for visualization) to the specified file")
+	out_parquet_nodes := flag.String("out-parquet-nodes", "", "Output all nodes (path, size, hash) as a parquet table to the specified file")
+	out_parquet_edges := flag.String("out-parquet-edges", "", "Output all edges (src, dst, type, rule, conditional, python_import) as a parquet table to the specified file")
+	out_file_hashes := flag.String("out-file-hashes", "", "Output the hash of each file to the specified file (e.g. for use in 'federated_repos')")
 	out_recursive_deps := flag.String("out-recursive-deps", "", "Output recursive dependencies of the input file specified in '-out-recursive-deps-for' to the specified file")
+	proposed_config := flag.String("proposed-config", "", "Compare the graph of the config with the graph of this proposed config, writing the result to '-out-config-impact'")
+	out_config_impact := flag.String("out-config-impact", "", "Output which inputs' dependencies and hashes change with the '-proposed-config' to the specified file")
 	out_recursive_deps_for := flag.String("out-recursive-deps-for", "", "Output recursive dependencies for the specified input file to the file specified in '-out-recursive-deps'")
 	hash_salt := flag.String("hash-salt", "", "Include this string in the dependency hash calculation. Use for cache busting.")
+	hash_tool_binary := flag.Bool("hash-tool-binary", false, "Include the hash of the repo_dagger binary itself in the dependency hash calculation")
+	interface_hashes := flag.Bool("experimental-interface-hashes", false, "Experimental: hash dependencies reached only through 'interface_only' rules by their public interface (Python only)")
+	network_fs := flag.Bool("network-fs", false, "Tune file access for checkouts on network filesystems like NFS/FUSE (overrides config's 'network_filesystem')")
+	out_config_graph := flag.String("out-config-graph", "", "Output which config files include which (in the relations format) to the specified file")
+	out_graph_snapshot := flag.String("out-graph-snapshot", "", "Output everything '-out-dep-hashes' covers other than file hashes to the specified file, check with 'repo_dagger self-check'")
+	sign_key := flag.String("sign-key", "", "Sign the '-out-dep-hashes' file with this Ed25519 private key (PEM), check with 'repo_dagger verify-signature'")
+	out_dep_hashes_sig := flag.String("out-dep-hashes-sig", "", "Output the signature of '-out-dep-hashes' to the specified file (default: its path with '.sig' appended)")
+	out_provenance := flag.String("out-provenance", "", "Output an in-toto statement with SLSA provenance of the dependency hash outputs (their digests, the flags, the config and the hash of each file) to the specified file")
+	provenance_builder_id := flag.String("provenance-builder-id", DEFAULT_PROVENANCE_BUILDER, "The builder ID of -out-provenance (e.g. the URI of the CI system running repo_dagger)")
+	assert_read_only := flag.Bool("assert-read-only", false, "Fail on any write other than the output files given on the command line (and their temporary files next to them), and log every output file created (for sandboxed pipelines)")
+	changed_files := flag.String("changed-files", "", "Comma separated list of changed files, for '-out-affected'")
+	simulate_change := flag.String("simulate-change", "", "Print which inputs' hashes would change if the files in the graph matching this glob were modified (also written to '-out-affected' if given)")
+	out_affected := flag.String("out-affected", "", "Output which inputs the '-changed-files' affect, and why (direct, transitive, global_dep or config), to the specified file")
+	target_durations := flag.String("target-durations", "", "JSON file of input file -> duration in seconds, for '-out-schedule'")
+	out_schedule := flag.String("out-schedule", "", "Output the critical path through the inputs (ordered by which inputs depend on others) and the earliest each can run, given the '-target-durations', to the specified file")
+	toolchain_fingerprint := flag.String("toolchain-fingerprint", "", "Include this toolchain fingerprint (e.g. codegen tool versions) in the dependency hash calculation")
 
 	// Parse command line args
 	flag.Parse()
 
 	if version {
 		fmt.Printf("version\t%s\n", VERSION)
+		fmt.Printf("schema\t%d\n", schema.Version)
 		build_info, ok := debug.ReadBuildInfo()
 		if ok {
 			fmt.Printf("%v", build_info)
@@ -101,30 +217,150 @@ func parseArgs() (*Args, error) {
 	if (*out_recursive_deps == "") != (*out_recursive_deps_for == "") {
 		return nil, fmt.Errorf("both -out-recursive-deps and -out-recursive-deps-for must be specified together")
 	}
+	if (*proposed_config == "") != (*out_config_impact == "") {
+		return nil, fmt.Errorf("both -proposed-config and -out-config-impact must be specified together")
+	}
+	if *simulate_change != "" {
+		if *changed_files != "" {
+			return nil, fmt.Errorf("-simulate-change and -changed-files can't be used together")
+		}
+		if !doublestar.ValidatePattern(*simulate_change) {
+			return nil, fmt.Errorf("invalid -simulate-change pattern '%s'", *simulate_change)
+		}
+	} else if (*changed_files == "") != (*out_affected == "") {
+		return nil, fmt.Errorf("both -changed-files and -out-affected must be specified together")
+	}
+	if (*target_durations == "") != (*out_schedule == "") {
+		return nil, fmt.Errorf("both -target-durations and -out-schedule must be specified together")
+	}
+	if *out_graph_snapshot != "" && *out_dep_hashes == "" {
+		return nil, fmt.Errorf("-out-graph-snapshot requires -out-dep-hashes")
+	}
+	if *sign_key != "" && *out_dep_hashes == "" {
+		return nil, fmt.Errorf("-sign-key requires -out-dep-hashes")
+	}
+	if *out_dep_hashes_sig != "" && *sign_key == "" {
+		return nil, fmt.Errorf("-out-dep-hashes-sig requires -sign-key")
+	}
+	if *sign_key != "" && *out_dep_hashes_sig == "" {
+		if *out_dep_hashes == STDOUT_OUTPUT {
+			return nil, fmt.Errorf("-sign-key with dependency hashes written to stdout requires -out-dep-hashes-sig")
+		}
+		*out_dep_hashes_sig = *out_dep_hashes + ".sig"
+	}
+	if *out_provenance != "" {
+		if *out_dep_hashes == "" && *out_env_dep_hashes == "" {
+			return nil, fmt.Errorf("-out-provenance requires -out-dep-hashes or -out-env-dep-hashes")
+		}
+		if *out_dep_hashes == STDOUT_OUTPUT || *out_env_dep_hashes == STDOUT_OUTPUT {
+			return nil, fmt.Errorf("-out-provenance requires the dependency hashes to be written to files, not stdout")
+		}
+	}
+	if *assert_read_only && *self_profile {
+		return nil, fmt.Errorf("-self-profile writes 'repo_dagger.prof', which isn't allowed with -assert-read-only")
+	}
+	if *max_output_bytes < 0 {
+		return nil, fmt.Errorf("-max-output-bytes must not be negative")
+	}
+	path_rewrites, err := parsePathRewrites(*rewrite_path_prefixes)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Args{
-		Config:              *config,
-		Verbose:             *verbose,
-		InputFiles:          strings.Split(*input_files, ","),
-		PrintDepStats:       *print_dep_stats,
-		PrintRevDepStats:    *print_rev_stats,
-		StatsSort:           stats_sort_val,
-		SelfProfile:         *self_profile,
-		OutDepHashes:        *out_dep_hashes,
-		OutRelations:        *out_relations,
-		OutRecursiveDeps:    *out_recursive_deps,
-		OutRecursiveDepsFor: *out_recursive_deps_for,
-		HashSalt:            *hash_salt,
-	}, nil
+	args := &Args{
+		Config:               *config,
+		ConfigSha256:         *config_sha256,
+		Verbose:              *verbose,
+		InputFiles:           strings.Split(*input_files, ","),
+		PrintDepStats:        *print_dep_stats,
+		PrintRevDepStats:     *print_rev_stats,
+		PrintRuleStats:       *print_rule_stats,
+		StatsSort:            stats_sort_val,
+		StatsHashEnv:         *stats_hash_env,
+		SelfProfile:          *self_profile,
+		OutDepHashes:         *out_dep_hashes,
+		OutEnvDepHashes:      *out_env_dep_hashes,
+		OutRelations:         *out_relations,
+		OutRelationsCounts:   *out_relations_counts,
+		OutReducedRelations:  *out_reduced_relations,
+		OutFileHashes:        *out_file_hashes,
+		OutParquetNodes:      *out_parquet_nodes,
+		OutParquetEdges:      *out_parquet_edges,
+		OutRecursiveDeps:     *out_recursive_deps,
+		OutRecursiveDepsFor:  *out_recursive_deps_for,
+		ProposedConfig:       *proposed_config,
+		OutConfigImpact:      *out_config_impact,
+		HashSalt:             *hash_salt,
+		HashToolBinary:       *hash_tool_binary,
+		ToolchainFingerprint: *toolchain_fingerprint,
+		InterfaceHashes:      *interface_hashes,
+		NetworkFS:            *network_fs,
+		AssertReadOnly:       *assert_read_only,
+		SignKey:              *sign_key,
+		OutDepHashesSig:      *out_dep_hashes_sig,
+		OutGraphSnapshot:     *out_graph_snapshot,
+		OutConfigGraph:       *out_config_graph,
+		ChangedFiles:         strings.Split(*changed_files, ","),
+		OutAffected:          *out_affected,
+		SimulateChange:       *simulate_change,
+		TargetDurations:      *target_durations,
+		OutSchedule:          *out_schedule,
+		PrintOptionalImports: *print_optional_imports,
+		OutTargetGraph:       *out_target_graph,
+		OutOwnerMatrix:       *out_owner_matrix,
+		PathRewrites:         path_rewrites,
+		MaxOutputBytes:       *max_output_bytes,
+		OutRunManifest:       *out_run_manifest,
+		OutUnresolvedImports: *out_unresolved_imports,
+		OutProvenance:        *out_provenance,
+		ProvenanceBuilderID:  *provenance_builder_id,
+		Defines:              defines,
+	}
+	if stdout_flags := args.stdoutOutputs(); len(stdout_flags) > 1 {
+		return nil, fmt.Errorf("only one output can be written to stdout, got -%s", strings.Join(stdout_flags, ", -"))
+	} else if len(stdout_flags) == 1 && args.MaxOutputBytes != 0 {
+		return nil, fmt.Errorf("-%s is written to stdout, which can't be split with -max-output-bytes", stdout_flags[0])
+	}
+	return args, nil
 }
 
 func main() {
 	log.SetFlags(log.Ltime | log.Lmicroseconds)
+	if len(os.Args) > 1 && os.Args[1] == "verify-signature" {
+		runVerifySignature(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "self-check" {
+		runSelfCheck(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay-affected" {
+		runReplayAffected(os.Args[2:])
+		return
+	}
 	args, err := parseArgs()
 	if err != nil {
 		flag.Usage()
 		log.Fatalf("Error: %v\n", err)
 	}
+	if len(args.stdoutOutputs()) != 0 {
+		// Only warnings and errors go to stderr, so the output can be piped
+		progress_log.SetOutput(io.Discard)
+	}
+	if args.OutRunManifest != "" {
+		log.SetOutput(run_recorder.countWarnings(os.Stderr))
+		// Written on early exits too, but not on fatal errors
+		defer writeRunManifest(args)
+	}
+
+	// Load the signing key early, to fail before doing all the work
+	var signing_key ed25519.PrivateKey
+	if args.SignKey != "" {
+		signing_key, err = LoadSigningKey(args.SignKey)
+		if err != nil {
+			log.Fatalf("error while loading signing key: %v\n", err)
+		}
+	}
 
 	if args.SelfProfile {
 		f, err := os.Create("repo_dagger.prof")
@@ -135,73 +371,227 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	log.Println("Loading Config:", args.Config)
+	progress_log.Println("Loading Config:", args.Config)
 
 	// Load the config file
-	config, config_hash, err := LoadConfig(args.Config)
+	run_recorder.Phase("load_config")
+	config, config_hash, err := LoadConfig(args.Config, args.ConfigSha256, args.Defines)
 	if err != nil {
 		log.Fatalf("failed to load config file: %v\n", err)
 	}
+	run_recorder.Config(args.Config, config_hash)
 	if len(args.InputFiles) > 0 && args.InputFiles[0] != "" {
 		// Override the input files if provided via command line
 		config.Inputs.items = args.InputFiles
 	}
+	if args.NetworkFS {
+		config.NetworkFilesystem = true
+	}
+	if args.AssertReadOnly {
+		if err := checkReadOnlyConfig(config); err != nil {
+			log.Fatalf("error: %v\n", err)
+		}
+	}
+	defer func() {
+		if count := config.unreadableFileCount(); count > 0 {
+			log.Printf("Warning: %d files couldn't be read (within max_unreadable_files)", count)
+		}
+	}()
 
 	if args.Verbose {
 		log.Println("Config:")
 		spew.Fdump(os.Stderr, config)
 	}
 
-	// Iterate over the inputs
-	base_dir := filepath.Join(filepath.Dir(args.Config), config.BaseDir)
-	log.Println("Base Directory:", base_dir)
-	input_files := []string{}
-	for _, input := range config.Inputs.items {
-		input_files_chunk, err := doublestar.Glob(os.DirFS(base_dir), input)
+	if args.OutConfigGraph != "" {
+		progress_log.Println("Writing config graph to:", args.OutConfigGraph)
+		writeJsonOutput(args, "out-config-graph", args.OutConfigGraph, schema.Relations(config.include_graph))
+	}
+
+	if args.ProposedConfig != "" {
+		impact, err := CompareConfigs(args.Config, config, config_hash, args.ProposedConfig, args)
 		if err != nil {
-			log.Fatalf("error while collecting input files: glob '%s': %v\n", input, err)
+			log.Fatalf("error while comparing configs: %v\n", err)
 		}
-		input_files = append(input_files, input_files_chunk...)
+		progress_log.Printf("%d inputs affected by the proposed config\n", len(impact.Targets))
+		progress_log.Println("Writing config impact to:", args.OutConfigImpact)
+		writeJsonOutput(args, "out-config-impact", args.OutConfigImpact, impact)
+		progress_log.Println("Done")
+		return
+	}
+
+	// Iterate over the inputs
+	base_dir := filepath.Join(ConfigDir(args.Config), config.BaseDir)
+	progress_log.Println("Base Directory:", base_dir)
+	input_files, err := CollectInputFiles(config, base_dir)
+	if err != nil {
+		log.Fatalf("error while collecting input files: %v\n", err)
 	}
-	slices.Sort(input_files)
-	input_files = slices.Compact(input_files)
 	if len(input_files) == 0 {
 		log.Fatalln("No input files found. Exiting.")
 	}
+	run_recorder.Inputs(input_files)
 
 	// Visit each file recursively, to build the relations map
 	all_files_set := map[string]bool{}
 	file_relation_map := map[string][]string{}
-	log.Println("Generating dependency graph")
-	err = VisitRecursively(all_files_set, file_relation_map, input_files, config, args, base_dir)
+	edge_origins := EdgeOrigins{}
+	var stats_filter func(edge Edge) bool
+	if args.StatsHashEnv != "" {
+		env_filter, ok := config.HashEnvironments[args.StatsHashEnv]
+		if !ok {
+			log.Fatalf("hash environment '%s' (from -stats-hash-env) not found in config\n", args.StatsHashEnv)
+		}
+		stats_filter = func(edge Edge) bool { return env_filter.Follows(edge, edge_origins) }
+	}
+	progress_log.Println("Generating dependency graph")
+	run_recorder.Phase("generate_graph")
+	err = VisitRecursively(all_files_set, file_relation_map, edge_origins, input_files, config, args, base_dir)
 	if err != nil {
 		log.Fatalf("error while visiting files: %v\n", err)
 	}
 
+	// Link the graphs of other repositories
+	federated_graphs, err := LoadFederatedGraphs(config, ConfigDir(args.Config), base_dir)
+	if err != nil {
+		log.Fatalf("error while loading federated graphs: %v\n", err)
+	}
+	err = federated_graphs.Link(file_relation_map, edge_origins, config)
+	if err != nil {
+		log.Fatalf("error while linking federated graphs: %v\n", err)
+	}
+	run_recorder.Phase("write_graph_outputs")
+
+	if args.PrintRuleStats {
+		PrintRuleStats(CalculateRuleStats(edge_origins, config), args.StatsSort)
+	}
+
+	if args.PrintOptionalImports {
+		PrintOptionalImports(edge_origins)
+	}
+
 	if args.OutRelations != "" {
 		// Write as json
-		log.Println("Writing relations to:", args.OutRelations)
-		f, err := os.Create(args.OutRelations)
+		progress_log.Println("Writing relations to:", args.OutRelations)
+		writeJsonOutput(args, "out-relations", args.OutRelations, schema.Relations(file_relation_map))
+	}
+
+	if args.OutRelationsCounts != "" {
+		progress_log.Println("Writing relations with counts to:", args.OutRelationsCounts)
+		writeJsonOutput(args, "out-relations-with-counts", args.OutRelationsCounts, CountRelations(file_relation_map, edge_origins))
+	}
+
+	if args.OutTargetGraph != "" {
+		if len(config.TargetGraph) == 0 {
+			log.Fatalln("-out-target-graph requires 'target_graph' in the config")
+		}
+		progress_log.Println("Writing target graph to:", args.OutTargetGraph)
+		writeJsonOutput(args, "out-target-graph", args.OutTargetGraph, CalculateTargetGraph(file_relation_map, config))
+	}
+
+	if args.OutOwnerMatrix != "" {
+		codeowners, err := LoadCodeowners(config, base_dir)
 		if err != nil {
-			log.Fatalf("error creating out-relations file '%s': %v\n", args.OutRelations, err)
+			log.Fatalf("error while loading CODEOWNERS: %v\n", err)
+		}
+		progress_log.Println("Writing owner matrix to:", args.OutOwnerMatrix)
+		writeJsonOutput(args, "out-owner-matrix", args.OutOwnerMatrix, CalculateOwnerMatrix(file_relation_map, codeowners))
+	}
+
+	if args.OutUnresolvedImports != "" {
+		unresolved := config.UnresolvedImports()
+		progress_log.Printf("%d unresolved Python imports\n", len(unresolved))
+		progress_log.Println("Writing unresolved imports to:", args.OutUnresolvedImports)
+		writeJsonOutput(args, "out-unresolved-imports", args.OutUnresolvedImports, unresolved)
+	}
+
+	if args.OutReducedRelations != "" {
+		progress_log.Println("Writing reduced relations to:", args.OutReducedRelations)
+		reduced := TransitiveReduction(file_relation_map)
+		writeJsonOutput(args, "out-reduced-relations", args.OutReducedRelations, schema.Relations(reduced))
+	}
+
+	if args.OutAffected != "" || args.SimulateChange != "" {
+		changed_files := args.ChangedFiles
+		if args.SimulateChange != "" {
+			// The pattern was validated when parsing the args
+			changed_files = SimulatedChangedFiles(file_relation_map, args.SimulateChange)
+			progress_log.Printf("Simulating a change to %d files matching '%s'\n", len(changed_files), args.SimulateChange)
+		}
+		affected := CalculateAffected(
+			file_relation_map, edge_origins, input_files, changed_files, args.Config, config, base_dir,
+		)
+		progress_log.Printf("%d inputs affected by the changed files\n", len(affected))
+		if args.SimulateChange != "" {
+			PrintAffected(affected)
 		}
-		defer f.Close()
-		enc := json.NewEncoder(f)
-		err = enc.Encode(file_relation_map)
+		if args.OutAffected != "" {
+			progress_log.Println("Writing affected inputs to:", args.OutAffected)
+			writeJsonOutput(args, "out-affected", args.OutAffected, affected)
+		}
+	}
+
+	if args.OutSchedule != "" {
+		durations, err := LoadTargetDurations(args.TargetDurations, input_files)
 		if err != nil {
-			log.Fatalf("error encoding relations: %v\n", err)
+			log.Fatalf("%v\n", err)
 		}
+		schedule := CalculateSchedule(InputDependencies(file_relation_map, input_files), durations)
+		PrintSchedule(schedule)
+		progress_log.Println("Writing schedule to:", args.OutSchedule)
+		writeJsonOutput(args, "out-schedule", args.OutSchedule, schedule)
 	}
 
-	if !args.PrintDepStats && !args.PrintRevDepStats && args.OutDepHashes == "" && args.OutRecursiveDeps == "" {
-		log.Println("Done")
+	if args.OutParquetEdges != "" {
+		progress_log.Println("Writing parquet edges to:", args.OutParquetEdges)
+		writeParquetOutput(args, "out-parquet-edges", args.OutParquetEdges, ParquetEdges(edge_origins, args.PathRewrites))
+	}
+
+	if !args.PrintDepStats && !args.PrintRevDepStats && !args.needsDepHashes() && args.OutRecursiveDeps == "" && args.OutFileHashes == "" && args.OutParquetNodes == "" {
+		progress_log.Println("Done")
 		return
 	}
 
 	fileHashes := map[string][32]byte{}
-	if args.OutDepHashes != "" {
-		log.Println("Calculating file hashes")
-		CalculateFileHashes(fileHashes, all_files_set, base_dir)
+	tool_fingerprint := []byte{}
+	external_inputs := &ExternalInputValues{}
+	if args.needsDepHashes() || args.OutFileHashes != "" || args.OutParquetNodes != "" {
+		progress_log.Println("Calculating file hashes")
+		run_recorder.Phase("hash_files")
+		CalculateFileHashes(fileHashes, all_files_set, config, base_dir)
+		federated_graphs.MergeFileHashes(fileHashes)
+	}
+	if args.OutFileHashes != "" {
+		progress_log.Println("Writing file hashes to:", args.OutFileHashes)
+		hex_hashes := make(schema.FileHashes, len(fileHashes))
+		for file_name, file_hash := range fileHashes {
+			hex_hashes[file_name] = fmt.Sprintf("%x", file_hash)
+		}
+		writeJsonOutput(args, "out-file-hashes", args.OutFileHashes, hex_hashes)
+	}
+	if args.OutParquetNodes != "" {
+		progress_log.Println("Writing parquet nodes to:", args.OutParquetNodes)
+		columns, err := ParquetNodes(file_relation_map, all_files_set, fileHashes, config, base_dir, args.PathRewrites)
+		if err != nil {
+			log.Fatalf("error encoding out-parquet-nodes: %v\n", err)
+		}
+		writeParquetOutput(args, "out-parquet-nodes", args.OutParquetNodes, columns)
+	}
+	if args.needsDepHashes() {
+		if args.HashToolBinary {
+			binary_hash, err := HashOwnBinary()
+			if err != nil {
+				log.Fatalf("error while hashing the repo_dagger binary: %v\n", err)
+			}
+			tool_fingerprint = append(tool_fingerprint, binary_hash[:]...)
+		}
+		tool_fingerprint = append(tool_fingerprint, []byte(args.ToolchainFingerprint)...)
+
+		progress_log.Println("Capturing external inputs")
+		external_inputs, err = CaptureExternalInputs(config, base_dir)
+		if err != nil {
+			log.Fatalf("error while capturing external inputs: %v\n", err)
+		}
 	}
 
 	type fileStatEntry struct {
@@ -209,14 +599,47 @@ func main() {
 		count int
 	}
 
-	log.Println("Calculating dependency hashes")
+	progress_log.Println("Calculating dependency hashes")
+	run_recorder.Phase("hash_deps")
 	ctx := context.Background()
 	maxWorkers := runtime.GOMAXPROCS(0)
 	sem := semaphore.NewWeighted(int64(maxWorkers))
 	dep_stats_chan := make(chan fileStatEntry, maxWorkers)
 	rev_dep_stats := map[string]int{}
 	rev_dep_stats_lock := sync.Mutex{}
-	dep_hashes := map[string]string{}
+	dep_hashes := schema.DepHashes{}
+	env_dep_hashes := schema.EnvDepHashes{}
+	for env_name := range config.HashEnvironments {
+		env_dep_hashes[env_name] = schema.DepHashes{}
+	}
+	dep_hash_params := DepHashParams{
+		AlgorithmVersion: config.fileHashAlgorithm().version,
+		ConfigHash:       config_hash,
+		Salt:             args.HashSalt,
+		ToolFingerprint:  tool_fingerprint,
+		ExternalInputs:   external_inputs,
+	}
+	if args.InterfaceHashes && args.needsDepHashes() {
+		progress_log.Println("Calculating interface hashes")
+		dep_hash_params.InterfaceHashes, err = CalculateInterfaceHashes(all_files_set, config, base_dir)
+		if err != nil {
+			log.Fatalf("error while calculating interface hashes: %v\n", err)
+		}
+	}
+	graph_snapshot := schema.GraphSnapshot{
+		AlgorithmVersion: dep_hash_params.AlgorithmVersion,
+		ConfigHash:       fmt.Sprintf("%x", config_hash),
+		Salt:             args.HashSalt,
+		ToolFingerprint:  fmt.Sprintf("%x", tool_fingerprint),
+		ExternalInputs:   external_inputs.Snapshot(),
+		Targets:          map[string]schema.SnapshotTarget{},
+	}
+	if dep_hash_params.InterfaceHashes != nil {
+		graph_snapshot.InterfaceHashes = map[string]string{}
+		for file, interface_hash := range dep_hash_params.InterfaceHashes {
+			graph_snapshot.InterfaceHashes[file] = fmt.Sprintf("%x", interface_hash)
+		}
+	}
 	dep_hashes_lock := sync.Mutex{}
 	wg := sync.WaitGroup{}
 	wg.Add(len(input_files))
@@ -226,52 +649,77 @@ func main() {
 			dep_list := BuildFullDepList(file_relation_map, file_name)
 			if args.OutRecursiveDepsFor == file_name {
 				// Write as json
-				log.Println("Writing recursive dependencies of", file_name, "to:", args.OutRecursiveDeps)
-				f, err := os.Create(args.OutRecursiveDeps)
-				if err != nil {
-					log.Fatalf("error creating out-recursive-deps file '%s': %v\n", args.OutRecursiveDeps, err)
-				}
-				defer f.Close()
-				enc := json.NewEncoder(f)
-				err = enc.Encode(dep_list)
-				if err != nil {
-					log.Fatalf("error encoding recursive deps: %v\n", err)
-				}
+				progress_log.Println("Writing recursive dependencies of", file_name, "to:", args.OutRecursiveDeps)
+				writeJsonOutput(args, "out-recursive-deps", args.OutRecursiveDeps, schema.RecursiveDeps(dep_list))
+			}
+			stats_dep_list := dep_list
+			if stats_filter != nil && (args.PrintDepStats || args.PrintRevDepStats) {
+				stats_dep_list = BuildFilteredDepList(file_relation_map, file_name, stats_filter)
 			}
 			if args.PrintDepStats {
 				dep_stats_chan <- fileStatEntry{
 					name:  file_name,
-					count: len(dep_list),
+					count: len(stats_dep_list),
 				}
 			}
 			if args.PrintRevDepStats {
 				rev_dep_stats_lock.Lock()
-				for _, dep := range dep_list {
+				for _, dep := range stats_dep_list {
 					rev_dep_stats[dep]++
 				}
 				rev_dep_stats_lock.Unlock()
 			}
+			interfaceOnly := func(dep_list []string, follow func(edge Edge) bool) map[string]bool {
+				if dep_hash_params.InterfaceHashes == nil {
+					return nil
+				}
+				return interfaceOnlyDeps(file_relation_map, file_name, dep_list, follow, edge_origins)
+			}
+			group_filters := config.TargetGroupFilters(file_name)
 			if args.OutDepHashes != "" {
-				hasher := sha256.New()
-
-				algo_ver := new(bytes.Buffer)
-				binary.Write(algo_ver, binary.LittleEndian, ALGORITHM_VERSION)
-
-				hasher.Write(algo_ver.Bytes())
-				hasher.Write([]byte(args.HashSalt))
-				hasher.Write(config_hash[:])
-				hasher.Write([]byte(file_name))
-
-				for _, dep := range dep_list {
-					hasher.Write([]byte(dep))
-					dep_hash := fileHashes[dep]
-					hasher.Write(dep_hash[:])
+				hashed_dep_list := dep_list
+				var follow func(edge Edge) bool
+				if len(group_filters) != 0 {
+					follow = combineEdgeFilters(group_filters, edge_origins)
+					hashed_dep_list = BuildFilteredDepList(file_relation_map, file_name, follow)
 				}
-
+				interface_only := interfaceOnly(hashed_dep_list, follow)
+				dep_hash := CalculateDepHash(
+					file_name,
+					hashed_dep_list,
+					interface_only,
+					fileHashes,
+					&dep_hash_params,
+				)
 				dep_hashes_lock.Lock()
-				dep_hashes[file_name] = fmt.Sprintf("%x", hasher.Sum(nil))
+				dep_hashes[file_name] = dep_hash
+				if args.OutGraphSnapshot != "" {
+					snapshot_target := schema.SnapshotTarget{Deps: hashed_dep_list}
+					for _, dep := range hashed_dep_list {
+						if interface_only[dep] {
+							snapshot_target.InterfaceOnlyDeps = append(snapshot_target.InterfaceOnlyDeps, dep)
+						}
+					}
+					graph_snapshot.Targets[file_name] = snapshot_target
+				}
 				dep_hashes_lock.Unlock()
 			}
+			if args.OutEnvDepHashes != "" {
+				for env_name, env_filter := range config.HashEnvironments {
+					follow := combineEdgeFilters(append(slices.Clone(group_filters), &env_filter), edge_origins)
+					env_dep_list := BuildFilteredDepList(file_relation_map, file_name, follow)
+					dep_hash := CalculateDepHash(
+						file_name,
+						env_dep_list,
+						interfaceOnly(env_dep_list, follow),
+						fileHashes,
+						&dep_hash_params,
+					)
+					dep_hashes_lock.Lock()
+					env_dep_hashes[env_name][file_name] = dep_hash
+					dep_hashes_lock.Unlock()
+				}
+			}
 			sem.Release(1)
 			wg.Done()
 		}()
@@ -284,6 +732,9 @@ func main() {
 		}
 		sort.Slice(sorted_stats, func(i, j int) bool {
 			if args.StatsSort == STATS_SORT_COUNT {
+				if sorted_stats[i].count == sorted_stats[j].count {
+					return sorted_stats[i].name < sorted_stats[j].name
+				}
 				return sorted_stats[i].count > sorted_stats[j].count
 			} else if args.StatsSort == STATS_SORT_NAME {
 				return sorted_stats[i].name < sorted_stats[j].name
@@ -300,17 +751,38 @@ func main() {
 	wg.Wait()
 	if args.OutDepHashes != "" {
 		// Write as json
-		log.Println("Writing dependency hashes to:", args.OutDepHashes)
-		f, err := os.Create(args.OutDepHashes)
+		progress_log.Println("Writing dependency hashes to:", args.OutDepHashes)
+		data := encodeJsonOutput(args, "out-dep-hashes", dep_hashes)
+		// So other runs can't replace the hashes between them and their signature (by default, next to
+		// them)
+		unlock, err := lockOutputDir(args.OutDepHashes)
 		if err != nil {
-			log.Fatalf("error creating out-dep-hashes file '%s': %v\n", args.OutDepHashes, err)
+			log.Fatalf("error writing out-dep-hashes: %v\n", err)
 		}
-		defer f.Close()
-		enc := json.NewEncoder(f)
-		err = enc.Encode(dep_hashes)
-		if err != nil {
-			log.Fatalf("error encoding dep hashes: %v\n", err)
+		writeOutput(args, "out-dep-hashes", args.OutDepHashes, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+		if signing_key != nil {
+			progress_log.Println("Writing dependency hashes signature to:", args.OutDepHashesSig)
+			writeOutput(args, "out-dep-hashes-sig", args.OutDepHashesSig, func(w io.Writer) error {
+				_, err := w.Write(SignOutput(signing_key, data))
+				return err
+			})
 		}
+		unlock()
+	}
+	if args.OutGraphSnapshot != "" {
+		progress_log.Println("Writing graph snapshot to:", args.OutGraphSnapshot)
+		writeJsonOutput(args, "out-graph-snapshot", args.OutGraphSnapshot, graph_snapshot)
+	}
+	if args.OutEnvDepHashes != "" {
+		progress_log.Println("Writing environment dependency hashes to:", args.OutEnvDepHashes)
+		writeJsonOutput(args, "out-env-dep-hashes", args.OutEnvDepHashes, env_dep_hashes)
+	}
+	if args.OutProvenance != "" {
+		progress_log.Println("Writing provenance to:", args.OutProvenance)
+		writeJsonOutput(args, "out-provenance", args.OutProvenance, CalculateProvenance(args, config, config_hash, fileHashes))
 	}
 
 	if args.PrintRevDepStats {
@@ -340,10 +812,33 @@ func main() {
 
 	}
 
-	log.Println("Done")
+	progress_log.Println("Done")
+}
+
+// Returns the sorted input files matching the config's inputs
+func CollectInputFiles(config *Config, base_dir string) ([]string, error) {
+	input_files := []string{}
+	for _, input := range config.Inputs.items {
+		input_files_chunk, err := doublestar.Glob(config.repoFS(base_dir), input)
+		if err != nil {
+			return nil, fmt.Errorf("glob '%s': %v", input, err)
+		}
+		input_files = append(input_files, input_files_chunk...)
+	}
+	slices.Sort(input_files)
+	return slices.Compact(input_files), nil
 }
 
 func BuildFullDepList(file_relation_map map[string][]string, file string) []string {
+	return BuildFilteredDepList(file_relation_map, file, nil)
+}
+
+// Like `BuildFullDepList`, but only follows edges for which `follow` returns true (if not nil)
+func BuildFilteredDepList(
+	file_relation_map map[string][]string,
+	file string,
+	follow func(edge Edge) bool,
+) []string {
 	visited := map[string]bool{}
 	dep_list := []string{}
 	var buildDepList func(string)
@@ -353,6 +848,9 @@ func BuildFullDepList(file_relation_map map[string][]string, file string) []stri
 		}
 		visited[file] = true
 		for _, related_file := range file_relation_map[file] {
+			if follow != nil && !follow(Edge{From: file, To: related_file}) {
+				continue
+			}
 			buildDepList(related_file)
 		}
 		dep_list = append(dep_list, file)
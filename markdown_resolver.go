@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var markdown_fence_parser = regexp.MustCompile("(?ms:^[ \t]*(```|~~~).*?^[ \t]*(```|~~~)[ \t]*$)")
+var markdown_link_parser = regexp.MustCompile(`\]\(\s*(<[^>\n]*>|[^)\s]+)`)
+var markdown_reference_parser = regexp.MustCompile(`(?m:^ {0,3}\[[^\]\n]+\]:[ \t]*(<[^>\n]*>|\S+))`)
+var markdown_html_parser = regexp.MustCompile(`<(?:img|a|source|video|audio|iframe)\b[^>]*?\b(?:src|href)\s*=\s*["']([^"']+)["']`)
+var markdown_snippet_parser = regexp.MustCompile(`(?m:^[ \t]*-{1,}8<-{1,}[ \t]+["']([^"'\n]+)["'])`)
+var markdown_include_parser = regexp.MustCompile(`\{%\s*include(?:-markdown)?\s+["']([^"']+)["']`)
+var markdown_mdx_import_parser = regexp.MustCompile(`(?m:^import\s+(?:[^'"\n]*\s+from\s+)?["']([^"']+)["'])`)
+
+// Resolves the files referenced by Markdown (and MDX) pages: relative links and images (inline,
+// reference-style and HTML `src`/`href`), mkdocs snippets (`--8<-- "file"`), `{% include %}` and
+// `{% include-markdown %}` directives, and docusaurus MDX imports. Links with a URL scheme or
+// absolute paths (site routes) are ignored, and so are references inside fenced code blocks.
+type MarkdownResolver struct{}
+
+// The path of a link target relative to the repo root, "" if it isn't a file path
+func markdownLinkPath(dir string, target string) string {
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	target, _, _ = strings.Cut(target, "#")
+	target, _, _ = strings.Cut(target, "?")
+	if target == "" || strings.Contains(target, ":") || strings.HasPrefix(target, "/") {
+		return ""
+	}
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	return filepath.Join(dir, target)
+}
+
+// The docusaurus site directory of a file (where `@site/` points), "" if there's none
+func docusaurusSiteDir(dir string, config *Config, base_dir string) string {
+	for ; ; dir = filepath.Dir(dir) {
+		for _, name := range []string{"docusaurus.config.js", "docusaurus.config.ts"} {
+			if fileExists(config, filepath.Join(base_dir, dir, name)) {
+				return dir
+			}
+		}
+		if dir == "." {
+			return ""
+		}
+	}
+}
+
+func (res *MarkdownResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	dir := filepath.Dir(file)
+	paths := []string{}
+	addIfExists := func(path string) bool {
+		if path != "" && path != file && !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+			return true
+		}
+		return false
+	}
+
+	data := markdown_fence_parser.ReplaceAllString(file_data, "")
+	for _, parser := range []*regexp.Regexp{markdown_link_parser, markdown_reference_parser, markdown_html_parser, markdown_include_parser} {
+		for _, match := range parser.FindAllStringSubmatch(data, -1) {
+			addIfExists(markdownLinkPath(dir, match[1]))
+		}
+	}
+
+	// Snippets are relative to the page, or to the repo root (mkdocs' default `base_path`)
+	for _, match := range markdown_snippet_parser.FindAllStringSubmatch(data, -1) {
+		if !addIfExists(markdownLinkPath(dir, match[1])) {
+			addIfExists(markdownLinkPath(".", match[1]))
+		}
+	}
+
+	for _, match := range markdown_mdx_import_parser.FindAllStringSubmatch(data, -1) {
+		// Drop webpack loaders, e.g. `!!raw-loader!./example.py`
+		target := match[1][strings.LastIndex(match[1], "!")+1:]
+		if site_path, ok := strings.CutPrefix(target, "@site/"); ok {
+			if site_dir := docusaurusSiteDir(dir, config, base_dir); site_dir != "" {
+				addIfExists(filepath.Join(site_dir, site_path))
+			}
+		} else if strings.HasPrefix(target, ".") {
+			addIfExists(markdownLinkPath(dir, target))
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Resolves Maven `pom.xml` files to the poms of their `<modules>` (also in profiles), their
+// `<parent>` (by `relativePath`, `../pom.xml` by default), and the reactor modules they have a
+// `<dependency>` on. The reactor is every module reachable from the topmost `pom.xml` above the file
+// (following parent directories that contain one). Dependencies on other artifacts are ignored.
+type MavenResolver struct {
+	// Topmost pom directory -> "groupId:artifactId" -> pom of the reactor module
+	reactors map[string]map[string]string
+}
+
+type mavenDependency struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+}
+
+type mavenPom struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	Parent     *struct {
+		GroupId      string  `xml:"groupId"`
+		RelativePath *string `xml:"relativePath"`
+	} `xml:"parent"`
+	Modules  []string `xml:"modules>module"`
+	Profiles []struct {
+		Modules []string `xml:"modules>module"`
+	} `xml:"profiles>profile"`
+	Dependencies         []mavenDependency `xml:"dependencies>dependency"`
+	DependencyManagement []mavenDependency `xml:"dependencyManagement>dependencies>dependency"`
+}
+
+func parseMavenPom(file_data []byte) (*mavenPom, error) {
+	pom := &mavenPom{}
+	if err := xml.Unmarshal(file_data, pom); err != nil {
+		return nil, err
+	}
+	if pom.GroupId == "" && pom.Parent != nil {
+		pom.GroupId = pom.Parent.GroupId
+	}
+	return pom, nil
+}
+
+// The poms of the modules of a pom, relative to the repo root
+func (pom *mavenPom) modulePoms(file string) []string {
+	modules := pom.Modules
+	for _, profile := range pom.Profiles {
+		modules = append(modules, profile.Modules...)
+	}
+	poms := []string{}
+	for _, module := range modules {
+		module_pom := filepath.Join(filepath.Dir(file), strings.TrimSpace(module))
+		if !strings.HasSuffix(module_pom, ".xml") {
+			module_pom = filepath.Join(module_pom, "pom.xml")
+		}
+		if !strings.HasPrefix(module_pom, "..") {
+			poms = append(poms, module_pom)
+		}
+	}
+	return poms
+}
+
+// Returns the modules of the reactor the pom in `dir` belongs to, indexing it on first use
+func (res *MavenResolver) loadReactor(dir string, config *Config, base_dir string) (map[string]string, error) {
+	root := dir
+	for root != "." && fileExists(config, filepath.Join(base_dir, filepath.Dir(root), "pom.xml")) {
+		root = filepath.Dir(root)
+	}
+	if reactor, ok := res.reactors[root]; ok {
+		return reactor, nil
+	}
+	reactor := map[string]string{}
+	queue := []string{filepath.Join(root, "pom.xml")}
+	visited := map[string]bool{}
+	for len(queue) != 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if visited[file] || !fileExists(config, filepath.Join(base_dir, file)) {
+			continue
+		}
+		visited[file] = true
+		data, err := readRepoFile(config, filepath.Join(base_dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading '%s': %v", file, err)
+		}
+		pom, err := parseMavenPom(data)
+		if err != nil {
+			// Not ours to report, the rule matching it will
+			continue
+		}
+		reactor[pom.GroupId+":"+pom.ArtifactId] = file
+		queue = append(queue, pom.modulePoms(file)...)
+	}
+	res.reactors[root] = reactor
+	return reactor, nil
+}
+
+func (res *MavenResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.reactors == nil {
+		res.reactors = map[string]map[string]string{}
+	}
+	if filepath.Base(file) != "pom.xml" {
+		return nil, nil
+	}
+	pom, err := parseMavenPom([]byte(file_data))
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing pom: %v", err)
+	}
+	dir := filepath.Dir(file)
+	paths := []string{}
+	addIfExists := func(path string) {
+		if path != file && !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, module_pom := range pom.modulePoms(file) {
+		addIfExists(module_pom)
+	}
+	if pom.Parent != nil {
+		parent_pom := "../pom.xml"
+		if pom.Parent.RelativePath != nil {
+			parent_pom = strings.TrimSpace(*pom.Parent.RelativePath)
+		}
+		if parent_pom != "" {
+			if !strings.HasSuffix(parent_pom, ".xml") {
+				parent_pom = filepath.Join(parent_pom, "pom.xml")
+			}
+			addIfExists(filepath.Join(dir, parent_pom))
+		}
+	}
+
+	reactor, err := res.loadReactor(dir, config, base_dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dependency := range append(pom.Dependencies, pom.DependencyManagement...) {
+		group_id := strings.ReplaceAll(strings.TrimSpace(dependency.GroupId), "${project.groupId}", pom.GroupId)
+		if module_pom, ok := reactor[group_id+":"+strings.TrimSpace(dependency.ArtifactId)]; ok {
+			addIfExists(module_pom)
+		}
+	}
+	return paths, nil
+}
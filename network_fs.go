@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults of `io_errors` in `network_filesystem` mode
+const NETWORK_FS_READ_RETRIES = 3
+const NETWORK_FS_RETRY_BACKOFF = 50 * time.Millisecond
+
+// Default `read_concurrency` in `network_filesystem` mode, where reads are mostly latency
+const NETWORK_FS_READ_CONCURRENCY = 16
+
+// The number of files to read in parallel when hashing
+func (config *Config) readConcurrency() int {
+	if config.ReadConcurrency > 0 {
+		return config.ReadConcurrency
+	}
+	if config.NetworkFilesystem {
+		return NETWORK_FS_READ_CONCURRENCY
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// In `network_filesystem` mode, each directory is listed once per run, and existence checks and
+// globs are answered from the listings instead of a round trip per path
+type dirListings struct {
+	lock sync.Mutex
+	dirs map[string]*dirListing
+}
+
+type dirListing struct {
+	entries []fs.DirEntry
+	// Name -> type, with symlinks resolved (dangling ones are left out)
+	types map[string]fs.FileMode
+	err   error
+}
+
+// Returns the listing of a directory, reading it on first use
+func (config *Config) listDir(dir string) *dirListing {
+	dir = filepath.Clean(dir)
+	config.dir_listings.lock.Lock()
+	defer config.dir_listings.lock.Unlock()
+	if listing, ok := config.dir_listings.dirs[dir]; ok {
+		return listing
+	}
+	listing := &dirListing{types: map[string]fs.FileMode{}}
+	listing.entries, listing.err = withIORetries(config, func() ([]fs.DirEntry, error) { return os.ReadDir(dir) })
+	for _, entry := range listing.entries {
+		if entry.Type()&fs.ModeSymlink == 0 {
+			listing.types[entry.Name()] = entry.Type()
+		} else if stat, err := withIORetries(config, func() (fs.FileInfo, error) {
+			return os.Stat(filepath.Join(dir, entry.Name()))
+		}); err == nil {
+			listing.types[entry.Name()] = stat.Mode().Type()
+		}
+	}
+	config.dir_listings.dirs[dir] = listing
+	return listing
+}
+
+// Returns the type of a path (following symlinks), and whether it exists
+func (config *Config) statRepoPath(path string) (fs.FileMode, bool) {
+	dir, name := filepath.Split(filepath.Clean(path))
+	if !config.NetworkFilesystem || name == "" || name == "." || name == ".." {
+		stat, err := withIORetries(config, func() (os.FileInfo, error) { return os.Stat(path) })
+		if err != nil {
+			return 0, false
+		}
+		return stat.Mode().Type(), true
+	}
+	file_type, ok := config.listDir(dir).types[name]
+	return file_type, ok
+}
+
+// Returns whether the path exists (as a file, directory or anything else)
+func pathExists(config *Config, path string) bool {
+	_, ok := config.statRepoPath(path)
+	return ok
+}
+
+// Returns whether the path is an existing directory
+func dirExists(config *Config, path string) bool {
+	file_type, ok := config.statRepoPath(path)
+	return ok && file_type.IsDir()
+}
+
+// Lists a directory of the repo, like `os.ReadDir`
+func readRepoDir(config *Config, dir string) ([]fs.DirEntry, error) {
+	if !config.NetworkFilesystem {
+		return withIORetries(config, func() ([]fs.DirEntry, error) { return os.ReadDir(dir) })
+	}
+	listing := config.listDir(dir)
+	return slices.Clone(listing.entries), listing.err
+}
+
+// A filesystem rooted at a directory of the repo, for globbing. In `network_filesystem` mode its
+// directories are listed through the cached listings.
+func (config *Config) repoFS(dir string) fs.FS {
+	if !config.NetworkFilesystem {
+		return os.DirFS(dir)
+	}
+	return &listedFS{FS: os.DirFS(dir), config: config, dir: dir}
+}
+
+type listedFS struct {
+	fs.FS
+	config *Config
+	dir    string
+}
+
+func (fsys *listedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return readRepoDir(fsys.config, filepath.Join(fsys.dir, filepath.FromSlash(name)))
+}
+
+func (fsys *listedFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	file_type, ok := fsys.config.statRepoPath(filepath.Join(fsys.dir, filepath.FromSlash(name)))
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return listedFileInfo{name: path.Base(name), file_type: file_type}, nil
+}
+
+// The type of a listed path, which is all globbing needs
+type listedFileInfo struct {
+	name      string
+	file_type fs.FileMode
+}
+
+func (info listedFileInfo) Name() string       { return info.name }
+func (info listedFileInfo) Size() int64        { return 0 }
+func (info listedFileInfo) Mode() fs.FileMode  { return info.file_type }
+func (info listedFileInfo) ModTime() time.Time { return time.Time{} }
+func (info listedFileInfo) IsDir() bool        { return info.file_type.IsDir() }
+func (info listedFileInfo) Sys() any           { return nil }
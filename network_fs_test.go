@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+func TestDirListings(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{
+		"src/a.py":         "",
+		"src/b.py":         "",
+		"src/pkg/c.py":     "",
+		"src/pkg/d.txt":    "",
+		"docs/index.md":    "",
+		"docs/api/spec.md": "",
+	})
+	if err := os.Symlink("a.py", filepath.Join(base_dir, "src/link.py")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing.py", filepath.Join(base_dir, "src/dangling.py")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("pkg", filepath.Join(base_dir, "src/pkg_link")); err != nil {
+		t.Fatal(err)
+	}
+
+	local := loadTestConfig(t, base_dir, "{}")
+	network := loadTestConfig(t, base_dir, "network_filesystem: true")
+	for _, path := range []string{"src/a.py", "src/link.py", "src/dangling.py", "src/pkg", "src/pkg_link", "src/missing.py", "missing/a.py", "src", "."} {
+		full_path := filepath.Join(base_dir, path)
+		if got, expected := fileExists(network, full_path), fileExists(local, full_path); got != expected {
+			t.Errorf("fileExists(%s) = %v, expected %v", path, got, expected)
+		}
+		if got, expected := dirExists(network, full_path), dirExists(local, full_path); got != expected {
+			t.Errorf("dirExists(%s) = %v, expected %v", path, got, expected)
+		}
+		if got, expected := pathExists(network, full_path), pathExists(local, full_path); got != expected {
+			t.Errorf("pathExists(%s) = %v, expected %v", path, got, expected)
+		}
+	}
+
+	for _, pattern := range []string{"**/*.py", "src/*", "**", "src/pkg_link/*.py", "docs/**/*.md", "nothing/**"} {
+		expected, err := doublestar.Glob(local.repoFS(base_dir), pattern, doublestar.WithFilesOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := doublestar.Glob(network.repoFS(base_dir), pattern, doublestar.WithFilesOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(expected)
+		slices.Sort(got)
+		if !slices.Equal(got, expected) {
+			t.Errorf("glob '%s' = %v, expected %v", pattern, got, expected)
+		}
+	}
+
+	// Each directory is listed once, so files created later aren't seen
+	if err := os.WriteFile(filepath.Join(base_dir, "src/new.py"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(network, filepath.Join(base_dir, "src/new.py")) {
+		t.Error("src/ was listed again")
+	}
+	if !fileExists(local, filepath.Join(base_dir, "src/new.py")) {
+		t.Error("src/new.py wasn't created")
+	}
+}
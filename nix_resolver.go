@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var nix_comment_parser = regexp.MustCompile(`(?s:/\*.*?\*/)|(?m:#[^\n]*$)`)
+var nix_call_parser = regexp.MustCompile(`\b(?:import|callPackages?)\s+\(?\s*(\.\.?/[A-Za-z0-9._+\-/]*)`)
+var nix_imports_parser = regexp.MustCompile(`\bimports\s*=\s*\[([^\]]*)\]`)
+var nix_path_parser = regexp.MustCompile(`(?:^|\s)(\.\.?/[A-Za-z0-9._+\-/]*)`)
+
+// Resolves the Nix files of `import ./path`, `callPackage ./path` (and `callPackages`) and of the
+// module lists in `imports = [ ./a.nix ./dir ]`, relative to the file. Directories resolve to
+// their `default.nix`. Paths built from variables or search paths (`<nixpkgs>`) are ignored.
+type NixResolver struct{}
+
+func (res *NixResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	data := nix_comment_parser.ReplaceAllString(file_data, "")
+	references := []string{}
+	for _, match := range nix_call_parser.FindAllStringSubmatch(data, -1) {
+		references = append(references, match[1])
+	}
+	for _, match := range nix_imports_parser.FindAllStringSubmatch(data, -1) {
+		for _, path_match := range nix_path_parser.FindAllStringSubmatch(match[1], -1) {
+			references = append(references, path_match[1])
+		}
+	}
+
+	paths := []string{}
+	for _, reference := range references {
+		path := filepath.Join(filepath.Dir(file), reference)
+		if strings.HasPrefix(path, "..") {
+			continue
+		}
+		if dirExists(config, filepath.Join(base_dir, path)) {
+			path = filepath.Join(path, "default.nix")
+		}
+		if fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
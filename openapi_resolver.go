@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Resolves OpenAPI and JSON Schema documents (YAML or JSON) to the files of their `$ref`s, like
+// `$ref: './schemas/user.yaml#/components/schemas/User'`, relative to the document. References
+// inside the document itself (`#/...`) and to URLs are ignored.
+type OpenapiResolver struct{}
+
+// Collects the `$ref` values anywhere in a document
+func collectRefs(node *yaml.Node, refs []string) []string {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "$ref" && value.Kind == yaml.ScalarNode {
+				refs = append(refs, value.Value)
+			}
+		}
+	}
+	for _, child := range node.Content {
+		refs = collectRefs(child, refs)
+	}
+	return refs
+}
+
+func (res *OpenapiResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	document := yaml.Node{}
+	if err := yaml.Unmarshal([]byte(file_data), &document); err != nil {
+		return nil, fmt.Errorf("error while parsing api document: %v", err)
+	}
+	paths := []string{}
+	for _, ref := range collectRefs(&document, nil) {
+		ref_file, _, _ := strings.Cut(ref, "#")
+		if ref_file == "" || strings.Contains(ref_file, "://") {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(ref_file); err == nil {
+			ref_file = unescaped
+		}
+		path := filepath.Join(filepath.Dir(file), ref_file)
+		if path != file && !strings.HasPrefix(path, "..") && fileExists(config, filepath.Join(base_dir, path)) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The output path that writes to stdout
+const STDOUT_OUTPUT = "-"
+
+// Logs progress, which is suppressed when an output is written to stdout so the tool composes in
+// shell pipelines. Warnings and errors are always logged (to stderr, like all logs).
+var progress_log = log.New(os.Stderr, "", log.Ltime|log.Lmicroseconds)
+
+// The temporary file of an output is next to it and named after it, e.g.
+// `.relations.json.123456.tmp` for `relations.json`
+func outputTempPattern(path string) string {
+	return "." + filepath.Base(path) + ".*.tmp"
+}
+
+// Whether a path is a temporary file of the output at `path` (see `outputTempPattern`)
+func isOutputTempOf(temp string, path string) bool {
+	name := filepath.Base(temp)
+	return filepath.Dir(temp) == filepath.Dir(path) &&
+		strings.HasPrefix(name, "."+filepath.Base(path)+".") && strings.HasSuffix(name, ".tmp")
+}
+
+// Whether an output may be written to `path` with `-assert-read-only`: the path given in the
+// output's own flag, or its chunks (see `-max-output-bytes`)
+func (args *Args) isDeclaredOutput(flag_name string, path string) bool {
+	declared, ok := args.outputPaths()[flag_name]
+	if !ok || declared == "" {
+		return false
+	}
+	return path == declared || isOutputChunkOf(path, declared)
+}
+
+// Create a temporary file next to an output, which `writeOutput` renames over it when it's
+// complete, so concurrent runs writing the same output (e.g. parallel CI jobs) never leave a mix of
+// both, and readers never see a partial file. Returns stdout for `-`. With `-assert-read-only`,
+// only the path given in the output's own flag (and its temporary file) may be created, and every
+// creation is logged for auditing (the rename replaces symlinks rather than following them).
+func createOutput(args *Args, flag_name string, path string) (*os.File, error) {
+	if path == STDOUT_OUTPUT {
+		return os.Stdout, nil
+	}
+	if args.AssertReadOnly && !args.isDeclaredOutput(flag_name, path) {
+		return nil, fmt.Errorf("write to undeclared output path '%s' (read-only assertion)", path)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), outputTempPattern(path))
+	if err != nil {
+		return nil, err
+	}
+	if args.AssertReadOnly {
+		abs_path, abs_err := filepath.Abs(path)
+		abs_temp, temp_err := filepath.Abs(f.Name())
+		if err = errors.Join(abs_err, temp_err); err == nil && !isOutputTempOf(f.Name(), path) {
+			err = fmt.Errorf("write to undeclared output path '%s' (read-only assertion)", f.Name())
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+		log.Printf("Audit: creating %s output '%s' (through temporary file '%s')", flag_name, abs_path, abs_temp)
+	}
+	// Temporary files are private, but outputs are read by other tools
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// Check that the config doesn't make the tool write anything with `-assert-read-only`
+func checkReadOnlyConfig(config *Config) error {
+	for name, input := range config.ExternalInputs {
+		if input.Command != "" {
+			return fmt.Errorf("external input '%s' runs a command, which isn't allowed with -assert-read-only", name)
+		}
+	}
+	for name, input := range config.AbstractNodes {
+		if input.Command != "" {
+			return fmt.Errorf("abstract node '%s' runs a command, which isn't allowed with -assert-read-only", name)
+		}
+	}
+	return nil
+}
+
+// Write an output file, exiting on failure. `write` returns its errors rather than exiting, so the
+// temporary file is removed on every failure
+func writeOutput(args *Args, flag_name string, path string, write func(w io.Writer) error) {
+	f, err := createOutput(args, flag_name, path)
+	if err != nil {
+		log.Fatalf("error creating %s file '%s': %v\n", flag_name, path, err)
+	}
+	if f != os.Stdout {
+		// Also if `write` panics; after the rename, this fails harmlessly
+		defer os.Remove(f.Name())
+	}
+	written := newHashingWriter(f)
+	err = write(written)
+	if f != os.Stdout {
+		if close_err := f.Close(); err == nil {
+			err = close_err
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), path)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		log.Fatalf("error writing %s file '%s': %v\n", flag_name, path, err)
+	}
+	if flag_name != "out-run-manifest" {
+		run_recorder.Artifact(flag_name, path, written)
+	}
+}
+
+// Write an output file as json (with `-rewrite-path-prefixes` applied), split into chunks if it's
+// larger than `-max-output-bytes`, exiting on failure
+func writeJsonOutput(args *Args, flag_name string, path string, value any) {
+	rewritten, err := rewriteJsonPaths(args.PathRewrites, value)
+	if err != nil {
+		log.Fatalf("error encoding %s: %v\n", flag_name, err)
+	}
+	encode := func(value any) func(w io.Writer) error {
+		return func(w io.Writer) error { return json.NewEncoder(w).Encode(value) }
+	}
+	if args.MaxOutputBytes != 0 {
+		size, err := encodedSize(encode(rewritten))
+		if err != nil {
+			log.Fatalf("error encoding %s: %v\n", flag_name, err)
+		}
+		if size > args.MaxOutputBytes {
+			parts, err := splitJson(rewritten, args.MaxOutputBytes)
+			if err != nil {
+				log.Fatalf("error splitting %s: %v\n", flag_name, err)
+			}
+			writeOutputChunks(args, flag_name, path, len(parts), func(idx int, w io.Writer) error {
+				return encode(parts[idx])(w)
+			})
+			return
+		}
+	}
+	writeOutput(args, flag_name, path, encode(rewritten))
+}
+
+// Write an output file as a parquet table, split into chunks (by rows) if it's larger than
+// `-max-output-bytes`, exiting on failure
+func writeParquetOutput(args *Args, flag_name string, path string, columns []ParquetColumn) {
+	if args.MaxOutputBytes != 0 {
+		size, err := encodedSize(func(w io.Writer) error { return WriteParquet(w, columns) })
+		if err != nil {
+			log.Fatalf("error encoding %s: %v\n", flag_name, err)
+		}
+		if size > args.MaxOutputBytes {
+			parts, err := splitParquet(columns, args.MaxOutputBytes)
+			if err != nil {
+				log.Fatalf("error splitting %s: %v\n", flag_name, err)
+			}
+			writeOutputChunks(args, flag_name, path, len(parts), func(idx int, w io.Writer) error {
+				return WriteParquet(w, sliceParquetColumns(columns, parts[idx][0], parts[idx][1]))
+			})
+			return
+		}
+	}
+	writeOutput(args, flag_name, path, func(w io.Writer) error {
+		return WriteParquet(w, columns)
+	})
+}
+
+// Encode an output as json in memory (the same as `writeJsonOutput`), exiting on failure
+func encodeJsonOutput(args *Args, flag_name string, value any) []byte {
+	rewritten, err := rewriteJsonPaths(args.PathRewrites, value)
+	if err != nil {
+		log.Fatalf("error encoding %s: %v\n", flag_name, err)
+	}
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(rewritten); err != nil {
+		log.Fatalf("error encoding %s: %v\n", flag_name, err)
+	}
+	return buf.Bytes()
+}
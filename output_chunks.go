@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// The path of a chunk of a split output: `relations.json` -> `relations.000.json`
+func outputChunkPath(path string, idx int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(path, ext), idx, ext)
+}
+
+// Whether a path is a chunk of the output at `declared`
+func isOutputChunkOf(path string, declared string) bool {
+	ext := filepath.Ext(declared)
+	rest, ok := strings.CutPrefix(path, strings.TrimSuffix(declared, ext)+".")
+	if !ok {
+		return false
+	}
+	idx, ok := strings.CutSuffix(rest, ext)
+	if _, err := strconv.Atoi(idx); !ok || err != nil || len(idx) < 3 {
+		return false
+	}
+	return true
+}
+
+func jsonSize(value any) (int64, error) {
+	data, err := json.Marshal(value)
+	return int64(len(data)), err
+}
+
+// The size of an output when encoded, without keeping it in memory
+func encodedSize(encode func(w io.Writer) error) (int64, error) {
+	counter := countingWriter{w: bufio.NewWriter(io.Discard)}
+	err := encode(&counter)
+	return counter.written, err
+}
+
+// Splits a JSON object (by keys, in sorted order) or array into parts that are at most
+// `max_bytes` each when encoded. Maps and slices are split as they are, so parts share their
+// entries with the value, and only one entry is encoded at a time to measure it.
+func splitJson(value any, max_bytes int64) ([]any, error) {
+	// The brackets and the trailing newline
+	const overhead = 3
+	v := reflect.ValueOf(value)
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	_, is_marshaler := value.(json.Marshaler)
+	if is_marshaler || v.Kind() == reflect.Struct || (v.Kind() == reflect.Map && v.Type().Key().Kind() != reflect.String) {
+		// Split what it encodes to instead, one level deep
+		return splitEncodedJson(value, max_bytes)
+	}
+	parts := []any{}
+	switch v.Kind() {
+	case reflect.Map:
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		chunk := reflect.MakeMap(v.Type())
+		size := int64(overhead)
+		for _, key := range keys {
+			key_size, err := jsonSize(key.Interface())
+			if err != nil {
+				return nil, err
+			}
+			value_size, err := jsonSize(v.MapIndex(key).Interface())
+			if err != nil {
+				return nil, err
+			}
+			// With the colon
+			entry_size := key_size + value_size + 1
+			if overhead+entry_size > max_bytes {
+				return nil, fmt.Errorf("the entry '%s' alone is larger than %d bytes", key.String(), max_bytes)
+			}
+			if chunk.Len() != 0 && size+1+entry_size > max_bytes {
+				parts = append(parts, chunk.Interface())
+				chunk = reflect.MakeMap(v.Type())
+				size = overhead
+			}
+			if chunk.Len() != 0 {
+				// The comma
+				size++
+			}
+			chunk.SetMapIndex(key, v.MapIndex(key))
+			size += entry_size
+		}
+		return append(parts, chunk.Interface()), nil
+	case reflect.Slice:
+		from := 0
+		size := int64(overhead)
+		for i := 0; i < v.Len(); i++ {
+			item_size, err := jsonSize(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			if overhead+item_size > max_bytes {
+				return nil, fmt.Errorf("the item at index %d alone is larger than %d bytes", i, max_bytes)
+			}
+			if i != from && size+1+item_size > max_bytes {
+				parts = append(parts, v.Slice(from, i).Interface())
+				from = i
+				size = overhead
+			}
+			if i != from {
+				// The comma
+				size++
+			}
+			size += item_size
+		}
+		return append(parts, v.Slice(from, v.Len()).Interface()), nil
+	}
+	return nil, fmt.Errorf("only objects and arrays can be split")
+}
+
+// Splits the encoding of a value with its own JSON encoding (like a struct), by its top-level
+// object keys or array items
+func splitEncodedJson(value any, max_bytes int64) ([]any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		return splitJson(fields, max_bytes)
+	} else if bytes.HasPrefix(data, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+		return splitJson(items, max_bytes)
+	}
+	return nil, fmt.Errorf("only objects and arrays can be split")
+}
+
+// Decodes JSON as the generic JSON types (keeping numbers as they are), which can be split
+func decodeGenericJson(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded any
+	err := decoder.Decode(&decoded)
+	return decoded, err
+}
+
+// An upper bound of the size of a parquet file of `num_rows` rows of the columns, whose values
+// (see `valueSize`) take `values_size` bytes
+func parquetSizeBound(columns []ParquetColumn, num_rows int, values_size int64) int64 {
+	// The magic at both ends, the metadata's size, and the metadata without row groups
+	size := int64(12 + 64 + len(VERSION))
+	// Each column chunk's metadata, in each row group
+	row_group_size := int64(16)
+	for _, column := range columns {
+		size += 16 + int64(len(column.Name))
+		row_group_size += 96 + 2*int64(len(column.Name))
+	}
+	num_row_groups := int64((num_rows + PARQUET_ROW_GROUP_ROWS - 1) / PARQUET_ROW_GROUP_ROWS)
+	size += num_row_groups * row_group_size
+	// Every page but the last of a column chunk has at least `PARQUET_PAGE_SIZE` of values, and
+	// each has a header and the size of its definition levels
+	num_pages := num_row_groups*int64(len(columns)) + values_size/PARQUET_PAGE_SIZE
+	return size + num_pages*64 + values_size
+}
+
+// Cuts the rows of parquet columns into ranges [from, to) that are at most `max_bytes` each when
+// written, by upper bounds of their sizes
+func splitParquet(columns []ParquetColumn, max_bytes int64) ([][2]int, error) {
+	num_rows := 0
+	if len(columns) != 0 {
+		num_rows = columns[0].numRows()
+	}
+	parts := [][2]int{}
+	from := 0
+	values_size := int64(0)
+	for row := 0; row < num_rows; row++ {
+		row_size := int64(0)
+		for i := range columns {
+			row_size += columns[i].valueSize(row)
+		}
+		if parquetSizeBound(columns, 1, row_size) > max_bytes {
+			return nil, fmt.Errorf("the row at index %d alone may be larger than %d bytes", row, max_bytes)
+		}
+		if row != from && parquetSizeBound(columns, row+1-from, values_size+row_size) > max_bytes {
+			parts = append(parts, [2]int{from, row})
+			from = row
+			values_size = 0
+		}
+		values_size += row_size
+	}
+	return append(parts, [2]int{from, num_rows}), nil
+}
+
+// The rows [from, to) of parquet columns
+func sliceParquetColumns(columns []ParquetColumn, from int, to int) []ParquetColumn {
+	sliced := make([]ParquetColumn, len(columns))
+	for i := range columns {
+		sliced[i] = columns[i].slice(from, to)
+	}
+	return sliced
+}
+
+// Writes the chunks of a split output next to it, and the index of the chunks in its place
+func writeOutputChunks(args *Args, flag_name string, path string, num_chunks int, write_chunk func(idx int, w io.Writer) error) {
+	progress_log.Printf("Splitting %s into %d chunks\n", flag_name, num_chunks)
+	unlock, err := lockOutputDir(path)
+	if err != nil {
+		log.Fatalf("error writing %s: %v\n", flag_name, err)
+	}
+	defer unlock()
+	index := schema.ChunkedOutput{Chunked: true, Chunks: []string{}}
+	for i := 0; i < num_chunks; i++ {
+		chunk_path := outputChunkPath(path, i)
+		writeOutput(args, flag_name, chunk_path, func(w io.Writer) error {
+			return write_chunk(i, w)
+		})
+		index.Chunks = append(index.Chunks, filepath.Base(chunk_path))
+	}
+	writeOutput(args, flag_name, path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(index)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+func TestOutputChunkPath(t *testing.T) {
+	if got := outputChunkPath("out/relations.json", 0); got != "out/relations.000.json" {
+		t.Errorf("outputChunkPath = %s, expected out/relations.000.json", got)
+	}
+	if !isOutputChunkOf("out/relations.012.json", "out/relations.json") || isOutputChunkOf("out/relations.json", "out/relations.json") {
+		t.Error("isOutputChunkOf doesn't match outputChunkPath")
+	}
+}
+
+// Encodes the parts of a split value, checking that each fits
+func encodeJsonParts(t *testing.T, parts []any, max_bytes int64) [][]byte {
+	t.Helper()
+	encoded := [][]byte{}
+	for i, part := range parts {
+		buf := bytes.Buffer{}
+		if err := json.NewEncoder(&buf).Encode(part); err != nil {
+			t.Fatal(err)
+		}
+		if int64(buf.Len()) > max_bytes {
+			t.Errorf("part %d is %d bytes, more than %d", i, buf.Len(), max_bytes)
+		}
+		encoded = append(encoded, buf.Bytes())
+	}
+	return encoded
+}
+
+func TestSplitJsonObject(t *testing.T) {
+	relations := schema.Relations{}
+	for i := 0; i < 200; i++ {
+		relations[fmt.Sprintf("src/file%03d.py", i)] = []string{fmt.Sprintf("src/dep%d.py", i%7), "requirements.txt"}
+	}
+	for _, max_bytes := range []int64{100, 1000, 100000} {
+		parts, err := splitJson(relations, max_bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		merged := schema.Relations{}
+		for _, data := range encodeJsonParts(t, parts, max_bytes) {
+			part := schema.Relations{}
+			if err := json.Unmarshal(data, &part); err != nil {
+				t.Fatal(err)
+			}
+			maps.Copy(merged, part)
+		}
+		if !maps.EqualFunc(merged, relations, slices.Equal) {
+			t.Errorf("the parts of %d bytes don't merge back", max_bytes)
+		}
+		// Greedy packing only cuts a part when the next entry doesn't fit
+		if whole, _ := jsonSize(relations); whole+1 <= max_bytes && len(parts) != 1 {
+			t.Errorf("split into %d parts, though it fits in %d bytes", len(parts), max_bytes)
+		}
+	}
+}
+
+func TestSplitJsonArray(t *testing.T) {
+	items := []map[string][]string{}
+	for i := 0; i < 100; i++ {
+		items = append(items, map[string][]string{fmt.Sprintf("file%d", i): {strings.Repeat("x", i)}})
+	}
+	parts, err := splitJson(items, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := []map[string][]string{}
+	for _, data := range encodeJsonParts(t, parts, 500) {
+		part := []map[string][]string{}
+		if err := json.Unmarshal(data, &part); err != nil {
+			t.Fatal(err)
+		}
+		merged = append(merged, part...)
+	}
+	expected, _ := json.Marshal(items)
+	got, _ := json.Marshal(merged)
+	if !bytes.Equal(got, expected) {
+		t.Error("the parts don't merge back in order")
+	}
+}
+
+func TestSplitJsonStruct(t *testing.T) {
+	value := struct {
+		A string `json:"a"`
+		B []int  `json:"b"`
+		C string `json:"c"`
+	}{strings.Repeat("a", 50), []int{1, 2, 3}, strings.Repeat("c", 50)}
+	parts, err := splitJson(&value, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := map[string]any{}
+	for _, data := range encodeJsonParts(t, parts, 80) {
+		if err := json.Unmarshal(data, &merged); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(parts) < 2 || len(merged) != 3 {
+		t.Errorf("split into %d parts with %d keys, expected all 3 keys over several parts", len(parts), len(merged))
+	}
+}
+
+func TestSplitJsonTooLarge(t *testing.T) {
+	if _, err := splitJson(map[string]string{"a": strings.Repeat("x", 100)}, 50); err == nil {
+		t.Error("expected an error for an entry larger than the limit")
+	}
+	if _, err := splitJson("scalar", 3); err == nil {
+		t.Error("expected an error for a scalar")
+	}
+}
+
+func TestSplitParquet(t *testing.T) {
+	columns := parquetTestColumns(3000, 20)
+	whole, err := encodedSize(func(w io.Writer) error { return WriteParquet(w, columns) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, max_bytes := range []int64{2000, 20000, 200000} {
+		parts, err := splitParquet(columns, max_bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := 0
+		for _, part := range parts {
+			if part[0] != next || part[1] <= part[0] {
+				t.Fatalf("parts %v don't cover the rows in order", parts)
+			}
+			next = part[1]
+			buf := bytes.Buffer{}
+			if err := WriteParquet(&buf, sliceParquetColumns(columns, part[0], part[1])); err != nil {
+				t.Fatal(err)
+			}
+			if int64(buf.Len()) > max_bytes {
+				t.Errorf("rows %v are %d bytes, more than %d", part, buf.Len(), max_bytes)
+			}
+			read, _, _ := readParquet(t, buf.Bytes())
+			if !slices.Equal(read[0].Strings, columns[0].Strings[part[0]:part[1]]) {
+				t.Errorf("rows %v didn't round-trip", part)
+			}
+		}
+		if next != 3000 {
+			t.Errorf("parts %v don't cover all rows", parts)
+		}
+		// The size bounds are loose, but not by much
+		if expected := whole/max_bytes + 1; int64(len(parts)) > 2*expected {
+			t.Errorf("split %d bytes into %d parts of %d bytes, expected about %d", whole, len(parts), max_bytes, expected)
+		}
+	}
+	if _, err := splitParquet(parquetTestColumns(10, 5000), 2000); err == nil {
+		t.Error("expected an error for a row larger than the limit")
+	}
+}
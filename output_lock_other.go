@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// Directories can't be locked without `flock`, so outputs that only make sense together may be
+// interleaved by concurrent runs. Each output is still replaced atomically (see `createOutput`).
+func lockOutputDir(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Takes an advisory lock on the directory of an output, so concurrent runs don't interleave outputs
+// that only make sense together (chunks and their index, dependency hashes and their signature).
+// No lock file is created, the directory itself is locked. Call the returned function to unlock.
+func lockOutputDir(path string) (func(), error) {
+	if path == STDOUT_OUTPUT {
+		return func() {}, nil
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("error while locking '%s': %v", dir.Name(), err)
+	}
+	return func() {
+		syscall.Flock(int(dir.Fd()), syscall.LOCK_UN)
+		dir.Close()
+	}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsOutputTempOf(t *testing.T) {
+	for _, test := range []struct {
+		temp     string
+		expected bool
+	}{
+		{"out/.relations.json.123456.tmp", true},
+		{"out/.relations.000.json.123456.tmp", false},
+		{"other/.relations.json.123456.tmp", false},
+		{"out/relations.json.123456.tmp", false},
+		{"out/.relations.json.123456", false},
+	} {
+		if got := isOutputTempOf(test.temp, "out/relations.json"); got != test.expected {
+			t.Errorf("isOutputTempOf(%q) = %v, expected %v", test.temp, got, test.expected)
+		}
+	}
+}
+
+func TestCreateOutputReadOnly(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{})
+	path := filepath.Join(base_dir, "relations.json")
+	args := &Args{AssertReadOnly: true, OutRelations: path}
+
+	for _, allowed := range []string{path, outputChunkPath(path, 3)} {
+		f, err := createOutput(args, "out-relations", allowed)
+		if err != nil {
+			t.Fatalf("creating declared output '%s' failed: %v", allowed, err)
+		}
+		if !isOutputTempOf(f.Name(), allowed) {
+			t.Errorf("temporary file '%s' isn't declared for '%s'", f.Name(), allowed)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	for _, denied := range []string{filepath.Join(base_dir, "other.json"), filepath.Join(base_dir, ".relations.json.1.tmp")} {
+		if _, err := createOutput(args, "out-relations", denied); err == nil {
+			t.Errorf("creating undeclared output '%s' should have failed", denied)
+		}
+	}
+	if _, err := createOutput(args, "out-file-hashes", path); err == nil {
+		t.Error("creating an output under another flag should have failed")
+	}
+}
+
+func TestWriteOutputReplaces(t *testing.T) {
+	base_dir := writeTestRepo(t, map[string]string{"relations.json": "old"})
+	path := filepath.Join(base_dir, "relations.json")
+	writeOutput(&Args{}, "out-relations", path, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new" {
+		t.Errorf("read %q, %v, expected \"new\"", data, err)
+	}
+	entries, err := os.ReadDir(base_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "relations.json" {
+			t.Errorf("unexpected file '%s' left next to the output", entry.Name())
+		}
+	}
+}
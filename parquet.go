@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A minimal Apache Parquet writer: PLAIN encoding, no compression.
+// Only UTF-8 string, int64 and boolean columns are supported, which is all we export.
+
+const (
+	parquetTypeBoolean   = 0
+	parquetTypeInt64     = 2
+	parquetTypeByteArray = 6
+
+	parquetRepetitionRequired = 0
+	parquetRepetitionOptional = 1
+
+	parquetConvertedUTF8 = 0
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetPageTypeData      = 0
+)
+
+// A column to write. Exactly one of `Strings`, `Int64s` and `Bools` should be set.
+// If `Nulls` is set the column is optional, and values at null rows are ignored.
+type ParquetColumn struct {
+	Name    string
+	Strings []string
+	Int64s  []int64
+	Bools   []bool
+	Nulls   []bool
+}
+
+func (column *ParquetColumn) numRows() int {
+	if column.Strings != nil {
+		return len(column.Strings)
+	} else if column.Bools != nil {
+		return len(column.Bools)
+	}
+	return len(column.Int64s)
+}
+
+// The rows [from, to) of the column
+func (column *ParquetColumn) slice(from int, to int) ParquetColumn {
+	sliced := ParquetColumn{Name: column.Name}
+	if column.Strings != nil {
+		sliced.Strings = column.Strings[from:to]
+	} else if column.Bools != nil {
+		sliced.Bools = column.Bools[from:to]
+	} else {
+		sliced.Int64s = column.Int64s[from:to]
+	}
+	if column.Nulls != nil {
+		sliced.Nulls = column.Nulls[from:to]
+	}
+	return sliced
+}
+
+func (column *ParquetColumn) physicalType() int32 {
+	if column.Strings != nil {
+		return parquetTypeByteArray
+	} else if column.Bools != nil {
+		return parquetTypeBoolean
+	}
+	return parquetTypeInt64
+}
+
+// Encode the column's values (and definition levels, if it's optional) as a data page
+func (column *ParquetColumn) encodePage() []byte {
+	page := new(bytes.Buffer)
+	if column.Nulls != nil {
+		// Definition levels, using the RLE/bit-packing hybrid with a bit width of 1
+		levels := new(bytes.Buffer)
+		for i := 0; i < len(column.Nulls); {
+			run := 1
+			for i+run < len(column.Nulls) && column.Nulls[i+run] == column.Nulls[i] {
+				run++
+			}
+			levels.Write(binary.AppendUvarint(nil, uint64(run)<<1))
+			if column.Nulls[i] {
+				levels.WriteByte(0)
+			} else {
+				levels.WriteByte(1)
+			}
+			i += run
+		}
+		binary.Write(page, binary.LittleEndian, uint32(levels.Len()))
+		page.Write(levels.Bytes())
+	}
+	// Booleans are bit-packed, LSB first
+	bits := byte(0)
+	num_bits := 0
+	for i := 0; i < column.numRows(); i++ {
+		if column.Nulls != nil && column.Nulls[i] {
+			continue
+		}
+		if column.Bools != nil {
+			if column.Bools[i] {
+				bits |= 1 << num_bits
+			}
+			num_bits++
+			if num_bits == 8 {
+				page.WriteByte(bits)
+				bits, num_bits = 0, 0
+			}
+		} else if column.Strings != nil {
+			binary.Write(page, binary.LittleEndian, uint32(len(column.Strings[i])))
+			page.WriteString(column.Strings[i])
+		} else {
+			binary.Write(page, binary.LittleEndian, column.Int64s[i])
+		}
+	}
+	if num_bits != 0 {
+		page.WriteByte(bits)
+	}
+	return page.Bytes()
+}
+
+// The size pages are cut at, and the number of rows in each row group
+const PARQUET_PAGE_SIZE = 1024 * 1024
+const PARQUET_ROW_GROUP_ROWS = 128 * 1024
+
+// Counts the bytes written, for the offsets in the metadata
+type countingWriter struct {
+	w       *bufio.Writer
+	written int64
+}
+
+func (cw *countingWriter) Write(data []byte) (int, error) {
+	n, err := cw.w.Write(data)
+	cw.written += int64(n)
+	return n, err
+}
+
+// An upper bound of the encoded size of a row's value (with its definition level)
+func (column *ParquetColumn) valueSize(row int) int64 {
+	size := int64(0)
+	if column.Nulls != nil {
+		size += 2
+		if column.Nulls[row] {
+			return size
+		}
+	}
+	if column.Strings != nil {
+		return size + 4 + int64(len(column.Strings[row]))
+	} else if column.Bools != nil {
+		return size + 1
+	}
+	return size + 8
+}
+
+type parquetChunkInfo struct {
+	offset int64
+	size   int64
+}
+
+// Write the rows [from, to) of a column as data pages of about `PARQUET_PAGE_SIZE` each
+func (column *ParquetColumn) writePages(out *countingWriter, from int, to int) (parquetChunkInfo, error) {
+	chunk := parquetChunkInfo{offset: out.written}
+	for page_from := from; page_from < to; {
+		page_to := page_from
+		page_size := int64(0)
+		for page_to < to && (page_to == page_from || page_size < PARQUET_PAGE_SIZE) {
+			page_size += column.valueSize(page_to)
+			page_to++
+		}
+		// Only a huge value can overflow the sizes in the page header
+		if page_size > math.MaxInt32 {
+			return chunk, fmt.Errorf("the value of column '%s' at row %d is too large for a parquet page", column.Name, page_to-1)
+		}
+		sliced := column.slice(page_from, page_to)
+		page := sliced.encodePage()
+		header := thriftWriter{}
+		header.structBegin()
+		header.i32(1, parquetPageTypeData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(page_to-page_from))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.structEnd()
+		header.structEnd()
+		if _, err := out.Write(header.buf.Bytes()); err != nil {
+			return chunk, err
+		}
+		if _, err := out.Write(page); err != nil {
+			return chunk, err
+		}
+		page_from = page_to
+	}
+	chunk.size = out.written - chunk.offset
+	return chunk, nil
+}
+
+// Write a parquet file with the given columns, which must all have the same number of rows. The
+// rows are streamed in row groups of `PARQUET_ROW_GROUP_ROWS`, with pages of `PARQUET_PAGE_SIZE`.
+func WriteParquet(w io.Writer, columns []ParquetColumn) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns")
+	}
+	num_rows := columns[0].numRows()
+	for _, column := range columns {
+		if column.numRows() != num_rows || (column.Nulls != nil && len(column.Nulls) != num_rows) {
+			return fmt.Errorf("column '%s' has a different number of rows", column.Name)
+		}
+	}
+
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	if _, err := out.Write([]byte("PAR1")); err != nil {
+		return err
+	}
+
+	// File metadata, with the row groups added as they're written
+	meta := thriftWriter{}
+	meta.structBegin()
+	meta.i32(1, 1)
+	meta.listBegin(2, thriftTypeStruct, len(columns)+1)
+	meta.structBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.structEnd()
+	for _, column := range columns {
+		meta.structBegin()
+		meta.i32(1, column.physicalType())
+		if column.Nulls != nil {
+			meta.i32(3, parquetRepetitionOptional)
+		} else {
+			meta.i32(3, parquetRepetitionRequired)
+		}
+		meta.binary(4, column.Name)
+		if column.Strings != nil {
+			meta.i32(6, parquetConvertedUTF8)
+		}
+		meta.structEnd()
+	}
+	meta.i64(3, int64(num_rows))
+	num_row_groups := (num_rows + PARQUET_ROW_GROUP_ROWS - 1) / PARQUET_ROW_GROUP_ROWS
+	meta.listBegin(4, thriftTypeStruct, num_row_groups)
+	for from := 0; from < num_rows; from += PARQUET_ROW_GROUP_ROWS {
+		to := min(from+PARQUET_ROW_GROUP_ROWS, num_rows)
+		meta.structBegin()
+		meta.listBegin(1, thriftTypeStruct, len(columns))
+		total_size := int64(0)
+		for _, column := range columns {
+			chunk, err := column.writePages(out, from, to)
+			if err != nil {
+				return err
+			}
+			meta.structBegin()
+			meta.i64(2, chunk.offset)
+			meta.structField(3)
+			meta.i32(1, column.physicalType())
+			meta.listBegin(2, thriftTypeI32, 2)
+			meta.listI32(parquetEncodingPlain)
+			meta.listI32(parquetEncodingRLE)
+			meta.listBegin(3, thriftTypeBinary, 1)
+			meta.listBinary(column.Name)
+			meta.i32(4, parquetCodecUncompressed)
+			meta.i64(5, int64(to-from))
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.structEnd()
+			meta.structEnd()
+			total_size += chunk.size
+		}
+		meta.i64(2, total_size)
+		meta.i64(3, int64(to-from))
+		meta.structEnd()
+	}
+	meta.binary(6, "repo_dagger version "+VERSION)
+	meta.structEnd()
+
+	if _, err := out.Write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(out, binary.LittleEndian, uint32(meta.buf.Len())); err != nil {
+		return err
+	}
+	if _, err := out.Write([]byte("PAR1")); err != nil {
+		return err
+	}
+	return out.w.Flush()
+}
+
+// Thrift compact protocol types
+const (
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+// Just enough of the thrift compact protocol to write parquet metadata
+type thriftWriter struct {
+	buf        bytes.Buffer
+	last_field []int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, field_type byte) {
+	last := &t.last_field[len(t.last_field)-1]
+	delta := id - *last
+	if delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | field_type)
+	} else {
+		t.buf.WriteByte(field_type)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) structBegin() {
+	t.last_field = append(t.last_field, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buf.WriteByte(0)
+	t.last_field = t.last_field[:len(t.last_field)-1]
+}
+
+// Begin a struct-typed field, which must be closed with `structEnd`
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftTypeStruct)
+	t.structBegin()
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftTypeI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftTypeI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.fieldHeader(id, thriftTypeBinary)
+	t.listBinary(v)
+}
+
+// Begin a list field, followed by `size` elements (`list*` calls, or structs)
+func (t *thriftWriter) listBegin(id int16, elem_type byte, size int) {
+	t.fieldHeader(id, thriftTypeList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem_type)
+	} else {
+		t.buf.WriteByte(0xf0 | elem_type)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) listBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Returns every node in the graph as the columns of a parquet table of (path, size, hash).
+// Size and hash are null for files without local content (generated or federated files), and
+// hash is null if file hashes weren't calculated.
+func ParquetNodes(
+	file_relation_map map[string][]string,
+	all_files_set map[string]bool,
+	fileHashes map[string][32]byte,
+	config *Config,
+	base_dir string,
+	rewrites []PathRewrite,
+) ([]ParquetColumn, error) {
+	nodes := make([]string, 0, len(file_relation_map))
+	for node := range file_relation_map {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	paths := ParquetColumn{Name: "path", Strings: nodes}
+	sizes := ParquetColumn{Name: "size", Int64s: []int64{}, Nulls: []bool{}}
+	hashes := ParquetColumn{Name: "hash", Strings: []string{}, Nulls: []bool{}}
+	for _, node := range nodes {
+		_, _, is_generated := config.GeneratedSources(node)
+		if all_files_set[node] && !is_generated && !isAbstractNode(node) {
+			stat, err := os.Stat(filepath.Join(base_dir, node))
+			if err != nil {
+				return nil, fmt.Errorf("error while reading size of '%s': %v", node, err)
+			}
+			sizes.Int64s = append(sizes.Int64s, stat.Size())
+			sizes.Nulls = append(sizes.Nulls, false)
+		} else {
+			sizes.Int64s = append(sizes.Int64s, 0)
+			sizes.Nulls = append(sizes.Nulls, true)
+		}
+		if file_hash, ok := fileHashes[node]; ok {
+			hashes.Strings = append(hashes.Strings, fmt.Sprintf("%x", file_hash))
+			hashes.Nulls = append(hashes.Nulls, false)
+		} else {
+			hashes.Strings = append(hashes.Strings, "")
+			hashes.Nulls = append(hashes.Nulls, true)
+		}
+	}
+	paths.Strings = rewritePaths(rewrites, paths.Strings)
+	return []ParquetColumn{paths, sizes, hashes}, nil
+}
+
+// Returns every edge in the graph as the columns of a parquet table of (src, dst, type, rule,
+// conditional, python_import), with a row per origin of each edge. Rule is null for edges that weren't created by a specific rule,
+// and python_import for edges that didn't come from a Python import.
+func ParquetEdges(edge_origins EdgeOrigins, rewrites []PathRewrite) []ParquetColumn {
+	srcs := ParquetColumn{Name: "src", Strings: []string{}}
+	dsts := ParquetColumn{Name: "dst", Strings: []string{}}
+	types := ParquetColumn{Name: "type", Strings: []string{}}
+	rules := ParquetColumn{Name: "rule", Strings: []string{}, Nulls: []bool{}}
+	conditionals := ParquetColumn{Name: "conditional", Bools: []bool{}}
+	python_imports := ParquetColumn{Name: "python_import", Strings: []string{}, Nulls: []bool{}}
+	for _, edge := range edge_origins.SortedEdges() {
+		for _, origin := range edge_origins[edge] {
+			srcs.Strings = append(srcs.Strings, edge.From)
+			dsts.Strings = append(dsts.Strings, edge.To)
+			types.Strings = append(types.Strings, origin.Type)
+			rules.Strings = append(rules.Strings, origin.Rule)
+			rules.Nulls = append(rules.Nulls, origin.Rule == "")
+			conditionals.Bools = append(conditionals.Bools, origin.Conditional)
+			python_imports.Strings = append(python_imports.Strings, origin.PythonImport)
+			python_imports.Nulls = append(python_imports.Nulls, origin.PythonImport == "")
+		}
+	}
+	srcs.Strings = rewritePaths(rewrites, srcs.Strings)
+	dsts.Strings = rewritePaths(rewrites, dsts.Strings)
+	return []ParquetColumn{srcs, dsts, types, rules, conditionals, python_imports}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Just enough of the thrift compact protocol to read what `thriftWriter` writes: structs are
+// decoded as field id -> value, integers as int64
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (t *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(t.data[t.pos:])
+	if n <= 0 {
+		panic(fmt.Sprintf("invalid varint at %d", t.pos))
+	}
+	t.pos += n
+	return v
+}
+
+func (t *thriftReader) zigzag() int64 {
+	v := t.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (t *thriftReader) value(value_type byte) any {
+	switch value_type {
+	case thriftTypeI32, thriftTypeI64:
+		return t.zigzag()
+	case thriftTypeBinary:
+		size := int(t.varint())
+		t.pos += size
+		return string(t.data[t.pos-size : t.pos])
+	case thriftTypeList:
+		header := t.data[t.pos]
+		t.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(t.varint())
+		}
+		list := []any{}
+		for i := 0; i < size; i++ {
+			list = append(list, t.value(header&0xf))
+		}
+		return list
+	case thriftTypeStruct:
+		fields := map[int16]any{}
+		last := int16(0)
+		for {
+			header := t.data[t.pos]
+			t.pos++
+			if header == 0 {
+				return fields
+			}
+			if delta := int16(header >> 4); delta != 0 {
+				last += delta
+			} else {
+				last = int16(t.zigzag())
+			}
+			fields[last] = t.value(header & 0xf)
+		}
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", value_type))
+}
+
+type parquetTestPage struct {
+	num_values int
+	size       int
+}
+
+// Reads back a parquet file written by `WriteParquet`, with the pages of each column
+func readParquet(t *testing.T, data []byte) ([]ParquetColumn, []int, map[string][]parquetTestPage) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("missing parquet magic")
+	}
+	meta_size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	meta_reader := thriftReader{data: data[len(data)-8-meta_size : len(data)-8]}
+	meta := meta_reader.value(thriftTypeStruct).(map[int16]any)
+
+	columns := []ParquetColumn{}
+	for _, element := range meta[2].([]any)[1:] {
+		element := element.(map[int16]any)
+		column := ParquetColumn{Name: element[4].(string)}
+		switch element[1].(int64) {
+		case parquetTypeByteArray:
+			column.Strings = []string{}
+		case parquetTypeBoolean:
+			column.Bools = []bool{}
+		default:
+			column.Int64s = []int64{}
+		}
+		if element[3].(int64) == parquetRepetitionOptional {
+			column.Nulls = []bool{}
+		}
+		columns = append(columns, column)
+	}
+
+	row_group_rows := []int{}
+	pages := map[string][]parquetTestPage{}
+	for _, row_group := range meta[4].([]any) {
+		row_group := row_group.(map[int16]any)
+		row_group_rows = append(row_group_rows, int(row_group[3].(int64)))
+		for i, chunk := range row_group[1].([]any) {
+			column := &columns[i]
+			chunk_meta := chunk.(map[int16]any)[3].(map[int16]any)
+			offset := int(chunk_meta[9].(int64))
+			end := offset + int(chunk_meta[7].(int64))
+			num_values := 0
+			for offset < end {
+				page_reader := thriftReader{data: data, pos: offset}
+				header := page_reader.value(thriftTypeStruct).(map[int16]any)
+				page_size := int(header[3].(int64))
+				page_values := int(header[5].(map[int16]any)[1].(int64))
+				page := data[page_reader.pos : page_reader.pos+page_size]
+				offset = page_reader.pos + page_size
+				pages[column.Name] = append(pages[column.Name], parquetTestPage{page_values, page_size})
+				num_values += page_values
+
+				nulls := make([]bool, page_values)
+				if column.Nulls != nil {
+					levels_size := int(binary.LittleEndian.Uint32(page))
+					levels := thriftReader{data: page[4 : 4+levels_size]}
+					for row := 0; row < page_values; {
+						run := int(levels.varint() >> 1)
+						is_null := levels.data[levels.pos] == 0
+						levels.pos++
+						for j := 0; j < run; j++ {
+							nulls[row+j] = is_null
+						}
+						row += run
+					}
+					page = page[4+levels_size:]
+					column.Nulls = append(column.Nulls, nulls...)
+				}
+				bit := 0
+				for row := 0; row < page_values; row++ {
+					switch {
+					case column.Strings != nil:
+						if nulls[row] {
+							column.Strings = append(column.Strings, "")
+							continue
+						}
+						size := int(binary.LittleEndian.Uint32(page))
+						column.Strings = append(column.Strings, string(page[4:4+size]))
+						page = page[4+size:]
+					case column.Bools != nil:
+						if nulls[row] {
+							column.Bools = append(column.Bools, false)
+							continue
+						}
+						column.Bools = append(column.Bools, page[bit/8]&(1<<(bit%8)) != 0)
+						bit++
+					default:
+						if nulls[row] {
+							column.Int64s = append(column.Int64s, 0)
+							continue
+						}
+						column.Int64s = append(column.Int64s, int64(binary.LittleEndian.Uint64(page)))
+						page = page[8:]
+					}
+				}
+			}
+			if num_values != int(chunk_meta[5].(int64)) {
+				t.Errorf("column chunk of '%s' has %d values, its metadata says %d", column.Name, num_values, chunk_meta[5])
+			}
+		}
+	}
+	if total := int(meta[3].(int64)); total != columns[0].numRows() {
+		t.Errorf("read %d rows, the metadata says %d", columns[0].numRows(), total)
+	}
+	return columns, row_group_rows, pages
+}
+
+func parquetTestColumns(num_rows int, string_size int) []ParquetColumn {
+	paths := ParquetColumn{Name: "path", Strings: []string{}}
+	sizes := ParquetColumn{Name: "size", Int64s: []int64{}, Nulls: []bool{}}
+	flags := ParquetColumn{Name: "flag", Bools: []bool{}}
+	rules := ParquetColumn{Name: "rule", Strings: []string{}, Nulls: []bool{}}
+	for i := 0; i < num_rows; i++ {
+		paths.Strings = append(paths.Strings, fmt.Sprintf("dir%d/file%d.py", i%7, i)+strings.Repeat("x", string_size))
+		sizes.Int64s = append(sizes.Int64s, int64(i)*1000-5)
+		sizes.Nulls = append(sizes.Nulls, i%5 == 0)
+		if i%5 == 0 {
+			sizes.Int64s[i] = 0
+		}
+		flags.Bools = append(flags.Bools, i%3 == 0)
+		rule := ""
+		if i%4 != 0 {
+			rule = fmt.Sprintf("rule %d", i%4)
+		}
+		rules.Strings = append(rules.Strings, rule)
+		rules.Nulls = append(rules.Nulls, rule == "")
+	}
+	return []ParquetColumn{paths, sizes, flags, rules}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		num_rows    int
+		string_size int
+	}{
+		{"empty", 0, 0},
+		{"single_row", 1, 0},
+		{"small", 100, 0},
+		// Several row groups, and pages cut by size
+		{"row_groups", 2*PARQUET_ROW_GROUP_ROWS + 10, 0},
+		{"large_values", 5000, 2000},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			columns := parquetTestColumns(test.num_rows, test.string_size)
+			buf := bytes.Buffer{}
+			if err := WriteParquet(&buf, columns); err != nil {
+				t.Fatal(err)
+			}
+			read, row_group_rows, pages := readParquet(t, buf.Bytes())
+			if len(read) != len(columns) {
+				t.Fatalf("read %d columns, expected %d", len(read), len(columns))
+			}
+			for i := range columns {
+				expected, got := columns[i], read[i]
+				if got.Name != expected.Name ||
+					!slices.Equal(got.Strings, expected.Strings) ||
+					!slices.Equal(got.Int64s, expected.Int64s) ||
+					!slices.Equal(got.Bools, expected.Bools) ||
+					!slices.Equal(got.Nulls, expected.Nulls) {
+					t.Errorf("column '%s' didn't round-trip", expected.Name)
+				}
+			}
+			for _, rows := range row_group_rows {
+				if rows > PARQUET_ROW_GROUP_ROWS {
+					t.Errorf("row group of %d rows, more than %d", rows, PARQUET_ROW_GROUP_ROWS)
+				}
+			}
+			if expected := (test.num_rows + PARQUET_ROW_GROUP_ROWS - 1) / PARQUET_ROW_GROUP_ROWS; len(row_group_rows) != expected {
+				t.Errorf("%d row groups, expected %d", len(row_group_rows), expected)
+			}
+			if test.num_rows*test.string_size > 2*PARQUET_PAGE_SIZE && len(pages["path"]) < 2 {
+				t.Errorf("column 'path' is in %d pages, expected it to be cut by size", len(pages["path"]))
+			}
+			for name, column_pages := range pages {
+				for _, page := range column_pages {
+					// A page is cut once it reaches the page size, so it's over by less than a value
+					if page.size > PARQUET_PAGE_SIZE+4+test.string_size+32 {
+						t.Errorf("page of '%s' is %d bytes, more than %d", name, page.size, PARQUET_PAGE_SIZE)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParquetMismatchedColumns(t *testing.T) {
+	columns := parquetTestColumns(10, 0)
+	columns[1].Nulls = columns[1].Nulls[:5]
+	if err := WriteParquet(&bytes.Buffer{}, columns); err == nil {
+		t.Error("expected an error for columns with different numbers of rows")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Maps repo paths matching a regex onto other paths, using "$1"-style templates
+type PathMapping struct {
+	name      string
+	pattern   *regexp.Regexp
+	templates []string
+}
+
+// Compile a `regex -> templates` map from the config.
+// The regexes must match the whole path. Mappings are ordered by their pattern, so the first
+// matching mapping is the same on every run.
+func compilePathMappings(mappings map[string][]string) ([]PathMapping, error) {
+	patterns := make([]string, 0, len(mappings))
+	for pattern := range mappings {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	out := make([]PathMapping, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("error while compiling path pattern '%s': %v", pattern, err)
+		}
+		out = append(out, PathMapping{
+			name:      pattern,
+			pattern:   compiled,
+			templates: mappings[pattern],
+		})
+	}
+	return out, nil
+}
+
+// Returns the mapped paths if the path matches this mapping
+func (mapping *PathMapping) Apply(path string) ([]string, bool) {
+	match := mapping.pattern.FindStringSubmatch(path)
+	if match == nil {
+		return nil, false
+	}
+	return RegexResult(match).applyOnTemplates(mapping.templates), true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A path prefix to replace in the outputs (`-rewrite-path-prefixes`)
+type PathRewrite struct {
+	From string
+	To   string
+}
+
+// Parses comma separated `from=to` prefixes, where an empty `to` strips the prefix
+func parsePathRewrites(value string) ([]PathRewrite, error) {
+	rewrites := []PathRewrite{}
+	if value == "" {
+		return rewrites, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		from, to, ok := strings.Cut(item, "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("invalid path prefix rewrite '%s', expected 'from=to'", item)
+		}
+		rewrites = append(rewrites, PathRewrite{From: from, To: to})
+	}
+	return rewrites, nil
+}
+
+// Rewrites the first matching prefix of a path
+func rewritePath(rewrites []PathRewrite, path string) string {
+	for _, rewrite := range rewrites {
+		if rest, ok := strings.CutPrefix(path, rewrite.From); ok {
+			return rewrite.To + rest
+		}
+	}
+	return path
+}
+
+func rewritePaths(rewrites []PathRewrite, paths []string) []string {
+	rewritten := make([]string, len(paths))
+	for i, path := range paths {
+		rewritten[i] = rewritePath(rewrites, path)
+	}
+	return rewritten
+}
+
+func rewriteJsonValue(rewrites []PathRewrite, value any) (any, error) {
+	switch value := value.(type) {
+	case string:
+		return rewritePath(rewrites, value), nil
+	case []any:
+		for i, item := range value {
+			rewritten, err := rewriteJsonValue(rewrites, item)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = rewritten
+		}
+		return value, nil
+	case map[string]any:
+		rewritten_map := make(map[string]any, len(value))
+		original_keys := make(map[string]string, len(value))
+		for key, item := range value {
+			rewritten_key := rewritePath(rewrites, key)
+			if other_key, ok := original_keys[rewritten_key]; ok {
+				return nil, fmt.Errorf("rewriting path prefixes merges '%s' and '%s'", other_key, key)
+			}
+			original_keys[rewritten_key] = key
+			rewritten, err := rewriteJsonValue(rewrites, item)
+			if err != nil {
+				return nil, err
+			}
+			rewritten_map[rewritten_key] = rewritten
+		}
+		return rewritten_map, nil
+	}
+	return value, nil
+}
+
+// Rewrites the path prefixes of every string (and map key) of an output. Strings that aren't paths
+// are left alone unless they start with one of the prefixes.
+func rewriteJsonPaths(rewrites []PathRewrite, value any) (any, error) {
+	if len(rewrites) == 0 {
+		return value, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeGenericJson(data)
+	if err != nil {
+		return nil, err
+	}
+	return rewriteJsonValue(rewrites, decoded)
+}
@@ -0,0 +1,324 @@
+// Package schema defines the formats of the artifacts exported by repo_dagger.
+//
+// All artifacts are JSON files. Paths are always relative to the analyzed repository's base
+// directory, use forward slashes, and map keys are emitted in sorted order.
+//
+// Compatibility: within a schema Version, fields and artifacts may be added, but existing ones
+// are never removed, renamed, or have their meaning changed. Any such change bumps Version.
+// Consumers should ignore fields they don't know.
+package schema
+
+import (
+	"embed"
+	"fmt"
+)
+
+// The version of the artifact formats in this package.
+// Bumped on any backward-incompatible change to any artifact.
+const Version = 1
+
+// Output of `-out-dep-hashes`: input file -> hex SHA-256 of the input and all of its
+// recursive dependencies (by their hashes, with the config's `file_hash_algorithm`).
+type DepHashes map[string]string
+
+// Output of `-out-env-dep-hashes`: hash environment name -> dependency hashes in that environment.
+type EnvDepHashes map[string]DepHashes
+
+// Output of `-out-relations`: file -> sorted list of its direct dependencies.
+// Also the output of `-out-reduced-relations`, with edges implied by longer paths removed, of
+// `-out-config-graph`, with config files (paths as loaded) and the configs they include, and of
+// `-out-target-graph`, with the targets of `target_graph` and the targets they depend on.
+type Relations map[string][]string
+
+// Output of `-out-relations-with-counts`: like `-out-relations`, with the number of direct
+// dependencies and direct dependents of each file precomputed, and how its Python imports reached
+// its dependencies.
+type RelationsWithCounts map[string]NodeRelations
+
+// A file's direct dependencies in `RelationsWithCounts`
+type NodeRelations struct {
+	// Sorted list of its direct dependencies
+	Deps []string `json:"deps"`
+	// The number of its direct dependencies
+	DepCount int `json:"dep_count"`
+	// The number of files that depend on it directly
+	DependentCount int `json:"dependent_count"`
+	// Dependency -> how Python imports reached it ("import", "from_import", "submodule_expansion",
+	// "dynamic_import" or "re_export"), for the dependencies reached by Python imports
+	PythonImports map[string][]string `json:"python_imports,omitempty"`
+}
+
+// Output of `-out-owner-matrix`: CODEOWNERS owner -> other owner whose files its files depend on
+// directly -> the number of such edges.
+type OwnerMatrix map[string]map[string]int
+
+// Output of `-out-provenance`: an in-toto statement with a SLSA v1 provenance predicate, attesting
+// the dependency hash outputs of a run.
+type Provenance struct {
+	// "https://in-toto.io/Statement/v1"
+	Type string `json:"_type"`
+	// The dependency hash outputs (and their signature) written by the run
+	Subject []ProvenanceResource `json:"subject"`
+	// "https://slsa.dev/provenance/v1"
+	PredicateType string              `json:"predicateType"`
+	Predicate     ProvenancePredicate `json:"predicate"`
+}
+
+type ProvenancePredicate struct {
+	BuildDefinition ProvenanceBuildDefinition `json:"buildDefinition"`
+	RunDetails      ProvenanceRunDetails      `json:"runDetails"`
+}
+
+type ProvenanceBuildDefinition struct {
+	BuildType string `json:"buildType"`
+	// The flags given on the command line, and their values
+	ExternalParameters map[string]string `json:"externalParameters"`
+	// The config, and every hashed file of the graph (relative to the base directory)
+	ResolvedDependencies []ProvenanceResource `json:"resolvedDependencies"`
+}
+
+// An in-toto resource descriptor
+type ProvenanceResource struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type ProvenanceRunDetails struct {
+	Builder  ProvenanceBuilder  `json:"builder"`
+	Metadata ProvenanceMetadata `json:"metadata"`
+}
+
+type ProvenanceBuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version"`
+}
+
+type ProvenanceMetadata struct {
+	// In RFC 3339 (UTC)
+	StartedOn  string `json:"startedOn"`
+	FinishedOn string `json:"finishedOn"`
+}
+
+// Output of `-out-unresolved-imports`: imports of `root_python_packages` that didn't resolve to any
+// file, sorted by file and line.
+type UnresolvedImports []UnresolvedImport
+
+type UnresolvedImport struct {
+	// The importing file, and the 1-based line of the import
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// The imported module
+	Module string `json:"module"`
+	// Whether the import is inside an `if`/`try` block, an `if TYPE_CHECKING:` block, or a
+	// `try`/`except ImportError` block
+	Conditional bool `json:"conditional"`
+	TypeOnly    bool `json:"type_only"`
+	Optional    bool `json:"optional"`
+}
+
+// Output of `-out-recursive-deps`: sorted list of the recursive dependencies of a single input
+// file, including itself.
+type RecursiveDeps []string
+
+// Output of `-out-file-hashes`: file -> hex hash of its contents, with the config's
+// `file_hash_algorithm` (SHA-256 by default).
+// Files without content of their own (e.g. generated files) are omitted.
+type FileHashes map[string]string
+
+// Output of `-out-config-impact`: how switching to the `-proposed-config` affects each input.
+type ConfigImpact struct {
+	// Whether the config file changed, which changes every dependency hash by itself
+	ConfigHashChanged bool `json:"config_hash_changed"`
+	// Input file -> its changes, only for inputs that change (not counting the config hash)
+	Targets map[string]TargetImpact `json:"targets"`
+}
+
+// How a single input is affected by a config change
+type TargetImpact struct {
+	// "added" or "removed" if it's an input of only one of the configs, otherwise "changed"
+	Status string `json:"status"`
+	// Recursive dependencies only in the proposed config
+	AddedDeps []string `json:"added_deps,omitempty"`
+	// Recursive dependencies only in the current config
+	RemovedDeps []string `json:"removed_deps,omitempty"`
+	// Whether the dependency hash changes, not counting the config hash
+	HashChanged bool `json:"hash_changed"`
+}
+
+// Output of `-out-affected`: input file -> why the `-changed-files` affect it, only for affected
+// inputs. Also the output of `repo_dagger replay-affected`, with the files changed between two runs.
+type Affected map[string]AffectedTarget
+
+// Why a single input is affected
+type AffectedTarget struct {
+	// "direct" if it changed itself, "transitive" if a change reaches it through a dependency
+	// created by a rule, "global_dep" if only through `global_deps`, or "config" if only the config
+	// (or an included config) changed
+	Category string `json:"category"`
+	// The direct dependency the change reaches it through ("transitive" and "global_dep")
+	Via string `json:"via,omitempty"`
+	// Sorted changed files among the recursive dependencies of `via` (or the input itself, if
+	// "direct")
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Output of `-out-schedule`: the earliest each input can run given the `-target-durations` and the
+// other inputs it depends on, and the critical path through them. Times are in seconds.
+type Schedule struct {
+	// The chain of inputs that takes the longest, in the order they run
+	CriticalPath []string `json:"critical_path"`
+	// When the last input finishes, with unlimited runners
+	Makespan float64 `json:"makespan"`
+	// The sum of the durations of all inputs (the time on a single runner)
+	TotalDuration float64 `json:"total_duration"`
+	// The most inputs running at once in this schedule (runners beyond it don't help)
+	MaxParallelism int `json:"max_parallelism"`
+	// Input file -> when it runs
+	Targets map[string]ScheduledTarget `json:"targets"`
+}
+
+// When a single input runs in the `Schedule`
+type ScheduledTarget struct {
+	Start  float64 `json:"start"`
+	Finish float64 `json:"finish"`
+	// Sorted other inputs among its recursive dependencies, which must finish before it starts
+	DependsOn []string `json:"depends_on"`
+}
+
+// Output of `-out-graph-snapshot`: everything that goes into `-out-dep-hashes` other than the file
+// hashes, so `repo_dagger self-check` can recompute the dependency hashes from it and the
+// `-out-file-hashes` output alone.
+type GraphSnapshot struct {
+	AlgorithmVersion uint64 `json:"algorithm_version"`
+	// Hex SHA-256 of the config file, the configs it includes and the `-define` values it uses
+	ConfigHash string `json:"config_hash"`
+	// The `-hash-salt`
+	Salt string `json:"salt"`
+	// Hex of the toolchain fingerprint (including the binary's hash, with `-hash-tool-binary`)
+	ToolFingerprint string `json:"tool_fingerprint"`
+	// The captured external inputs, in hashing order
+	ExternalInputs []SnapshotExternalInput `json:"external_inputs"`
+	// File -> hex hash of its interface (with the `file_hash_algorithm`), only with
+	// `-experimental-interface-hashes`
+	InterfaceHashes map[string]string `json:"interface_hashes,omitempty"`
+	// Input file -> what its dependency hash covers
+	Targets map[string]SnapshotTarget `json:"targets"`
+}
+
+// A captured external input in a graph snapshot
+type SnapshotExternalInput struct {
+	Name string `json:"name"`
+	// Hex SHA-256 of the captured value
+	Hash string `json:"hash"`
+	// The input only applies to input files matching these patterns (all if empty)
+	Targets []string `json:"targets,omitempty"`
+}
+
+// The dependencies covered by an input's dependency hash in a graph snapshot
+type SnapshotTarget struct {
+	// Sorted recursive dependencies after the target group filters, including the input itself
+	Deps []string `json:"deps"`
+	// The dependencies hashed by their interface hash
+	InterfaceOnlyDeps []string `json:"interface_only_deps,omitempty"`
+}
+
+// A row of `-out-parquet-nodes`, a parquet table of every node in the graph.
+// Size and Hash are null for files without local content (generated or federated files), and
+// Hash is null if file hashes weren't calculated.
+type NodeRow struct {
+	Path string  `parquet:"path"`
+	Size *int64  `parquet:"size"`
+	Hash *string `parquet:"hash"`
+}
+
+// A row of `-out-parquet-edges`, a parquet table of every edge in the graph (Src depends on Dst),
+// with a row per origin of each edge.
+// Type is what created the edge ("rule", "global", "generated", "federated", "test" or a rule's
+// `edge_type`), and Rule is the specific rule pattern, if any.
+// Conditional is set for edges from imports inside `if`/`try` blocks (soft dependencies).
+// PythonImport is how a Python import created the edge (see `NodeRelations.PythonImports`), if any.
+type EdgeRow struct {
+	Src          string  `parquet:"src"`
+	Dst          string  `parquet:"dst"`
+	Type         string  `parquet:"type"`
+	Rule         *string `parquet:"rule"`
+	Conditional  bool    `parquet:"conditional"`
+	PythonImport *string `parquet:"python_import"`
+}
+
+// Output of `-out-run-manifest`: what a run did, for build provenance.
+type RunManifest struct {
+	// The repo_dagger version, and the version of these formats
+	ToolVersion   string `json:"tool_version"`
+	SchemaVersion int    `json:"schema_version"`
+	// When the run started, in RFC 3339 (UTC)
+	StartedAt string `json:"started_at"`
+	// The path or URL of the config, and the hex SHA-256 of it, the configs it includes and the
+	// `-define` values it uses (as in graph snapshots)
+	Config     string `json:"config"`
+	ConfigHash string `json:"config_hash"`
+	// Flag name -> value, for the flags given on the command line
+	Flags map[string]string `json:"flags"`
+	// Sorted input files, after expanding the config's `inputs`
+	Inputs []string `json:"inputs"`
+	// The output files written, sorted by path (excluding the manifest itself)
+	Artifacts []RunArtifact `json:"artifacts"`
+	// Phase of the run -> seconds it took, and "total"
+	Timings map[string]float64 `json:"timings"`
+	// The number of warnings logged
+	Warnings int `json:"warnings"`
+}
+
+// An output file written by a run
+type RunArtifact struct {
+	// The flag of the output, e.g. "out-dep-hashes"
+	Flag string `json:"flag"`
+	// The path written ("-" for stdout), or the chunk's path for split outputs
+	Path string `json:"path"`
+	// Hex SHA-256 and size of its contents
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Written in place of an output larger than `-max-output-bytes`. The output is split into chunks
+// next to it (`relations.json` -> `relations.000.json`, ...), each in the output's own format with
+// some of its entries (object keys or array items, in order) or parquet rows. Merging the chunks
+// gives the output.
+type ChunkedOutput struct {
+	// Always true, which tells the index apart from the outputs
+	Chunked bool `json:"chunked"`
+	// The file names of the chunks, in order, in the index's directory
+	Chunks []string `json:"chunks"`
+}
+
+//go:embed jsonschema/*.schema.json
+var jsonSchemas embed.FS
+
+// Names of the artifacts that have a JSON schema
+var Artifacts = []string{
+	"affected",
+	"chunked_output",
+	"config_impact",
+	"dep_hashes",
+	"env_dep_hashes",
+	"file_hashes",
+	"graph_snapshot",
+	"owner_matrix",
+	"provenance",
+	"recursive_deps",
+	"relations",
+	"relations_with_counts",
+	"run_manifest",
+	"schedule",
+	"unresolved_imports",
+}
+
+// Returns the JSON schema (draft 2020-12) of the given artifact
+func JSONSchema(artifact string) ([]byte, error) {
+	data, err := jsonSchemas.ReadFile("jsonschema/" + artifact + ".schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("unknown artifact '%s'", artifact)
+	}
+	return data, nil
+}
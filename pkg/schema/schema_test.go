@@ -0,0 +1,243 @@
+package schema
+
+import (
+	"encoding/json"
+	"io/fs"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The Go type of each artifact with a JSON schema
+var artifactTypes = map[string]reflect.Type{
+	"affected":              reflect.TypeFor[Affected](),
+	"chunked_output":        reflect.TypeFor[ChunkedOutput](),
+	"config_impact":         reflect.TypeFor[ConfigImpact](),
+	"dep_hashes":            reflect.TypeFor[DepHashes](),
+	"env_dep_hashes":        reflect.TypeFor[EnvDepHashes](),
+	"file_hashes":           reflect.TypeFor[FileHashes](),
+	"graph_snapshot":        reflect.TypeFor[GraphSnapshot](),
+	"owner_matrix":          reflect.TypeFor[OwnerMatrix](),
+	"provenance":            reflect.TypeFor[Provenance](),
+	"recursive_deps":        reflect.TypeFor[RecursiveDeps](),
+	"relations":             reflect.TypeFor[Relations](),
+	"relations_with_counts": reflect.TypeFor[RelationsWithCounts](),
+	"run_manifest":          reflect.TypeFor[RunManifest](),
+	"schedule":              reflect.TypeFor[Schedule](),
+	"unresolved_imports":    reflect.TypeFor[UnresolvedImports](),
+}
+
+func loadSchema(t *testing.T, artifact string) map[string]any {
+	t.Helper()
+	data, err := JSONSchema(artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := map[string]any{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("invalid schema of %s: %v", artifact, err)
+	}
+	return schema
+}
+
+func TestArtifactsHaveSchemas(t *testing.T) {
+	files, err := fs.Glob(jsonSchemas, "jsonschema/*.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(file, "jsonschema/"), ".schema.json"))
+	}
+	if !slices.Equal(names, Artifacts) {
+		t.Errorf("schema files %v don't match Artifacts %v", names, Artifacts)
+	}
+	for _, artifact := range Artifacts {
+		if _, ok := artifactTypes[artifact]; !ok {
+			t.Errorf("artifact %s has no Go type in the test", artifact)
+		}
+		schema := loadSchema(t, artifact)
+		if id := "https://github.com/Wazzaps/repo_dagger/schema/v1/" + artifact + ".schema.json"; schema["$id"] != id {
+			t.Errorf("%s: $id is %v, expected %s (of Version %d)", artifact, schema["$id"], id, Version)
+		}
+	}
+}
+
+// The JSON types a Go type may be encoded as
+func jsonTypes(typ reflect.Type) []string {
+	switch typ.Kind() {
+	case reflect.String:
+		return []string{"string"}
+	case reflect.Bool:
+		return []string{"boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		return []string{"integer"}
+	case reflect.Float64:
+		return []string{"number"}
+	case reflect.Map, reflect.Struct:
+		return []string{"object"}
+	case reflect.Slice:
+		// nil slices are encoded as null
+		return []string{"array", "null"}
+	}
+	return nil
+}
+
+// Checks that a JSON schema describes the JSON encoding of a Go type, field by field
+func checkSchema(t *testing.T, where string, schema map[string]any, root map[string]any, typ reflect.Type) {
+	t.Helper()
+	if ref, ok := schema["$ref"].(string); ok {
+		if def, ok := strings.CutPrefix(ref, "#/$defs/"); ok {
+			schema = root["$defs"].(map[string]any)[def].(map[string]any)
+		} else {
+			root = loadSchema(t, strings.TrimSuffix(ref, ".schema.json"))
+			schema = root
+		}
+	}
+	allowed := jsonTypes(typ)
+	schema_types := []string{}
+	switch schema_type := schema["type"].(type) {
+	case string:
+		schema_types = append(schema_types, schema_type)
+	case []any:
+		for _, item := range schema_type {
+			schema_types = append(schema_types, item.(string))
+		}
+	}
+	// The type of a constant or an enum is the type of its values
+	values, _ := schema["enum"].([]any)
+	if value, ok := schema["const"]; ok {
+		values = append(values, value)
+	}
+	for _, value := range values {
+		switch value.(type) {
+		case string:
+			schema_types = append(schema_types, "string")
+		case bool:
+			schema_types = append(schema_types, "boolean")
+		default:
+			t.Errorf("%s: unexpected value %v", where, value)
+		}
+	}
+	if len(schema_types) == 0 {
+		t.Errorf("%s: no type", where)
+		return
+	}
+	for _, schema_type := range schema_types {
+		// Numbers may be described as integers, if they always are
+		if !slices.Contains(allowed, schema_type) && !(schema_type == "integer" && typ.Kind() == reflect.Float64) {
+			t.Errorf("%s: type %s, but %s is encoded as %v", where, schema_type, typ, allowed)
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			t.Errorf("%s: map keys of %s aren't strings", where, typ)
+		}
+		items, ok := schema["additionalProperties"].(map[string]any)
+		if !ok {
+			t.Errorf("%s: no additionalProperties for %s", where, typ)
+			return
+		}
+		checkSchema(t, where+".*", items, root, typ.Elem())
+	case reflect.Slice:
+		items, ok := schema["items"].(map[string]any)
+		if !ok {
+			t.Errorf("%s: no items for %s", where, typ)
+			return
+		}
+		checkSchema(t, where+"[]", items, root, typ.Elem())
+	case reflect.Struct:
+		properties, _ := schema["properties"].(map[string]any)
+		required := map[string]bool{}
+		if schema_required, ok := schema["required"].([]any); ok {
+			for _, name := range schema_required {
+				required[name.(string)] = true
+			}
+		}
+		fields := map[string]bool{}
+		for i := 0; i < typ.NumField(); i++ {
+			name, options, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				t.Errorf("%s: field %s of %s has no json name", where, typ.Field(i).Name, typ)
+				continue
+			}
+			fields[name] = true
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				t.Errorf("%s: field %s of %s isn't in the schema", where, name, typ)
+				continue
+			}
+			// Fields are always written, unless they're omitted when empty
+			if omitempty := strings.Contains(options, "omitempty"); omitempty == required[name] {
+				t.Errorf("%s: field %s of %s is required: %v, but omitempty: %v", where, name, typ, required[name], omitempty)
+			}
+			checkSchema(t, where+"."+name, property, root, typ.Field(i).Type)
+		}
+		for name := range properties {
+			if !fields[name] {
+				t.Errorf("%s: property %s isn't a field of %s", where, name, typ)
+			}
+		}
+	}
+}
+
+func TestSchemasMatchTypes(t *testing.T) {
+	for artifact, typ := range artifactTypes {
+		t.Run(artifact, func(t *testing.T) {
+			schema := loadSchema(t, artifact)
+			checkSchema(t, artifact, schema, schema, typ)
+		})
+	}
+}
+
+// Fills every field, map and slice of a value, so nothing is omitted when it's encoded
+func fillValue(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString("src/a.py")
+	case reflect.Bool:
+		value.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		value.SetInt(3)
+	case reflect.Uint64:
+		value.SetUint(3)
+	case reflect.Float64:
+		value.SetFloat(1.5)
+	case reflect.Map:
+		value.Set(reflect.MakeMap(value.Type()))
+		item := reflect.New(value.Type().Elem()).Elem()
+		fillValue(item)
+		value.SetMapIndex(reflect.ValueOf("src/b.py").Convert(value.Type().Key()), item)
+	case reflect.Slice:
+		value.Set(reflect.MakeSlice(value.Type(), 1, 1))
+		fillValue(value.Index(0))
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			fillValue(value.Field(i))
+		}
+	}
+}
+
+// The artifacts read back into their Go types unchanged, with every field in the schema
+func TestArtifactsRoundTrip(t *testing.T) {
+	for artifact, typ := range artifactTypes {
+		t.Run(artifact, func(t *testing.T) {
+			value := reflect.New(typ)
+			fillValue(value.Elem())
+			data, err := json.Marshal(value.Interface())
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := reflect.New(typ)
+			if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded.Interface(), value.Interface()) {
+				t.Errorf("%s didn't round-trip: %s", artifact, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+const (
+	IN_TOTO_STATEMENT_TYPE     = "https://in-toto.io/Statement/v1"
+	SLSA_PROVENANCE_TYPE       = "https://slsa.dev/provenance/v1"
+	PROVENANCE_BUILD_TYPE      = "https://github.com/Wazzaps/repo_dagger/provenance/v1"
+	DEFAULT_PROVENANCE_BUILDER = "https://github.com/Wazzaps/repo_dagger"
+)
+
+// Returns an in-toto statement attesting the dependency hash outputs written so far: their
+// digests, the flags and config that produced them, and the hash of every file they depend on
+func CalculateProvenance(
+	args *Args,
+	config *Config,
+	config_hash [32]byte,
+	fileHashes map[string][32]byte,
+) schema.Provenance {
+	subjects := []schema.ProvenanceResource{}
+	for _, artifact := range run_recorder.ArtifactsOf("out-dep-hashes", "out-dep-hashes-sig", "out-env-dep-hashes") {
+		subjects = append(subjects, schema.ProvenanceResource{
+			Name:   artifact.Path,
+			Digest: map[string]string{"sha256": artifact.SHA256},
+		})
+	}
+
+	dependencies := []schema.ProvenanceResource{{
+		URI:    args.Config,
+		Digest: map[string]string{"sha256": fmt.Sprintf("%x", config_hash)},
+	}}
+	files := make([]string, 0, len(fileHashes))
+	for file := range fileHashes {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		dependencies = append(dependencies, schema.ProvenanceResource{
+			URI:    file,
+			Digest: map[string]string{config.fileHashAlgorithm().name: fmt.Sprintf("%x", fileHashes[file])},
+		})
+	}
+
+	return schema.Provenance{
+		Type:          IN_TOTO_STATEMENT_TYPE,
+		Subject:       subjects,
+		PredicateType: SLSA_PROVENANCE_TYPE,
+		Predicate: schema.ProvenancePredicate{
+			BuildDefinition: schema.ProvenanceBuildDefinition{
+				BuildType:            PROVENANCE_BUILD_TYPE,
+				ExternalParameters:   givenFlags(),
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: schema.ProvenanceRunDetails{
+				Builder: schema.ProvenanceBuilder{
+					ID:      args.ProvenanceBuilderID,
+					Version: map[string]string{"repo_dagger": VERSION},
+				},
+				Metadata: schema.ProvenanceMetadata{
+					StartedOn:  run_recorder.start.UTC().Format(time.RFC3339),
+					FinishedOn: time.Now().UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// `{ callable = "pkg.cli:main" }` of Poetry scripts
+var poetry_script_callable_parser = regexp.MustCompile(`\bcallable\s*=\s*["']([^"']+)["']`)
+
+// Resolves `pyproject.toml` files to the modules of their console scripts and entry points
+// (`[project.scripts]`, `[project.gui-scripts]`, `[project.entry-points.*]`, and Poetry's
+// `[tool.poetry.scripts]` and `[tool.poetry.plugins.*]`), like `module:function` targets are
+// imported. Modules outside `root_python_packages` are ignored.
+type PyprojectResolver struct {
+	python *PythonModuleResolver
+}
+
+// Whether a `pyproject.toml` section lists entry points
+func isEntryPointsSection(section string) bool {
+	section = strings.ReplaceAll(section, `"`, "")
+	switch section {
+	case "project.scripts", "project.gui-scripts", "tool.poetry.scripts":
+		return true
+	}
+	return strings.HasPrefix(section, "project.entry-points.") || strings.HasPrefix(section, "tool.poetry.plugins.")
+}
+
+// The module of an entry point's object reference (`pkg.cli:main [extra]`)
+func entryPointModule(value string) string {
+	if match := poetry_script_callable_parser.FindStringSubmatch(value); match != nil {
+		value = match[1]
+	} else if strings.HasPrefix(value, "{") {
+		return ""
+	}
+	// Without a trailing comment
+	if value = strings.TrimSpace(value); value != "" && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end != -1 {
+			value = value[1 : end+1]
+		}
+	}
+	module, _, _ := strings.Cut(value, ":")
+	module, _, _ = strings.Cut(module, "[")
+	return strings.TrimSpace(module)
+}
+
+func (res *PyprojectResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if filepath.Base(file) != "pyproject.toml" {
+		return nil, nil
+	}
+	paths := []string{}
+	section := ""
+	for _, line := range strings.Split(file_data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if match := cargo_section_parser.FindStringSubmatch(line); match != nil {
+			section = match[1]
+			continue
+		}
+		match := cargo_key_parser.FindStringSubmatch(line)
+		if match == nil || !isEntryPointsSection(section) {
+			continue
+		}
+		module := entryPointModule(match[2])
+		if module == "" || strings.HasPrefix(module, ".") {
+			continue
+		}
+		resolved, err := res.python.Resolve(module, config, base_dir)
+		if err != nil {
+			return nil, fmt.Errorf("error while resolving entry point module '%s': %v", module, err)
+		}
+		paths = append(paths, resolved.Paths...)
+		paths = append(paths, resolved.ParentPaths...)
+	}
+	return paths, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import "strings"
+
+// A name imported by a Python import statement, with its `as` alias (empty if none)
+type pythonImportedName struct {
+	name  string
+	alias string
+}
+
+// An `import` or `from ... import` statement (or their Cython `cimport` forms)
+type pythonImportStatement struct {
+	// Offset of the statement in the file
+	offset int
+	// The module of `from ... import`, including the leading dots of relative imports. Empty for
+	// `import`.
+	from string
+	// The modules of `import`, or the names of `from ... import` (`*` for star imports)
+	names []pythonImportedName
+}
+
+type pythonToken struct {
+	value  string
+	offset int
+}
+
+func isPythonIdentByte(c byte) bool {
+	return c == '_' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isPythonIdent(token string) bool {
+	return token != "" && isPythonIdentByte(token[0]) && (token[0] < '0' || token[0] > '9')
+}
+
+// Returns the offset after the string literal starting at `start` (or the end of its line, if it
+// isn't terminated)
+func skipPythonString(data string, start int) int {
+	quote := data[start]
+	if strings.HasPrefix(data[start:], strings.Repeat(string(quote), 3)) {
+		for i := start + 3; i < len(data); i++ {
+			if data[i] == '\\' {
+				i++
+			} else if strings.HasPrefix(data[i:], strings.Repeat(string(quote), 3)) {
+				return i + 3
+			}
+		}
+		return len(data)
+	}
+	for i := start + 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '\n':
+			return i
+		case quote:
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+// Splits Python source into the tokens of its logical statements. Comments are skipped and string
+// literals become a single `"` token, lines are joined inside brackets and after a backslash, and
+// split on `;`.
+func pythonStatements(data string) [][]pythonToken {
+	statements := [][]pythonToken{}
+	statement := []pythonToken{}
+	depth := 0
+	endStatement := func() {
+		if len(statement) != 0 {
+			statements = append(statements, statement)
+			statement = []pythonToken{}
+		}
+	}
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '\\' && strings.HasPrefix(data[i+1:], "\n"):
+			i += 2
+		case c == '\\' && strings.HasPrefix(data[i+1:], "\r\n"):
+			i += 3
+		case c == '\n':
+			if depth == 0 {
+				endStatement()
+			}
+			i++
+		case c == ';' && depth == 0:
+			endStatement()
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case c == '"' || c == '\'':
+			statement = append(statement, pythonToken{`"`, i})
+			i = skipPythonString(data, i)
+		case isPythonIdentByte(c):
+			start := i
+			for i < len(data) && isPythonIdentByte(data[i]) {
+				i++
+			}
+			statement = append(statement, pythonToken{data[start:i], start})
+		default:
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				depth = max(depth-1, 0)
+			}
+			statement = append(statement, pythonToken{string(c), i})
+			i++
+		}
+	}
+	endStatement()
+	return statements
+}
+
+// Reads a dotted name (`a.b.c`, or `..a` if relative ones are allowed) from the tokens at `i`,
+// returning it and the index after it
+func readPythonDottedName(tokens []pythonToken, i int, relative bool) (string, int) {
+	name := ""
+	for relative && i < len(tokens) && tokens[i].value == "." {
+		name += "."
+		i++
+	}
+	// In `from . import x`, the module is only the dots
+	only_dots := name != ""
+	for i < len(tokens) && isPythonIdent(tokens[i].value) && tokens[i].value != "import" &&
+		!(only_dots && tokens[i].value == "cimport") {
+		name += tokens[i].value
+		i++
+		if i+1 < len(tokens) && tokens[i].value == "." && isPythonIdent(tokens[i+1].value) {
+			name += "."
+			i++
+		} else {
+			break
+		}
+	}
+	return name, i
+}
+
+// Reads a comma-separated list of (dotted) names with optional aliases, returning false if the
+// tokens aren't one
+func readPythonImportedNames(tokens []pythonToken, dotted bool) ([]pythonImportedName, bool) {
+	names := []pythonImportedName{}
+	for i := 0; i < len(tokens); {
+		imported := pythonImportedName{}
+		if tokens[i].value == "*" && !dotted {
+			imported.name = "*"
+			i++
+		} else if dotted {
+			imported.name, i = readPythonDottedName(tokens, i, false)
+		} else if isPythonIdent(tokens[i].value) {
+			imported.name = tokens[i].value
+			i++
+		}
+		if imported.name == "" {
+			return nil, false
+		}
+		if i+1 < len(tokens) && tokens[i].value == "as" && isPythonIdent(tokens[i+1].value) {
+			imported.alias = tokens[i+1].value
+			i += 2
+		}
+		names = append(names, imported)
+		if i < len(tokens) {
+			if tokens[i].value != "," {
+				return nil, false
+			}
+			i++
+		}
+	}
+	return names, len(names) != 0
+}
+
+// Parses the import statements of a Python (or Cython) file. Statements that aren't valid imports
+// are skipped.
+func parsePythonImports(data string) []pythonImportStatement {
+	imports := []pythonImportStatement{}
+	for _, tokens := range pythonStatements(data) {
+		statement := pythonImportStatement{offset: tokens[0].offset}
+		var ok bool
+		switch tokens[0].value {
+		case "import", "cimport":
+			statement.names, ok = readPythonImportedNames(tokens[1:], true)
+		case "from":
+			var i int
+			statement.from, i = readPythonDottedName(tokens, 1, true)
+			if statement.from == "" || i == len(tokens) || (tokens[i].value != "import" && tokens[i].value != "cimport") {
+				continue
+			}
+			names := tokens[i+1:]
+			// Parenthesized names may have a trailing comma
+			if len(names) >= 2 && names[0].value == "(" && names[len(names)-1].value == ")" {
+				names = names[1 : len(names)-1]
+				if len(names) != 0 && names[len(names)-1].value == "," {
+					names = names[:len(names)-1]
+				}
+			}
+			statement.names, ok = readPythonImportedNames(names, false)
+		}
+		if ok {
+			imports = append(imports, statement)
+		}
+	}
+	return imports
+}
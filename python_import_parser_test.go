@@ -0,0 +1,107 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Formats an import statement like Python would, e.g. `from a import b as c, d`
+func formatPythonImport(statement pythonImportStatement) string {
+	names := []string{}
+	for _, imported := range statement.names {
+		if imported.alias != "" {
+			names = append(names, imported.name+" as "+imported.alias)
+		} else {
+			names = append(names, imported.name)
+		}
+	}
+	if statement.from != "" {
+		return "from " + statement.from + " import " + strings.Join(names, ", ")
+	}
+	return "import " + strings.Join(names, ", ")
+}
+
+func TestParsePythonImports(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		source   string
+		expected []string
+	}{
+		{"import", "import os\n", []string{"import os"}},
+		{"dotted", "import os.path\n", []string{"import os.path"}},
+		{"several", "import os, sys as system, a.b as c\n", []string{"import os, sys as system, a.b as c"}},
+		{"from", "from a.b import c\n", []string{"from a.b import c"}},
+		{"from_aliases", "from a import b as c, d\n", []string{"from a import b as c, d"}},
+		{"star", "from a import *\n", []string{"from a import *"}},
+		{"relative", "from . import a\nfrom ..b.c import d\n", []string{"from . import a", "from ..b.c import d"}},
+		{"cimport", "from libc.stdlib cimport malloc\ncimport numpy\n", []string{"from libc.stdlib import malloc", "import numpy"}},
+		{"indented", "def f():\n    if x:\n        import a\n", []string{"import a"}},
+		{"no_trailing_newline", "import a", []string{"import a"}},
+		{"crlf", "import a\r\nimport b\r\n", []string{"import a", "import b"}},
+
+		// Multi-line imports
+		{"parenthesized", "from a import (\n    b,\n    c as d,\n)\n", []string{"from a import b, c as d"}},
+		{"parenthesized_single", "from a import (b)\n", []string{"from a import b"}},
+		{"parenthesized_comments", "from a import (  # the names\n    b,  # import x\n    # import y\n    c,\n)\n", []string{"from a import b, c"}},
+		{"backslash", "from a \\\n    import b\nimport c, \\\n    d\n", []string{"from a import b", "import c, d"}},
+		{"backslash_crlf", "import a, \\\r\n    b\r\n", []string{"import a, b"}},
+
+		// `;`-separated statements
+		{"semicolons", "import a; import b\n", []string{"import a", "import b"}},
+		{"semicolon_after_code", "x = 1; from c import d\n", []string{"from c import d"}},
+		{"semicolon_in_string", "x = 'a; import b'\n", []string{}},
+
+		// Comments
+		{"comment_line", "# import a\nimport b\n", []string{"import b"}},
+		{"comment_after_code", "x = 1  # import a\n", []string{}},
+		{"comment_after_import", "import a  # import b\n", []string{"import a"}},
+
+		// Strings containing "import"
+		{"string", "x = \"import a\"\n", []string{}},
+		{"string_statement", "\"import a\"\nimport b\n", []string{"import b"}},
+		{"escaped_quote", "x = \"a \\\" import b\"\nimport c\n", []string{"import c"}},
+		{"docstring", "\"\"\"\nimport a\n\"\"\"\nimport b\n", []string{"import b"}},
+		{"single_quoted_docstring", "'''\nfrom a import b\n'''\nimport c\n", []string{"import c"}},
+		{"triple_quote_with_quotes", "x = \"\"\"a \"quoted\" ''' import b\n\"\"\"\nimport c\n", []string{"import c"}},
+		{"triple_quote_escaped", "x = '''a \\''' import b\n'''\nimport c\n", []string{"import c"}},
+		{"unterminated_string", "x = \"abc\nimport a\n", []string{"import a"}},
+
+		// f-strings
+		{"f_string", "x = f\"import {a}\"\nimport b\n", []string{"import b"}},
+		{"f_string_nested_quotes", "x = f\"{'import a'}\"\nimport b\n", []string{"import b"}},
+		{"f_string_triple", "x = f'''\nimport {a}\n'''\nimport b\n", []string{"import b"}},
+		{"raw_string", "x = r'\\d import a'\nimport b\n", []string{"import b"}},
+
+		// Not imports
+		{"bare_import", "import\n", []string{}},
+		{"from_without_import", "from a\n", []string{}},
+		{"from_without_module", "from import a\n", []string{}},
+		{"import_module_call", "importlib.import_module('a')\n", []string{}},
+		{"attribute", "x.import_a = 1\n", []string{}},
+		{"identifier_prefix", "imports = [a]\nfromage = 1\n", []string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, statement := range parsePythonImports(test.source) {
+				got = append(got, formatPythonImport(statement))
+			}
+			if !slices.Equal(got, test.expected) {
+				t.Errorf("parsePythonImports(%q) = %q, expected %q", test.source, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestParsePythonImportOffsets(t *testing.T) {
+	source := "\"\"\"Docs\"\"\"\n\nimport a\nx = 1; from b import (\n    c,\n)\n"
+	statements := parsePythonImports(source)
+	if len(statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(statements))
+	}
+	for i, expected := range []string{"import a", "from b import ("} {
+		if !strings.HasPrefix(source[statements[i].offset:], expected) {
+			t.Errorf("statement %d is at %q, expected %q", i, source[statements[i].offset:], expected)
+		}
+	}
+}
@@ -1,18 +1,41 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
 )
 
 type PythonModuleResolverResult struct {
+	// The files of the module itself
 	Paths []string
+	// The files of its ancestor packages (their `__init__.py` etc.), which are imported first
+	ParentPaths []string
+	// Its type stubs (`.pyi` files, also in `foo-stubs` packages), separate from `Paths` only with
+	// `python_stub_packages`
+	StubPaths []string
 }
 
 type PythonModuleResolver struct {
 	cache map[string]*PythonModuleResolverResult
+	// For the globs of `python_module_overrides`
+	globs *GlobCache
+	// Package -> name -> the modules its `__init__.py` imports the name from ("*" for star imports)
+	reexports map[string]map[string][]string
+}
+
+// Whether a module is in one of the `root_python_packages`
+func isRootPythonModule(module string, config *Config) bool {
+	for _, root_python_package := range config.RootPythonPackages.items {
+		if strings.HasPrefix(module, root_python_package+".") || module == root_python_package {
+			return true
+		}
+	}
+	return false
 }
 
 func (res *PythonModuleResolver) Resolve(
@@ -22,15 +45,18 @@ func (res *PythonModuleResolver) Resolve(
 		return cached, nil
 	}
 
-	// Filter to specified root modules
-	allowed := false
-	for _, root_python_package := range config.RootPythonPackages.items {
-		if strings.HasPrefix(module, root_python_package+".") || module == root_python_package {
-			allowed = true
-			break
+	// Modules with an explicit location, regardless of `root_python_packages`
+	if pattern, ok := config.PythonModOverrides[module]; ok {
+		out, err := res.resolveOverride(module, pattern, config, base_dir)
+		if err != nil {
+			return nil, err
 		}
+		res.cache[module] = out
+		return out, nil
 	}
-	if !allowed {
+
+	// Filter to specified root modules
+	if !isRootPythonModule(module, config) {
 		res.cache[module] = &PythonModuleResolverResult{}
 		return res.cache[module], nil
 	}
@@ -40,45 +66,78 @@ func (res *PythonModuleResolver) Resolve(
 	}
 
 	paths := []string{}
+	stub_paths := []string{}
 
 	visit_parent := false
-
-	dir_path := strings.ReplaceAll(module, ".", "/")
-	dir_path_init := filepath.Join(dir_path, "__init__.py")
-	py_path := dir_path + ".py"
-	pyx_path := dir_path + ".pyx"
-	pyi_path := dir_path + ".pyi"
-	pxd_path := dir_path + ".pxd"
-	c_path := dir_path + ".c"
-	if _, err := os.Stat(filepath.Join(base_dir, dir_path_init)); err == nil {
-		paths = append(paths, dir_path_init)
-		visit_parent = true
-	}
-	if stat_res, err := os.Stat(filepath.Join(base_dir, dir_path)); err == nil && stat_res.IsDir() {
-		// This is a namespace package, no file to import
-		visit_parent = true
-	}
-	if _, err := os.Stat(filepath.Join(base_dir, py_path)); err == nil {
-		paths = append(paths, py_path)
-		visit_parent = true
-	}
-	if _, err := os.Stat(filepath.Join(base_dir, pyx_path)); err == nil {
-		paths = append(paths, pyx_path)
-		visit_parent = true
-	}
-	if _, err := os.Stat(filepath.Join(base_dir, pyi_path)); err == nil {
-		paths = append(paths, pyi_path)
+	addPath := func(path string) {
+		if config.PythonStubPackages && filepath.Ext(path) == ".pyi" {
+			stub_paths = append(stub_paths, path)
+		} else {
+			paths = append(paths, path)
+		}
 		visit_parent = true
 	}
-	if _, err := os.Stat(filepath.Join(base_dir, pxd_path)); err == nil {
-		paths = append(paths, pxd_path)
-		visit_parent = true
+
+	// A namespace package (PEP 420) may span several source roots, so all of them are searched
+	for _, dir_path := range pythonModulePaths(module, config) {
+		dir_path_init := filepath.Join(dir_path, "__init__.py")
+		py_path := dir_path + ".py"
+		pyx_path := dir_path + ".pyx"
+		pyi_path := dir_path + ".pyi"
+		pxd_path := dir_path + ".pxd"
+		c_path := dir_path + ".c"
+		for _, candidate := range []string{dir_path_init, dir_path_init + "i"} {
+			if path, ok := resolveCandidate(candidate, config, base_dir); ok {
+				addPath(path)
+			}
+		}
+		if dirExists(config, filepath.Join(base_dir, dir_path)) {
+			// This is a namespace package, no file to import
+			visit_parent = true
+		}
+		for _, candidate := range []string{py_path, pyx_path, pyi_path, pxd_path, c_path} {
+			if path, ok := resolveCandidate(candidate, config, base_dir); ok {
+				addPath(path)
+			}
+		}
+		// Compiled extensions, also with a platform tag (`module.cpython-311-x86_64-linux-gnu.so`)
+		for _, pattern := range []string{dir_path + ".so", dir_path + ".*.so", dir_path + ".pyd", dir_path + ".*.pyd"} {
+			matches, err := filepath.Glob(filepath.Join(base_dir, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("error while globbing extension modules '%s': %v", pattern, err)
+			}
+			for _, match := range matches {
+				path, err := filepath.Rel(base_dir, match)
+				if err != nil {
+					return nil, err
+				}
+				paths = append(paths, path)
+				visit_parent = true
+			}
+		}
 	}
-	if _, err := os.Stat(filepath.Join(base_dir, c_path)); err == nil {
-		paths = append(paths, c_path)
-		visit_parent = true
+
+	// Stub-only packages (PEP 561): `foo-stubs/bar.pyi` for `foo.bar`
+	if config.PythonStubPackages {
+		top_level, rest, _ := strings.Cut(module, ".")
+		module_path := strings.ReplaceAll(module, ".", "/")
+		for _, dir_path := range pythonModulePaths(module, config) {
+			stubs_path := filepath.Join(
+				strings.TrimSuffix(dir_path, module_path), top_level+"-stubs", strings.ReplaceAll(rest, ".", "/"),
+			)
+			candidates := []string{filepath.Join(stubs_path, "__init__.pyi")}
+			if rest != "" {
+				candidates = append(candidates, stubs_path+".pyi")
+			}
+			for _, candidate := range candidates {
+				if path, ok := resolveCandidate(candidate, config, base_dir); ok {
+					stub_paths = append(stub_paths, path)
+				}
+			}
+		}
 	}
 
+	parent_paths := []string{}
 	if visit_parent {
 		idx := strings.LastIndex(module, ".")
 		if idx != -1 {
@@ -86,13 +145,199 @@ func (res *PythonModuleResolver) Resolve(
 			if err != nil {
 				return nil, err
 			}
-			paths = append(paths, sub_resolve.Paths...)
+			parent_paths = append(parent_paths, sub_resolve.Paths...)
+			parent_paths = append(parent_paths, sub_resolve.ParentPaths...)
 		}
 	}
 
 	out := &PythonModuleResolverResult{
-		Paths: paths,
+		Paths:       paths,
+		ParentPaths: parent_paths,
+		StubPaths:   stub_paths,
 	}
 	res.cache[module] = out
 	return out, nil
 }
+
+// Resolves a module of `python_module_overrides` to its file, or the files matching its glob
+func (res *PythonModuleResolver) resolveOverride(
+	module string, pattern string, config *Config, base_dir string,
+) (*PythonModuleResolverResult, error) {
+	paths := []string{}
+	if strings.ContainsAny(pattern, "*?[{") {
+		matches, err := res.globs.Glob(".", pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error while globbing override of '%s': %v", module, err)
+		}
+		paths = append(paths, matches...)
+	} else if path, ok := resolveCandidate(pattern, config, base_dir); ok {
+		paths = append(paths, path)
+	}
+
+	parent_paths := []string{}
+	if idx := strings.LastIndex(module, "."); idx != -1 && len(paths) != 0 {
+		sub_resolve, err := res.Resolve(module[:idx], config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+		parent_paths = append(parent_paths, sub_resolve.Paths...)
+		parent_paths = append(parent_paths, sub_resolve.ParentPaths...)
+	}
+	return &PythonModuleResolverResult{Paths: paths, ParentPaths: parent_paths}, nil
+}
+
+// Check if a candidate file exists, either directly or through its canonical path (if it's a
+// generated duplicate that may be absent locally). Generated files are assumed to exist.
+func resolveCandidate(candidate string, config *Config, base_dir string) (string, bool) {
+	canonical := config.CanonicalPath(candidate)
+	if _, _, ok := config.GeneratedSources(canonical); ok {
+		return canonical, true
+	}
+	for _, path := range []string{candidate, canonical} {
+		if pathExists(config, filepath.Join(base_dir, path)) {
+			return canonical, true
+		}
+	}
+	return "", false
+}
+
+// The paths of a module relative to the repo root, without an extension. Modules of packages in
+// `python_source_roots` are under each of their source roots, others directly under the repo root.
+func pythonModulePaths(module string, config *Config) []string {
+	dir_path := strings.ReplaceAll(module, ".", "/")
+	source_roots := []string{""}
+	matched_package := ""
+	for root_package, roots := range config.PythonSourceRoots {
+		if (module == root_package || strings.HasPrefix(module, root_package+".")) && len(root_package) > len(matched_package) {
+			source_roots = roots.items
+			matched_package = root_package
+		}
+	}
+	paths := make([]string, len(source_roots))
+	for i, source_root := range source_roots {
+		paths[i] = filepath.Join(source_root, dir_path)
+	}
+	return paths
+}
+
+func validatePythonModOverrides(config *Config) error {
+	for module, pattern := range config.PythonModOverrides {
+		if module == "" || strings.HasPrefix(module, ".") || strings.HasSuffix(module, ".") {
+			return fmt.Errorf("invalid module name '%s'", module)
+		}
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid pattern '%s' of '%s'", pattern, module)
+		}
+		if filepath.IsAbs(pattern) || strings.HasPrefix(filepath.Clean(pattern), "..") {
+			return fmt.Errorf("pattern '%s' of '%s' must be inside the repo", pattern, module)
+		}
+	}
+	return nil
+}
+
+func validatePythonSourceRoots(config *Config) error {
+	for root_package, source_roots := range config.PythonSourceRoots {
+		if !slices.Contains(config.RootPythonPackages.items, root_package) {
+			return fmt.Errorf("'%s' is not in root_python_packages", root_package)
+		}
+		if len(source_roots.items) == 0 {
+			return fmt.Errorf("'%s' has no source roots", root_package)
+		}
+		for _, source_root := range source_roots.items {
+			if filepath.IsAbs(source_root) || strings.HasPrefix(filepath.Clean(source_root), "..") {
+				return fmt.Errorf("source root '%s' of '%s' must be inside the repo", source_root, root_package)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolves a relative module name (`.sub`, `..other`) imported by the `__init__.py` of a package
+func resolveRelativeModule(pkg string, module string) string {
+	relative := strings.TrimLeft(module, ".")
+	level := len(module) - len(relative)
+	if level == 0 {
+		return module
+	}
+	parts := strings.Split(pkg, ".")
+	if level-1 >= len(parts) {
+		return relative
+	}
+	base := strings.Join(parts[:len(parts)-(level-1)], ".")
+	if relative == "" {
+		return base
+	}
+	return base + "." + relative
+}
+
+// Parses the names a package's `__init__.py` imports from other modules
+func (res *PythonModuleResolver) loadReExports(pkg string, config *Config, base_dir string) (map[string][]string, error) {
+	if res.reexports == nil {
+		res.reexports = map[string]map[string][]string{}
+	}
+	if reexports, ok := res.reexports[pkg]; ok {
+		return reexports, nil
+	}
+	reexports := map[string][]string{}
+	res.reexports[pkg] = reexports
+	// Only regular packages have an `__init__.py`, so it's in one of the source roots
+	init_path := ""
+	for _, dir_path := range pythonModulePaths(pkg, config) {
+		candidate := filepath.Join(dir_path, "__init__.py")
+		if _, _, ok := config.GeneratedSources(config.CanonicalPath(candidate)); !ok && fileExists(config, filepath.Join(base_dir, candidate)) {
+			init_path = candidate
+			break
+		}
+	}
+	if init_path == "" {
+		return reexports, nil
+	}
+	data, err := readRepoFile(config, filepath.Join(base_dir, init_path))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading '%s': %v", init_path, err)
+	}
+	for _, statement := range parsePythonImports(string(data)) {
+		if statement.from == "" {
+			continue
+		}
+		module := resolveRelativeModule(pkg, statement.from)
+		for _, imported := range statement.names {
+			if imported.name == "*" {
+				reexports["*"] = append(reexports["*"], module)
+				continue
+			}
+			name := imported.name
+			if imported.alias != "" {
+				name = imported.alias
+			}
+			// The name is either defined in the module, or is a submodule of it
+			reexports[name] = append(reexports[name], module, module+"."+imported.name)
+		}
+	}
+	return reexports, nil
+}
+
+// Returns the modules that the `__init__.py` of a package re-exports the given names from, one
+// level deep. For `*`, all of the modules it imports from. Names it doesn't import may come from
+// its own star imports.
+func (res *PythonModuleResolver) ReExportedFrom(
+	pkg string, names []string, config *Config, base_dir string,
+) ([]string, error) {
+	reexports, err := res.loadReExports(pkg, config, base_dir)
+	if err != nil {
+		return nil, err
+	}
+	modules := []string{}
+	for _, name := range names {
+		if name == "*" {
+			for _, name_modules := range reexports {
+				modules = append(modules, name_modules...)
+			}
+		} else if name_modules, ok := reexports[name]; ok {
+			modules = append(modules, name_modules...)
+		} else {
+			modules = append(modules, reexports["*"]...)
+		}
+	}
+	return modules, nil
+}
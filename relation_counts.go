@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Returns the relations with the direct dependency and dependent counts of each file, and how the
+// Python imports of each file reached its dependencies. Files that are only dependencies get an
+// entry too.
+func CountRelations(file_relation_map map[string][]string, edge_origins EdgeOrigins) schema.RelationsWithCounts {
+	counted := schema.RelationsWithCounts{}
+	for file, deps := range file_relation_map {
+		node := counted[file]
+		node.Deps = deps
+		node.DepCount = len(deps)
+		for _, dep := range deps {
+			if kinds := pythonImportKinds(edge_origins[Edge{From: file, To: dep}]); len(kinds) != 0 {
+				if node.PythonImports == nil {
+					node.PythonImports = map[string][]string{}
+				}
+				node.PythonImports[dep] = kinds
+			}
+		}
+		counted[file] = node
+		for _, dep := range deps {
+			dep_node := counted[dep]
+			dep_node.DependentCount++
+			counted[dep] = dep_node
+		}
+	}
+	return counted
+}
+
+// The sorted, unique `PYTHON_IMPORT_*` kinds of an edge's origins
+func pythonImportKinds(origins []EdgeOrigin) []string {
+	kinds := []string{}
+	for _, origin := range origins {
+		if origin.PythonImport != "" {
+			kinds = append(kinds, origin.PythonImport)
+		}
+	}
+	slices.Sort(kinds)
+	return slices.Compact(kinds)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// An entry of `resolvers`: a built-in resolver to run, and its options
+type ResolverConfig struct {
+	// The action that enables the resolver in rules, e.g. `visit_c_includes`
+	Action string
+	// The resolver's top-level config keys (e.g. `c_include_dirs`), overriding them
+	Options yaml.Node
+}
+
+// Validates `resolvers`, applies their options to the config, and computes the order the
+// built-in resolvers run in. Without `resolvers`, all of them are active, in their default order.
+func validateResolvers(config *Config) error {
+	if config.Resolvers == nil {
+		config.active_resolvers = make([]*builtinResolver, len(builtinResolvers))
+		for i := range builtinResolvers {
+			config.active_resolvers[i] = &builtinResolvers[i]
+		}
+		return nil
+	}
+
+	config.active_resolvers = []*builtinResolver{}
+	for _, resolver_config := range config.Resolvers {
+		idx := slices.IndexFunc(builtinResolvers, func(builtin builtinResolver) bool {
+			return builtin.action == resolver_config.Action
+		})
+		if idx == -1 {
+			return fmt.Errorf("unknown resolver '%s'", resolver_config.Action)
+		}
+		builtin := &builtinResolvers[idx]
+		if slices.Contains(config.active_resolvers, builtin) {
+			return fmt.Errorf("resolver '%s' is listed twice", resolver_config.Action)
+		}
+		config.active_resolvers = append(config.active_resolvers, builtin)
+
+		options := &resolver_config.Options
+		if options.Kind == 0 {
+			continue
+		}
+		if options.Kind != yaml.MappingNode {
+			return fmt.Errorf("options of resolver '%s' must be a mapping", resolver_config.Action)
+		}
+		for i := 0; i < len(options.Content); i += 2 {
+			if key := options.Content[i].Value; !slices.Contains(builtin.options, key) {
+				return fmt.Errorf("resolver '%s' has no option '%s'", resolver_config.Action, key)
+			}
+		}
+		if err := options.Decode(config); err != nil {
+			return fmt.Errorf("error while decoding options of resolver '%s': %v", resolver_config.Action, err)
+		}
+	}
+
+	// A rule enabling an unlisted resolver would silently lose its edges
+	patterns := make([]string, 0, len(config.PathRules))
+	for pattern := range config.PathRules {
+		patterns = append(patterns, pattern)
+	}
+	slices.Sort(patterns)
+	for _, pattern := range patterns {
+		rule := config.PathRules[pattern]
+		if action, ok := inactiveResolverAction(&rule.Actions, config); ok {
+			return fmt.Errorf("path_rule '%s' enables '%s', which isn't listed in resolvers", pattern, action)
+		}
+		regex_patterns := make([]string, 0, len(rule.RegexRules))
+		for regex_pattern := range rule.RegexRules {
+			regex_patterns = append(regex_patterns, regex_pattern)
+		}
+		slices.Sort(regex_patterns)
+		for _, regex_pattern := range regex_patterns {
+			regex_actions := rule.RegexRules[regex_pattern]
+			if action, ok := inactiveResolverAction(&regex_actions, config); ok {
+				return fmt.Errorf(
+					"regex rule '%s' of path_rule '%s' enables '%s', which isn't listed in resolvers",
+					regex_pattern,
+					pattern,
+					action,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the first action enabling a built-in resolver that isn't active
+func inactiveResolverAction(actions *RuleActions, config *Config) (string, bool) {
+	for i := range builtinResolvers {
+		builtin := &builtinResolvers[i]
+		if builtin.enabled(actions) && !slices.Contains(config.active_resolvers, builtin) {
+			return builtin.action, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// A built-in resolver, which finds the files a visited file refers to by parsing it
+type FileResolver interface {
+	Resolve(file string, file_data string, config *Config, base_dir string) ([]string, error)
+}
+
+type builtinResolver struct {
+	// The action that enables this resolver in a rule
+	action  string
+	enabled func(actions *RuleActions) bool
+	create  func() FileResolver
+	// The top-level config keys the resolver reads, which may be set in its `resolvers` entry
+	options []string
+}
+
+// The built-in resolvers, in the order they run
+var builtinResolvers = []builtinResolver{
+	{
+		action:  "visit_imported_go_packages",
+		enabled: func(actions *RuleActions) bool { return actions.VisitImportedGoPackages },
+		create:  func() FileResolver { return &GoPackageResolver{} },
+	},
+	{
+		action:  "visit_imported_rust_modules",
+		enabled: func(actions *RuleActions) bool { return actions.VisitImportedRustModules },
+		create:  func() FileResolver { return &RustModuleResolver{} },
+	},
+	{
+		action:  "visit_c_includes",
+		enabled: func(actions *RuleActions) bool { return actions.VisitCIncludes },
+		create:  func() FileResolver { return &CIncludeResolver{} },
+		options: []string{"c_include_dirs"},
+	},
+	{
+		action:  "visit_imported_java_classes",
+		enabled: func(actions *RuleActions) bool { return actions.VisitImportedJavaClasses },
+		create:  func() FileResolver { return &JavaImportResolver{} },
+		options: []string{"java_source_roots"},
+	},
+	{
+		action:  "visit_cmake_references",
+		enabled: func(actions *RuleActions) bool { return actions.VisitCMakeReferences },
+		create:  func() FileResolver { return &CMakeResolver{} },
+		options: []string{"cmake_module_dirs"},
+	},
+	{
+		action:  "visit_dockerfile_refs",
+		enabled: func(actions *RuleActions) bool { return actions.VisitDockerfileRefs },
+		create:  func() FileResolver { return &DockerfileResolver{} },
+		options: []string{"docker_build_contexts", "docker_local_images"},
+	},
+	{
+		action:  "visit_dbt_refs",
+		enabled: func(actions *RuleActions) bool { return actions.VisitDbtRefs },
+		create:  func() FileResolver { return &DbtResolver{} },
+		options: []string{"dbt_model_dirs"},
+	},
+	{
+		action:  "visit_jinja_templates",
+		enabled: func(actions *RuleActions) bool { return actions.VisitJinjaTemplates },
+		create:  func() FileResolver { return &JinjaResolver{} },
+		options: []string{"jinja_template_roots"},
+	},
+	{
+		action:  "visit_csproj_references",
+		enabled: func(actions *RuleActions) bool { return actions.VisitCsprojReferences },
+		create:  func() FileResolver { return &CsprojResolver{} },
+	},
+	{
+		action:  "visit_swift_package_targets",
+		enabled: func(actions *RuleActions) bool { return actions.VisitSwiftPackageTargets },
+		create:  func() FileResolver { return &SwiftPackageResolver{} },
+	},
+	{
+		action:  "visit_gradle_projects",
+		enabled: func(actions *RuleActions) bool { return actions.VisitGradleProjects },
+		create:  func() FileResolver { return &GradleResolver{} },
+	},
+	{
+		action:  "visit_markdown_links",
+		enabled: func(actions *RuleActions) bool { return actions.VisitMarkdownLinks },
+		create:  func() FileResolver { return &MarkdownResolver{} },
+	},
+	{
+		action:  "visit_scss_imports",
+		enabled: func(actions *RuleActions) bool { return actions.VisitScssImports },
+		create:  func() FileResolver { return &ScssResolver{} },
+		options: []string{"scss_include_paths"},
+	},
+	{
+		action:  "visit_helm_chart_files",
+		enabled: func(actions *RuleActions) bool { return actions.VisitHelmChartFiles },
+		create:  func() FileResolver { return &HelmResolver{} },
+	},
+	{
+		action:  "visit_kustomize_resources",
+		enabled: func(actions *RuleActions) bool { return actions.VisitKustomizeResources },
+		create:  func() FileResolver { return &KustomizeResolver{} },
+	},
+	{
+		action:  "visit_maven_modules",
+		enabled: func(actions *RuleActions) bool { return actions.VisitMavenModules },
+		create:  func() FileResolver { return &MavenResolver{} },
+	},
+	{
+		action:  "visit_thrift_includes",
+		enabled: func(actions *RuleActions) bool { return actions.VisitThriftIncludes },
+		create:  func() FileResolver { return &ThriftResolver{} },
+		options: []string{"thrift_include_dirs"},
+	},
+	{
+		action:  "visit_nix_imports",
+		enabled: func(actions *RuleActions) bool { return actions.VisitNixImports },
+		create:  func() FileResolver { return &NixResolver{} },
+	},
+	{
+		action:  "visit_locked_packages",
+		enabled: func(actions *RuleActions) bool { return actions.VisitLockedPackages },
+		create:  func() FileResolver { return &LockfileResolver{} },
+		options: []string{"lockfiles", "package_aliases"},
+	},
+	{
+		action:  "visit_graphql_imports",
+		enabled: func(actions *RuleActions) bool { return actions.VisitGraphqlImports },
+		create:  func() FileResolver { return &GraphqlResolver{} },
+	},
+	{
+		action:  "visit_openapi_refs",
+		enabled: func(actions *RuleActions) bool { return actions.VisitOpenapiRefs },
+		create:  func() FileResolver { return &OpenapiResolver{} },
+	},
+	{
+		action:  "visit_ansible_includes",
+		enabled: func(actions *RuleActions) bool { return actions.VisitAnsibleIncludes },
+		create:  func() FileResolver { return &AnsibleResolver{} },
+		options: []string{"ansible_roles_paths"},
+	},
+	{
+		action:  "visit_pyproject_entry_points",
+		enabled: func(actions *RuleActions) bool { return actions.VisitPyprojectEntryPoints },
+		create:  func() FileResolver { return &PyprojectResolver{} },
+	},
+}
+
+// The state of all resolvers during a single run
+type Resolvers struct {
+	python *PythonModuleResolver
+	// Also used by the Python imports, shared with the `visit_locked_packages` action
+	lockfiles *LockfileResolver
+	files     map[string]FileResolver
+	globs     *GlobCache
+}
+
+func NewResolvers(config *Config, base_dir string) *Resolvers {
+	globs := NewGlobCache(config, base_dir)
+	python := &PythonModuleResolver{
+		cache: map[string]*PythonModuleResolverResult{},
+		globs: globs,
+	}
+	lockfiles := &LockfileResolver{}
+	return &Resolvers{
+		python:    python,
+		lockfiles: lockfiles,
+		files: map[string]FileResolver{
+			"visit_locked_packages":        lockfiles,
+			"visit_pyproject_entry_points": &PyprojectResolver{python: python},
+		},
+		globs: globs,
+	}
+}
+
+// Returns the resolver's state, creating it on first use
+func (resolvers *Resolvers) get(builtin *builtinResolver) FileResolver {
+	resolver, ok := resolvers.files[builtin.action]
+	if !ok {
+		resolver = builtin.create()
+		resolvers.files[builtin.action] = resolver
+	}
+	return resolver
+}
+
+// Run all built-in resolvers enabled by the actions
+func (resolvers *Resolvers) resolveAll(
+	actions *RuleActions,
+	file string,
+	file_data **string,
+	file_relations *FileRelations,
+	origin EdgeOrigin,
+	config *Config,
+	base_dir string,
+) error {
+	for _, builtin := range config.active_resolvers {
+		if !builtin.enabled(actions) {
+			continue
+		}
+		err := loadFileData(file_data, file, config, base_dir)
+		if err != nil {
+			return fmt.Errorf("error while reading file: %v", err)
+		}
+		paths, err := resolvers.get(builtin).Resolve(file, **file_data, config, base_dir)
+		if err != nil {
+			return fmt.Errorf("error while running %s: %v", builtin.action, err)
+		}
+		file_relations.Add(origin, paths...)
+	}
+	return nil
+}
+
+// Read the file into `file_data`, unless it was already read
+func loadFileData(file_data **string, file string, config *Config, base_dir string) error {
+	if *file_data != nil {
+		return nil
+	}
+	file_data_bytes, err := readRepoFile(config, filepath.Join(base_dir, file))
+	if err != nil {
+		// Within the `max_unreadable_files` budget, continue as if the file was empty
+		if err := config.addUnreadableFile(file, err); err != nil {
+			return err
+		}
+	}
+	file_data_str := string(file_data_bytes)
+	*file_data = &file_data_str
+	return nil
+}
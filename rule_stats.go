@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sort"
+)
+
+// How much of the graph a rule produced
+type RuleStat struct {
+	Rule  string
+	Edges int
+	// Distinct files the edges point to
+	Files int
+}
+
+// The name of the config rule that created an edge
+func (origin EdgeOrigin) ruleName() string {
+	switch origin.Type {
+	case EDGE_TYPE_GLOBAL:
+		return "global_deps"
+	case EDGE_TYPE_GENERATED:
+		return "generated_files: " + origin.Rule
+	case EDGE_TYPE_FEDERATED:
+		if origin.Rule == "" {
+			return "federated_repos"
+		}
+		return "cross_repo_deps: " + origin.Rule
+	}
+	return origin.Rule
+}
+
+// Count the edges and files produced by each rule. Rules of the config that produced nothing are
+// included too, with zero counts.
+func CalculateRuleStats(edge_origins EdgeOrigins, config *Config) []RuleStat {
+	edges := map[string]int{}
+	files := map[string]map[string]bool{}
+	addRule := func(rule string) {
+		if files[rule] == nil {
+			files[rule] = map[string]bool{}
+		}
+	}
+	if len(config.GlobalDeps.items) != 0 {
+		addRule("global_deps")
+	}
+	for rule_pattern, path_rules := range config.PathRules {
+		addRule(rule_pattern)
+		for regex_rule_pattern := range path_rules.RegexRules {
+			addRule(rule_pattern + " | " + regex_rule_pattern)
+		}
+	}
+	for pattern := range config.GeneratedFiles {
+		addRule("generated_files: " + pattern)
+	}
+	for pattern := range config.CrossRepoDeps {
+		addRule("cross_repo_deps: " + pattern)
+	}
+
+	for edge, origins := range edge_origins {
+		counted := map[string]bool{}
+		for _, origin := range origins {
+			rule := origin.ruleName()
+			if counted[rule] {
+				continue
+			}
+			counted[rule] = true
+			addRule(rule)
+			edges[rule]++
+			files[rule][edge.To] = true
+		}
+	}
+
+	stats := make([]RuleStat, 0, len(files))
+	for rule, rule_files := range files {
+		stats = append(stats, RuleStat{Rule: rule, Edges: edges[rule], Files: len(rule_files)})
+	}
+	return stats
+}
+
+func PrintRuleStats(stats []RuleStat, stats_sort StatsSortVal) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats_sort == STATS_SORT_COUNT && stats[i].Edges != stats[j].Edges {
+			return stats[i].Edges > stats[j].Edges
+		}
+		return stats[i].Rule < stats[j].Rule
+	})
+	log.Println("edges\tfiles\trule")
+	for _, stat := range stats {
+		log.Printf("%d\t%d\t%s", stat.Edges, stat.Files, stat.Rule)
+	}
+}
+
+// Prints the edges created by optional imports (see `mark_optional_imports`), sorted
+func PrintOptionalImports(edge_origins EdgeOrigins) {
+	log.Println("optional imports that resolved:")
+	count := 0
+	for _, edge := range edge_origins.SortedEdges() {
+		if slices.ContainsFunc(edge_origins[edge], func(origin EdgeOrigin) bool { return origin.Optional }) {
+			log.Printf("%s\t%s", edge.From, edge.To)
+			count++
+		}
+	}
+	log.Printf("%d edges from optional imports\n", count)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Records what a run did, for `-out-run-manifest`
+type runRecorder struct {
+	lock        sync.Mutex
+	start       time.Time
+	phase       string
+	phase_start time.Time
+	manifest    schema.RunManifest
+}
+
+var run_recorder = &runRecorder{
+	start: time.Now(),
+	manifest: schema.RunManifest{
+		Inputs:    []string{},
+		Artifacts: []schema.RunArtifact{},
+		Timings:   map[string]float64{},
+	},
+}
+
+// Ends the current phase of the run (if any), and starts timing the next one (if not empty)
+func (recorder *runRecorder) Phase(name string) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	now := time.Now()
+	if recorder.phase != "" {
+		recorder.manifest.Timings[recorder.phase] += now.Sub(recorder.phase_start).Seconds()
+	}
+	recorder.phase = name
+	recorder.phase_start = now
+}
+
+// Records the config of the run
+func (recorder *runRecorder) Config(path string, config_hash [32]byte) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.manifest.Config = path
+	recorder.manifest.ConfigHash = fmt.Sprintf("%x", config_hash)
+}
+
+// Records the input files of the run
+func (recorder *runRecorder) Inputs(input_files []string) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.manifest.Inputs = slices.Clone(input_files)
+	slices.Sort(recorder.manifest.Inputs)
+}
+
+// Records an output file that was written
+func (recorder *runRecorder) Artifact(flag_name string, path string, written *hashingWriter) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.manifest.Artifacts = append(recorder.manifest.Artifacts, schema.RunArtifact{
+		Flag:   flag_name,
+		Path:   path,
+		SHA256: fmt.Sprintf("%x", written.hash.Sum(nil)),
+		Size:   written.size,
+	})
+}
+
+// The output files written so far by the given flags, sorted by path
+func (recorder *runRecorder) ArtifactsOf(flag_names ...string) []schema.RunArtifact {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	artifacts := []schema.RunArtifact{}
+	for _, artifact := range recorder.manifest.Artifacts {
+		if slices.Contains(flag_names, artifact.Flag) {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	slices.SortFunc(artifacts, func(a, b schema.RunArtifact) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return artifacts
+}
+
+// The flags given on the command line, and their values
+func givenFlags() map[string]string {
+	flags := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
+
+// Counts the warnings logged, passing the logs through
+func (recorder *runRecorder) countWarnings(w io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		if bytes.Contains(p, []byte("Warning:")) {
+			recorder.lock.Lock()
+			recorder.manifest.Warnings++
+			recorder.lock.Unlock()
+		}
+		return w.Write(p)
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// Hashes and counts what's written through it
+type hashingWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, hash: sha256.New()}
+}
+
+func (writer *hashingWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	writer.hash.Write(p[:n])
+	writer.size += int64(n)
+	return n, err
+}
+
+// Writes the manifest of the run: its flags, config, inputs, the outputs it wrote (with their
+// hashes), how long each phase took and how many warnings were logged
+func writeRunManifest(args *Args) {
+	run_recorder.Phase("")
+	run_recorder.lock.Lock()
+	manifest := run_recorder.manifest
+	manifest.ToolVersion = VERSION
+	manifest.SchemaVersion = schema.Version
+	manifest.StartedAt = run_recorder.start.UTC().Format(time.RFC3339)
+	manifest.Flags = givenFlags()
+	manifest.Artifacts = slices.Clone(manifest.Artifacts)
+	slices.SortFunc(manifest.Artifacts, func(a, b schema.RunArtifact) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	manifest.Timings["total"] = time.Since(run_recorder.start).Seconds()
+	run_recorder.lock.Unlock()
+
+	progress_log.Println("Writing run manifest to:", args.OutRunManifest)
+	writeJsonOutput(args, "out-run-manifest", args.OutRunManifest, manifest)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var rust_mod_parser = regexp.MustCompile(`(?m:^[ \t]*((?:#\[[^\]]*\]\s*)*)(?:pub(?:\([^)]*\))?\s+)?mod\s+([A-Za-z_][A-Za-z0-9_]*)\s*;)`)
+var rust_path_attr_parser = regexp.MustCompile(`#\[path\s*=\s*"([^"]+)"\]`)
+var rust_use_parser = regexp.MustCompile(`(?m:^[ \t]*(?:pub(?:\([^)]*\))?\s+)?use\s+([^;]+);)`)
+var rust_use_rename_parser = regexp.MustCompile(`\s+as\s+[A-Za-z_][A-Za-z0-9_]*`)
+var rust_extern_crate_parser = regexp.MustCompile(`(?m:^[ \t]*(?:pub(?:\([^)]*\))?\s+)?extern\s+crate\s+([A-Za-z_][A-Za-z0-9_]*))`)
+
+var cargo_section_parser = regexp.MustCompile(`^\[\s*([^\]]+?)\s*\]$`)
+var cargo_key_parser = regexp.MustCompile(`^([A-Za-z0-9_.-]+|"[^"]+")\s*=\s*(.*)$`)
+var cargo_inline_table_parser = regexp.MustCompile(`([A-Za-z0-9_-]+)\s*=\s*("[^"]*"|true|false)`)
+
+// A crate in the repo, described by its `Cargo.toml`
+type rustCrate struct {
+	dir string
+	// The crate's library root (e.g. `src/lib.rs`), empty if it's not a library
+	lib_root string
+	// Path dependencies, by the name they're used by in code
+	deps map[string]string
+}
+
+// Resolves `mod`, `use` and `extern crate` declarations of Rust files to the files of the modules
+// and crates (through `Cargo.toml` path dependencies) they refer to
+type RustModuleResolver struct {
+	// Crate by `Cargo.toml` directory, nil if there is none
+	crates map[string]*rustCrate
+}
+
+func (res *RustModuleResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.crates == nil {
+		res.crates = map[string]*rustCrate{}
+	}
+	crate, err := res.crateOf(filepath.Dir(file), config, base_dir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	module_dir := rustModuleDir(file, crate)
+
+	// `mod foo;` is either `foo.rs` or `foo/mod.rs`, unless it has a `#[path = "..."]` attribute
+	for _, match := range rust_mod_parser.FindAllStringSubmatch(file_data, -1) {
+		if path_attr := rust_path_attr_parser.FindStringSubmatch(match[1]); path_attr != nil {
+			mod_path := filepath.Join(filepath.Dir(file), path_attr[1])
+			if pathExists(config, filepath.Join(base_dir, mod_path)) {
+				paths = append(paths, mod_path)
+			}
+			continue
+		}
+		paths = append(paths, resolveRustModulePath(module_dir, []string{match[2]}, config, base_dir)...)
+	}
+
+	// `use` paths visit every module on the way, and other crates' roots
+	use_paths := []string{}
+	for _, match := range rust_use_parser.FindAllStringSubmatch(file_data, -1) {
+		use_paths = append(use_paths, expandRustUseTree(match[1])...)
+	}
+	for _, match := range rust_extern_crate_parser.FindAllStringSubmatch(file_data, -1) {
+		use_paths = append(use_paths, match[1])
+	}
+	for _, use_path := range use_paths {
+		segments := strings.Split(strings.TrimPrefix(use_path, "::"), "::")
+		switch {
+		case crate == nil:
+			continue
+		case segments[0] == "crate":
+			paths = append(paths, resolveRustModulePath(filepath.Join(crate.dir, "src"), segments[1:], config, base_dir)...)
+		case segments[0] == "self":
+			paths = append(paths, resolveRustModulePath(module_dir, segments[1:], config, base_dir)...)
+		case segments[0] == "super":
+			super_dir := filepath.Dir(module_dir)
+			segments = segments[1:]
+			for len(segments) > 0 && segments[0] == "super" {
+				super_dir = filepath.Dir(super_dir)
+				segments = segments[1:]
+			}
+			paths = append(paths, resolveRustModulePath(super_dir, segments, config, base_dir)...)
+		default:
+			dep_dir, ok := crate.deps[segments[0]]
+			if !ok || strings.HasPrefix(dep_dir, "..") {
+				// Not a path dependency, or outside the repo
+				continue
+			}
+			dep, err := res.crateOf(dep_dir, config, base_dir)
+			if err != nil {
+				return nil, err
+			}
+			if dep == nil || dep.lib_root == "" {
+				continue
+			}
+			paths = append(paths, filepath.Join(dep.dir, "Cargo.toml"), dep.lib_root)
+			paths = append(paths, resolveRustModulePath(filepath.Dir(dep.lib_root), segments[1:], config, base_dir)...)
+		}
+	}
+
+	// Crate roots depend on their manifest
+	if crate != nil && (file == crate.lib_root || module_dir == filepath.Dir(file) && filepath.Base(file) != "mod.rs") {
+		paths = append(paths, filepath.Join(crate.dir, "Cargo.toml"))
+	}
+
+	// A module may `use` its own items through their full path
+	paths = slices.DeleteFunc(paths, func(path string) bool { return path == file })
+	slices.Sort(paths)
+	return slices.Compact(paths), nil
+}
+
+// Returns the directory in which the submodules of a file are located
+func rustModuleDir(file string, crate *rustCrate) string {
+	dir := filepath.Dir(file)
+	name := strings.TrimSuffix(filepath.Base(file), ".rs")
+	if name == "mod" || file == filepath.Join(dir, "lib.rs") || file == filepath.Join(dir, "main.rs") || file == filepath.Join(dir, "build.rs") {
+		return dir
+	}
+	if crate != nil {
+		if crate.lib_root == file {
+			return dir
+		}
+		// Each file in these directories is a crate root
+		for _, roots_dir := range []string{"src/bin", "tests", "examples", "benches"} {
+			if dir == filepath.Join(crate.dir, roots_dir) {
+				return dir
+			}
+		}
+	}
+	return filepath.Join(dir, name)
+}
+
+// Returns the files of each module along a path of module names, until one isn't found
+// (the rest are items inside the last module)
+func resolveRustModulePath(dir string, segments []string, config *Config, base_dir string) []string {
+	paths := []string{}
+	for _, segment := range segments {
+		if segment == "*" || segment == "self" {
+			break
+		}
+		found := false
+		for _, candidate := range []string{filepath.Join(dir, segment+".rs"), filepath.Join(dir, segment, "mod.rs")} {
+			if pathExists(config, filepath.Join(base_dir, candidate)) {
+				paths = append(paths, candidate)
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+		dir = filepath.Join(dir, segment)
+	}
+	return paths
+}
+
+// Expand a `use` tree such as `a::{b, c::{d, e as f}}` into a list of paths
+func expandRustUseTree(tree string) []string {
+	tree = strings.Join(strings.Fields(rust_use_rename_parser.ReplaceAllString(tree, "")), "")
+	brace := strings.IndexByte(tree, '{')
+	if brace == -1 {
+		return []string{tree}
+	}
+	prefix := tree[:brace]
+	inner := strings.TrimSuffix(tree[brace+1:], "}")
+
+	// Split the inner list on top-level commas
+	paths := []string{}
+	depth := 0
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) && inner[i] == '{' {
+			depth++
+		} else if i < len(inner) && inner[i] == '}' {
+			depth--
+		} else if i == len(inner) || (inner[i] == ',' && depth == 0) {
+			if item := inner[start:i]; item != "" {
+				for _, sub_path := range expandRustUseTree(item) {
+					paths = append(paths, prefix+sub_path)
+				}
+			}
+			start = i + 1
+		}
+	}
+	return paths
+}
+
+// Returns the crate containing the directory, by looking for the nearest `Cargo.toml` with a package
+func (res *RustModuleResolver) crateOf(dir string, config *Config, base_dir string) (*rustCrate, error) {
+	dir = filepath.Clean(dir)
+	if crate, ok := res.crates[dir]; ok {
+		return crate, nil
+	}
+	var crate *rustCrate
+	manifest, err := readCargoManifest(dir, base_dir)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil && manifest.sections["package"] != nil {
+		crate, err = res.loadCrate(dir, manifest, config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+	} else if dir != "." && !strings.HasPrefix(dir, "..") {
+		crate, err = res.crateOf(filepath.Dir(dir), config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.crates[dir] = crate
+	return crate, nil
+}
+
+func (res *RustModuleResolver) loadCrate(dir string, manifest *cargoManifest, config *Config, base_dir string) (*rustCrate, error) {
+	crate := &rustCrate{dir: dir, deps: map[string]string{}}
+	lib_root := filepath.Join(dir, "src", "lib.rs")
+	if lib_path, ok := manifest.sections["lib"]["path"]; ok {
+		lib_root = filepath.Join(dir, unquoteToml(lib_path))
+	}
+	if pathExists(config, filepath.Join(base_dir, lib_root)) {
+		crate.lib_root = lib_root
+	}
+
+	var workspace *cargoManifest
+	for section, keys := range manifest.sections {
+		if !isCargoDepsSection(section) {
+			continue
+		}
+		for name, dep := range manifest.deps(section, keys) {
+			if dep["workspace"] == "true" {
+				// Inherited from the workspace's dependencies
+				if workspace == nil {
+					var err error
+					workspace, err = findCargoWorkspace(filepath.Dir(dir), base_dir)
+					if err != nil {
+						return nil, err
+					}
+					if workspace == nil {
+						break
+					}
+				}
+				ws_deps := workspace.deps("workspace.dependencies", workspace.sections["workspace.dependencies"])
+				if ws_path, ok := ws_deps[name]["path"]; ok {
+					crate.deps[strings.ReplaceAll(name, "-", "_")] = filepath.Join(workspace.dir, ws_path)
+				}
+			} else if dep_path, ok := dep["path"]; ok {
+				crate.deps[strings.ReplaceAll(name, "-", "_")] = filepath.Join(dir, dep_path)
+			}
+		}
+	}
+	return crate, nil
+}
+
+func isCargoDepsSection(section string) bool {
+	for _, kind := range []string{"dependencies", "dev-dependencies", "build-dependencies"} {
+		if section == kind || strings.HasPrefix(section, "target.") && strings.HasSuffix(section, "."+kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the nearest ancestor `Cargo.toml` with a `[workspace]` section
+func findCargoWorkspace(dir string, base_dir string) (*cargoManifest, error) {
+	for {
+		manifest, err := readCargoManifest(dir, base_dir)
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil && manifest.sections["workspace"] != nil {
+			return manifest, nil
+		}
+		if dir == "." || strings.HasPrefix(dir, "..") {
+			return nil, nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// The parts of a `Cargo.toml` we care about: keys of each section, and dependencies defined as
+// their own sections (`[dependencies.foo]`)
+type cargoManifest struct {
+	dir      string
+	sections map[string]map[string]string
+}
+
+// Read `dir/Cargo.toml`, returns nil if it doesn't exist
+func readCargoManifest(dir string, base_dir string) (*cargoManifest, error) {
+	data, err := os.ReadFile(filepath.Join(base_dir, dir, "Cargo.toml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	manifest := &cargoManifest{dir: dir, sections: map[string]map[string]string{}}
+	section := ""
+	manifest.sections[section] = map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if match := cargo_section_parser.FindStringSubmatch(line); match != nil {
+			section = match[1]
+			if manifest.sections[section] == nil {
+				manifest.sections[section] = map[string]string{}
+			}
+			// So `[dependencies.foo]` is found even without a `[dependencies]` section
+			if parent, _, ok := strings.Cut(section, "."); ok && manifest.sections[parent] == nil {
+				manifest.sections[parent] = map[string]string{}
+			}
+		} else if match := cargo_key_parser.FindStringSubmatch(line); match != nil {
+			manifest.sections[section][unquoteToml(match[1])] = match[2]
+		}
+	}
+	return manifest, nil
+}
+
+// Returns the dependencies of a section, as a map of their (unquoted) keys, e.g. "path"
+func (manifest *cargoManifest) deps(section string, keys map[string]string) map[string]map[string]string {
+	deps := map[string]map[string]string{}
+	for name, value := range keys {
+		dep := map[string]string{}
+		if strings.HasPrefix(value, "{") {
+			for _, match := range cargo_inline_table_parser.FindAllStringSubmatch(value, -1) {
+				dep[match[1]] = unquoteToml(match[2])
+			}
+		} else if name_prefix, key, ok := strings.Cut(name, "."); ok {
+			// `foo.path = "..."`
+			if deps[name_prefix] == nil {
+				deps[name_prefix] = map[string]string{}
+			}
+			deps[name_prefix][key] = unquoteToml(value)
+			continue
+		}
+		deps[name] = dep
+	}
+	// `[dependencies.foo]`
+	for other_section, other_keys := range manifest.sections {
+		if name, ok := strings.CutPrefix(other_section, section+"."); ok && !strings.Contains(name, ".") {
+			dep := map[string]string{}
+			for key, value := range other_keys {
+				dep[key] = unquoteToml(value)
+			}
+			deps[name] = dep
+		}
+	}
+	return deps
+}
+
+func unquoteToml(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
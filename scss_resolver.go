@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var scss_comment_parser = regexp.MustCompile(`(?s:/\*.*?\*/)|(?m://[^\n]*$)`)
+var scss_import_parser = regexp.MustCompile(`@(?:import|use|forward)\s+([^;{}]*)`)
+var scss_url_parser = regexp.MustCompile(`url\(\s*["']?([^"')\s]+)["']?\s*\)|"([^"]*)"|'([^']*)'`)
+
+// Resolves `@import`, `@use` and `@forward` rules of CSS, SCSS and Sass stylesheets to the files
+// Sass would load: relative to the stylesheet, then in the `scss_include_paths` in order, trying
+// the `_partial` name, `.scss`/`.sass`/`.css` extensions and `_index` files of directories.
+// URLs, built-in modules (`sass:math`) and imports using variables are ignored.
+type ScssResolver struct{}
+
+// Returns the first existing file Sass would load for `name` in `dir`
+func (res *ScssResolver) findStylesheet(dir string, name string, config *Config, base_dir string) (string, bool) {
+	base := filepath.Join(dir, name)
+	candidates := []string{}
+	if ext := filepath.Ext(name); ext == ".scss" || ext == ".sass" || ext == ".css" {
+		candidates = append(candidates, base, filepath.Join(filepath.Dir(base), "_"+filepath.Base(base)))
+	} else {
+		for _, ext := range []string{".scss", ".sass", ".css"} {
+			candidates = append(candidates, base+ext, filepath.Join(filepath.Dir(base), "_"+filepath.Base(base)+ext))
+		}
+		for _, ext := range []string{".scss", ".sass", ".css"} {
+			candidates = append(candidates, filepath.Join(base, "_index"+ext), filepath.Join(base, "index"+ext))
+		}
+	}
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(candidate, "..") && fileExists(config, filepath.Join(base_dir, candidate)) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func (res *ScssResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	paths := []string{}
+	data := scss_comment_parser.ReplaceAllString(file_data, "")
+	for _, match := range scss_import_parser.FindAllStringSubmatch(data, -1) {
+		// Only the URLs, not `as`/`with` clauses or media queries
+		for _, url_match := range scss_url_parser.FindAllStringSubmatch(match[1], -1) {
+			name := url_match[1] + url_match[2] + url_match[3]
+			// Webpack's `~` prefix for packages, which are found in the include paths
+			name, from_package := strings.CutPrefix(name, "~")
+			if name == "" || strings.Contains(name, ":") || strings.HasPrefix(name, "/") || strings.Contains(name, "#{") {
+				continue
+			}
+			search_dirs := config.ScssIncludePaths.items
+			if !from_package {
+				search_dirs = append([]string{filepath.Dir(file)}, search_dirs...)
+			}
+			for _, dir := range search_dirs {
+				if path, ok := res.findStylesheet(dir, name, config, base_dir); ok {
+					paths = append(paths, path)
+					break
+				}
+			}
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Recompute the dependency hashes from a graph snapshot and file hashes only
+func RecomputeDepHashes(snapshot *schema.GraphSnapshot, file_hashes schema.FileHashes) (schema.DepHashes, error) {
+	if snapshot.AlgorithmVersion != ALGORITHM_VERSION && snapshot.AlgorithmVersion != ALGORITHM_VERSION_SHA512_256 {
+		return nil, fmt.Errorf(
+			"snapshot is of hash algorithm version %d, this binary implements versions %d and %d",
+			snapshot.AlgorithmVersion,
+			ALGORITHM_VERSION,
+			ALGORITHM_VERSION_SHA512_256,
+		)
+	}
+	params := DepHashParams{AlgorithmVersion: snapshot.AlgorithmVersion, Salt: snapshot.Salt}
+	var err error
+	params.ConfigHash, err = decodeHexHash(snapshot.ConfigHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config hash: %v", err)
+	}
+	params.ToolFingerprint, err = hex.DecodeString(snapshot.ToolFingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tool fingerprint: %v", err)
+	}
+	params.ExternalInputs, err = externalInputsFromSnapshot(snapshot.ExternalInputs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid external inputs: %v", err)
+	}
+	if snapshot.InterfaceHashes != nil {
+		params.InterfaceHashes = map[string][32]byte{}
+		for file, hex_hash := range snapshot.InterfaceHashes {
+			params.InterfaceHashes[file], err = decodeHexHash(hex_hash)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interface hash of '%s': %v", file, err)
+			}
+		}
+	}
+	decoded_file_hashes := map[string][32]byte{}
+	for file, hex_hash := range file_hashes {
+		decoded_file_hashes[file], err = decodeHexHash(hex_hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file hash of '%s': %v", file, err)
+		}
+	}
+
+	dep_hashes := schema.DepHashes{}
+	for file_name, target := range snapshot.Targets {
+		interface_only := map[string]bool{}
+		for _, dep := range target.InterfaceOnlyDeps {
+			interface_only[dep] = true
+		}
+		dep_hashes[file_name] = CalculateDepHash(file_name, target.Deps, interface_only, decoded_file_hashes, &params)
+	}
+	return dep_hashes, nil
+}
+
+// The `self-check` command: proves the dependency hashes are a pure function of the recorded
+// inputs, by recomputing them without access to the repo and comparing
+func runSelfCheck(command_args []string) {
+	flags := flag.NewFlagSet("self-check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s self-check -graph-snapshot snapshot.json -file-hashes file_hashes.json -dep-hashes dep_hashes.json\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	snapshot_path := flags.String("graph-snapshot", "", "Path of the '-out-graph-snapshot' output")
+	file_hashes_path := flags.String("file-hashes", "", "Path of the '-out-file-hashes' output")
+	dep_hashes_path := flags.String("dep-hashes", "", "Path of the '-out-dep-hashes' output to check")
+	flags.Parse(command_args)
+	if *snapshot_path == "" || *file_hashes_path == "" || *dep_hashes_path == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	snapshot := schema.GraphSnapshot{}
+	if err := readJsonFile(*snapshot_path, &snapshot); err != nil {
+		log.Fatalf("error while reading graph snapshot: %v\n", err)
+	}
+	file_hashes := schema.FileHashes{}
+	if err := readJsonFile(*file_hashes_path, &file_hashes); err != nil {
+		log.Fatalf("error while reading file hashes: %v\n", err)
+	}
+	expected := schema.DepHashes{}
+	if err := readJsonFile(*dep_hashes_path, &expected); err != nil {
+		log.Fatalf("error while reading dependency hashes: %v\n", err)
+	}
+
+	recomputed, err := RecomputeDepHashes(&snapshot, file_hashes)
+	if err != nil {
+		log.Fatalf("error while recomputing dependency hashes: %v\n", err)
+	}
+	mismatches := []string{}
+	for file_name, dep_hash := range expected {
+		if recomputed_hash, ok := recomputed[file_name]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("'%s' isn't in the snapshot", file_name))
+		} else if recomputed_hash != dep_hash {
+			mismatches = append(mismatches, fmt.Sprintf("'%s' recomputed as %s, expected %s", file_name, recomputed_hash, dep_hash))
+		}
+	}
+	for file_name := range recomputed {
+		if _, ok := expected[file_name]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("'%s' is missing from the dependency hashes", file_name))
+		}
+	}
+	sort.Strings(mismatches)
+	for _, mismatch := range mismatches {
+		log.Println("Mismatch:", mismatch)
+	}
+	if len(mismatches) != 0 {
+		log.Fatalf("Self-check failed: %d of %d dependency hashes don't match\n", len(mismatches), len(expected))
+	}
+	log.Printf("Self-check passed: all %d dependency hashes match", len(expected))
+}
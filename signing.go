@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Read a PEM encoded key file, e.g. as generated by `openssl genpkey -algorithm ed25519`
+func readPemKey(path string, block_type string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != block_type {
+		return nil, fmt.Errorf("'%s' isn't a PEM encoded %s", path, strings.ToLower(block_type))
+	}
+	return block.Bytes, nil
+}
+
+// Load an Ed25519 private key (PKCS #8, PEM encoded)
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPemKey(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ed_key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("'%s' isn't an Ed25519 key", path)
+	}
+	return ed_key, nil
+}
+
+// Load an Ed25519 public key (PKIX, PEM encoded)
+func LoadVerifyingKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPemKey(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ed_key, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("'%s' isn't an Ed25519 key", path)
+	}
+	return ed_key, nil
+}
+
+// Sign an output file's contents. The signature is base64 encoded, like `cosign sign-blob` does.
+func SignOutput(key ed25519.PrivateKey, data []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)) + "\n")
+}
+
+// Check a signature made by `SignOutput`
+func VerifyOutputSignature(key ed25519.PublicKey, data []byte, signature []byte) error {
+	raw_signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	if !ed25519.Verify(key, data, raw_signature) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// The `verify-signature` command: checks an output file against its signature, exiting with an
+// error if it doesn't match
+func runVerifySignature(command_args []string) {
+	flags := flag.NewFlagSet("verify-signature", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s verify-signature -public-key key.pem -signature file.sig file\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	public_key := flags.String("public-key", "", "Path of the Ed25519 public key (PEM)")
+	signature_path := flags.String("signature", "", "Path of the signature (default: the file's path with '.sig' appended)")
+	flags.Parse(command_args)
+	if *public_key == "" || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	file := flags.Arg(0)
+	if *signature_path == "" {
+		*signature_path = file + ".sig"
+	}
+
+	key, err := LoadVerifyingKey(*public_key)
+	if err != nil {
+		log.Fatalf("error while loading public key: %v\n", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("error while reading '%s': %v\n", file, err)
+	}
+	signature, err := os.ReadFile(*signature_path)
+	if err != nil {
+		log.Fatalf("error while reading signature: %v\n", err)
+	}
+	if err := VerifyOutputSignature(key, data, signature); err != nil {
+		log.Fatalf("Verification of '%s' failed: %v\n", file, err)
+	}
+	log.Printf("Verified signature of '%s'", file)
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+var swift_target_call_parser = regexp.MustCompile(`\.(target|executableTarget|testTarget|macro|plugin|systemLibrary|binaryTarget)\s*\(`)
+var swift_product_call_parser = regexp.MustCompile(`\.(library|executable|plugin)\s*\(`)
+var swift_package_call_parser = regexp.MustCompile(`\.package\s*\(`)
+var swift_dependency_parser = regexp.MustCompile(`\.(?:product|target|byName)\s*\(|"[^"]*"`)
+var swift_string_parser = regexp.MustCompile(`"([^"]*)"`)
+
+// The parsers of labeled arguments (`label: "value"`, `label: [`), compiled once per label
+var swift_arg_parsers = struct {
+	lock    sync.Mutex
+	parsers map[string]*regexp.Regexp
+}{parsers: map[string]*regexp.Regexp{}}
+
+func swiftArgParser(label string, value_pattern string) *regexp.Regexp {
+	pattern := `\b` + label + `\s*:\s*` + value_pattern
+	swift_arg_parsers.lock.Lock()
+	defer swift_arg_parsers.lock.Unlock()
+	parser, ok := swift_arg_parsers.parsers[pattern]
+	if !ok {
+		parser = regexp.MustCompile(pattern)
+		swift_arg_parsers.parsers[pattern] = parser
+	}
+	return parser
+}
+
+// Resolves Swift source files to the files of their Swift Package Manager target, and of the
+// targets it depends on (`dependencies:` of the target in `Package.swift`), including products of
+// local packages (`.package(path: ...)`). `Package.swift` itself visits the manifests of its local
+// packages. Targets are found in their `path:`, or the SwiftPM default directories.
+type SwiftPackageResolver struct {
+	// Package directory -> its parsed manifest, nil if there's no `Package.swift` there
+	packages map[string]*swiftPackage
+}
+
+type swiftPackage struct {
+	dir     string
+	targets []*swiftTarget
+	// Product name -> names of its targets
+	products map[string][]string
+	// Package identity (lowercase last path component) -> directory, for local packages
+	local_packages map[string]string
+}
+
+type swiftTarget struct {
+	name    string
+	dir     string
+	exclude []string
+	deps    []swiftDependency
+}
+
+type swiftDependency struct {
+	name string
+	// The package of a product, "" for targets of the same package (or products by name)
+	package_name string
+}
+
+// Blank out comments, keeping string literals and offsets, so brackets can be matched
+func stripSwiftComments(data string) string {
+	out := []byte(data)
+	for i := 0; i < len(out); i++ {
+		switch {
+		case out[i] == '"':
+			for i++; i < len(out) && out[i] != '"' && out[i] != '\n'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case strings.HasPrefix(string(out[i:]), "//"):
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case strings.HasPrefix(string(out[i:]), "/*"):
+			for ; i < len(out) && !strings.HasPrefix(string(out[i:]), "*/"); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			if i+1 < len(out) {
+				out[i], out[i+1] = ' ', ' '
+				i++
+			}
+		}
+	}
+	return string(out)
+}
+
+// Returns the index after the bracket closing the one before `start`
+func matchSwiftBracket(data string, start int) int {
+	depth := 1
+	for i := start; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(data)
+}
+
+// Returns the arguments of the top-level calls found by `parser` (calls nested in others aren't returned)
+func swiftCalls(data string, parser *regexp.Regexp) []string {
+	calls := []string{}
+	end := 0
+	for _, match := range parser.FindAllStringSubmatchIndex(data, -1) {
+		if match[0] < end {
+			continue
+		}
+		end = matchSwiftBracket(data, match[1])
+		calls = append(calls, data[match[0]:end])
+	}
+	return calls
+}
+
+// Returns the string value of a `label: "value"` argument
+func swiftStringArg(call string, label string) (string, bool) {
+	match := swiftArgParser(label, `"([^"]*)"`).FindStringSubmatch(call)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// Returns the contents of a `label: [...]` argument
+func swiftArrayArg(call string, label string) string {
+	loc := swiftArgParser(label, `\[`).FindStringIndex(call)
+	if loc == nil {
+		return ""
+	}
+	return call[loc[1]:matchSwiftBracket(call, loc[1])]
+}
+
+// Returns the string literals at the top level of an array's contents
+func swiftStrings(array string) []string {
+	strs := []string{}
+	for _, match := range swift_string_parser.FindAllStringSubmatch(array, -1) {
+		strs = append(strs, match[1])
+	}
+	return strs
+}
+
+func parseSwiftPackage(dir string, file_data string, config *Config, base_dir string) *swiftPackage {
+	data := stripSwiftComments(file_data)
+	pkg := &swiftPackage{dir: dir, products: map[string][]string{}, local_packages: map[string]string{}}
+
+	for _, call := range swiftCalls(data, swift_package_call_parser) {
+		path, ok := swiftStringArg(call, "path")
+		if !ok {
+			continue
+		}
+		package_dir := filepath.Join(dir, path)
+		identity := strings.ToLower(filepath.Base(package_dir))
+		if name, ok := swiftStringArg(call, "name"); ok {
+			pkg.local_packages[strings.ToLower(name)] = package_dir
+		}
+		pkg.local_packages[identity] = package_dir
+	}
+
+	for _, call := range swiftCalls(data, swift_product_call_parser) {
+		if name, ok := swiftStringArg(call, "name"); ok {
+			pkg.products[name] = swiftStrings(swiftArrayArg(call, "targets"))
+		}
+	}
+
+	for _, call := range swiftCalls(data, swift_target_call_parser) {
+		name, ok := swiftStringArg(call, "name")
+		if !ok {
+			continue
+		}
+		target := &swiftTarget{name: name, exclude: swiftStrings(swiftArrayArg(call, "exclude"))}
+		if path, ok := swiftStringArg(call, "path"); ok {
+			target.dir = filepath.Join(dir, path)
+		} else {
+			parent_dirs := []string{"Sources", "Source", "src", "srcs"}
+			if strings.HasPrefix(call, ".testTarget") {
+				parent_dirs = []string{"Tests", "Sources", "Source", "src", "srcs"}
+			} else if strings.HasPrefix(call, ".plugin") {
+				parent_dirs = []string{"Plugins"}
+			}
+			for _, parent_dir := range parent_dirs {
+				candidate := filepath.Join(dir, parent_dir, name)
+				if dirExists(config, filepath.Join(base_dir, candidate)) {
+					target.dir = candidate
+					break
+				}
+			}
+		}
+
+		deps := swiftArrayArg(call, "dependencies")
+		for offset := 0; ; {
+			dep_match := swift_dependency_parser.FindStringIndex(deps[offset:])
+			if dep_match == nil {
+				break
+			}
+			start, end := offset+dep_match[0], offset+dep_match[1]
+			if deps[start] == '"' {
+				target.deps = append(target.deps, swiftDependency{name: deps[start+1 : end-1]})
+				offset = end
+				continue
+			}
+			// Skip the whole call, e.g. its `condition:`
+			offset = matchSwiftBracket(deps, end)
+			dep_name, _ := swiftStringArg(deps[start:offset], "name")
+			package_name, _ := swiftStringArg(deps[start:offset], "package")
+			target.deps = append(target.deps, swiftDependency{name: dep_name, package_name: package_name})
+		}
+		pkg.targets = append(pkg.targets, target)
+	}
+	return pkg
+}
+
+// Returns the package in `dir`, loading it on first use
+func (res *SwiftPackageResolver) loadPackage(dir string, config *Config, base_dir string) (*swiftPackage, error) {
+	if pkg, ok := res.packages[dir]; ok {
+		return pkg, nil
+	}
+	var pkg *swiftPackage
+	manifest := filepath.Join(dir, "Package.swift")
+	if fileExists(config, filepath.Join(base_dir, manifest)) {
+		data, err := readRepoFile(config, filepath.Join(base_dir, manifest))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading '%s': %v", manifest, err)
+		}
+		pkg = parseSwiftPackage(dir, string(data), config, base_dir)
+	}
+	res.packages[dir] = pkg
+	return pkg, nil
+}
+
+// The files of a target, except the excluded ones
+func (target *swiftTarget) files(config *Config, base_dir string) ([]string, error) {
+	if target.dir == "" || strings.HasPrefix(target.dir, "..") {
+		return nil, nil
+	}
+	files, err := doublestar.Glob(
+		config.repoFS(filepath.Join(base_dir, target.dir)),
+		"**",
+		doublestar.WithFilesOnly(),
+		doublestar.WithFailOnIOErrors(),
+	)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	paths := []string{}
+	for _, file := range files {
+		excluded := false
+		for _, exclude := range target.exclude {
+			exclude = filepath.Clean(exclude)
+			if file == exclude || strings.HasPrefix(file, exclude+"/") {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			paths = append(paths, filepath.Join(target.dir, file))
+		}
+	}
+	return paths, nil
+}
+
+// The files of the targets a dependency refers to, and the manifest of their package
+func (res *SwiftPackageResolver) dependencyFiles(
+	pkg *swiftPackage, dep swiftDependency, config *Config, base_dir string,
+) ([]string, error) {
+	dep_pkg := pkg
+	if dep.package_name != "" {
+		package_dir, ok := pkg.local_packages[strings.ToLower(dep.package_name)]
+		if !ok {
+			// A remote package
+			return nil, nil
+		}
+		var err error
+		dep_pkg, err = res.loadPackage(package_dir, config, base_dir)
+		if err != nil || dep_pkg == nil {
+			return nil, err
+		}
+	}
+
+	// A target, or a product made of targets
+	target_names := []string{dep.name}
+	if product_targets, ok := dep_pkg.products[dep.name]; ok && dep.package_name != "" {
+		target_names = product_targets
+	}
+	paths := []string{}
+	if dep_pkg != pkg {
+		paths = append(paths, filepath.Join(dep_pkg.dir, "Package.swift"))
+	}
+	for _, target := range dep_pkg.targets {
+		for _, name := range target_names {
+			if target.name == name {
+				files, err := target.files(config, base_dir)
+				if err != nil {
+					return nil, err
+				}
+				paths = append(paths, files...)
+			}
+		}
+	}
+	return paths, nil
+}
+
+func (res *SwiftPackageResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	if res.packages == nil {
+		res.packages = map[string]*swiftPackage{}
+	}
+
+	// The manifest depends on the manifests of local packages
+	if filepath.Base(file) == "Package.swift" {
+		pkg, err := res.loadPackage(filepath.Dir(file), config, base_dir)
+		if err != nil || pkg == nil {
+			return nil, err
+		}
+		paths := []string{}
+		for _, package_dir := range pkg.local_packages {
+			manifest := filepath.Join(package_dir, "Package.swift")
+			if !strings.HasPrefix(manifest, "..") && fileExists(config, filepath.Join(base_dir, manifest)) {
+				paths = append(paths, manifest)
+			}
+		}
+		return paths, nil
+	}
+
+	// Find the package and target of the file
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		pkg, err := res.loadPackage(dir, config, base_dir)
+		if err != nil {
+			return nil, err
+		}
+		if pkg != nil {
+			var file_target *swiftTarget
+			for _, target := range pkg.targets {
+				if target.dir != "" && strings.HasPrefix(file, target.dir+"/") &&
+					(file_target == nil || len(target.dir) > len(file_target.dir)) {
+					file_target = target
+				}
+			}
+			if file_target == nil {
+				return nil, nil
+			}
+			paths, err := file_target.files(config, base_dir)
+			if err != nil {
+				return nil, fmt.Errorf("error while listing target '%s': %v", file_target.name, err)
+			}
+			paths = append(paths, filepath.Join(dir, "Package.swift"))
+			for _, dep := range file_target.deps {
+				dep_paths, err := res.dependencyFiles(pkg, dep, config, base_dir)
+				if err != nil {
+					return nil, fmt.Errorf("error while resolving dependency '%s' of target '%s': %v", dep.name, file_target.name, err)
+				}
+				paths = append(paths, dep_paths...)
+			}
+			return slices.DeleteFunc(paths, func(path string) bool { return path == file }), nil
+		}
+		if dir == "." {
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+func validateTargetGraph(config *Config) error {
+	for name, sources := range config.TargetGraph {
+		if len(sources.items) == 0 {
+			return fmt.Errorf("target '%s' has no sources", name)
+		}
+		for _, pattern := range sources.items {
+			if !doublestar.ValidatePattern(pattern) {
+				return fmt.Errorf("invalid pattern '%s' in target '%s'", pattern, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the targets of `target_graph` each target depends on: target A depends on target B if
+// the recursive dependencies of A's sources include any of B's sources. Sources are matched
+// against the files in the graph.
+func CalculateTargetGraph(file_relation_map map[string][]string, config *Config) schema.Relations {
+	sources := map[string][]string{}
+	is_source_of := map[string][]string{}
+	for file := range file_relation_map {
+		for name, patterns := range config.TargetGraph {
+			// These patterns were validated when the config was loaded
+			if matches, _ := checkExcludePatterns(patterns.items, file); matches {
+				sources[name] = append(sources[name], file)
+				is_source_of[file] = append(is_source_of[file], name)
+			}
+		}
+	}
+
+	target_graph := schema.Relations{}
+	for name := range config.TargetGraph {
+		deps := map[string]bool{}
+		visited := map[string]bool{}
+		queue := slices.Clone(sources[name])
+		for len(queue) != 0 {
+			file := queue[0]
+			queue = queue[1:]
+			if visited[file] {
+				continue
+			}
+			visited[file] = true
+			for _, target := range is_source_of[file] {
+				if target != name {
+					deps[target] = true
+				}
+			}
+			queue = append(queue, file_relation_map[file]...)
+		}
+		target_graph[name] = []string{}
+		for target := range deps {
+			target_graph[name] = append(target_graph[name], target)
+		}
+		slices.Sort(target_graph[name])
+	}
+	return target_graph
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Files in `terminal_dirs` (e.g. vendored `third_party/`) are resolved and hashed like any other
+// file, but not visited, so their own dependencies don't enter the graph.
+func validateTerminalDirs(config *Config) error {
+	config.terminal_dirs = []string{}
+	for _, dir := range config.TerminalDirs.items {
+		clean := filepath.ToSlash(filepath.Clean(dir))
+		if dir == "" || clean == "." || filepath.IsAbs(dir) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("'%s' must be a directory under base_dir", dir)
+		}
+		config.terminal_dirs = append(config.terminal_dirs, clean)
+	}
+	return nil
+}
+
+// Whether a file is in one of the `terminal_dirs`
+func (config *Config) IsTerminal(file string) bool {
+	for _, dir := range config.terminal_dirs {
+		if strings.HasPrefix(file, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var thrift_include_parser = regexp.MustCompile(`(?m:^[ \t]*(?:include|cpp_include)[ \t]+["']([^"'\n]+)["'])`)
+
+// Resolves `include "..."` statements of Thrift IDL files, relative to the file first, then in the
+// `thrift_include_dirs` in order (like the `-I` flags of the Thrift compiler). `cpp_include`s are
+// followed too when they name a file in the repo. Includes that aren't found are ignored.
+type ThriftResolver struct{}
+
+func (res *ThriftResolver) Resolve(
+	file string, file_data string, config *Config, base_dir string,
+) ([]string, error) {
+	paths := []string{}
+	for _, match := range thrift_include_parser.FindAllStringSubmatch(file_data, -1) {
+		for _, include_dir := range append([]string{filepath.Dir(file)}, config.ThriftIncludeDirs.items...) {
+			candidate := filepath.Join(include_dir, match[1])
+			if !strings.HasPrefix(candidate, "..") && fileExists(config, filepath.Join(base_dir, candidate)) {
+				paths = append(paths, candidate)
+				break
+			}
+		}
+	}
+	return paths, nil
+}
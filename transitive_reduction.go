@@ -0,0 +1,126 @@
+package main
+
+import (
+	"slices"
+	"sort"
+)
+
+// Returns the transitive reduction of the relations: edges implied by a longer path are removed,
+// keeping the same reachability between different files (self-edges are removed too).
+// Import cycles make the reduction ambiguous, so it's done between strongly connected components:
+// edges inside a cycle are all kept, and a single edge is kept between each pair of directly
+// related components (the first one in sorted order).
+func TransitiveReduction(file_relation_map map[string][]string) map[string][]string {
+	files := make([]string, 0, len(file_relation_map))
+	for file := range file_relation_map {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	component_of := stronglyConnectedComponents(files, file_relation_map)
+
+	// The edges between components, and the original edge representing each of them
+	type componentEdge struct {
+		from int
+		to   int
+	}
+	successors := map[int][]int{}
+	representatives := map[componentEdge]Edge{}
+	reduced := map[string][]string{}
+	for _, file := range files {
+		reduced[file] = []string{}
+		for _, dep := range file_relation_map[file] {
+			if dep == file {
+				continue
+			}
+			from, to := component_of[file], component_of[dep]
+			if from == to {
+				reduced[file] = append(reduced[file], dep)
+				continue
+			}
+			component_edge := componentEdge{from, to}
+			if _, ok := representatives[component_edge]; !ok {
+				representatives[component_edge] = Edge{From: file, To: dep}
+				successors[from] = append(successors[from], to)
+			}
+		}
+	}
+
+	// An edge between components is redundant if its target is reachable through another successor
+	reached := map[int]int{}
+	generation := 0
+	var markReachable func(component int)
+	markReachable = func(component int) {
+		for _, successor := range successors[component] {
+			if reached[successor] != generation {
+				reached[successor] = generation
+				markReachable(successor)
+			}
+		}
+	}
+	for from, tos := range successors {
+		generation++
+		for _, to := range tos {
+			markReachable(to)
+		}
+		for _, to := range tos {
+			if reached[to] != generation {
+				edge := representatives[componentEdge{from, to}]
+				reduced[edge.From] = append(reduced[edge.From], edge.To)
+			}
+		}
+	}
+
+	for _, deps := range reduced {
+		slices.Sort(deps)
+	}
+	return reduced
+}
+
+// Returns the strongly connected component of each file (Tarjan's algorithm)
+func stronglyConnectedComponents(files []string, file_relation_map map[string][]string) map[string]int {
+	index := map[string]int{}
+	low_link := map[string]int{}
+	on_stack := map[string]bool{}
+	stack := []string{}
+	component_of := map[string]int{}
+	next_index := 0
+	next_component := 0
+
+	var connect func(file string)
+	connect = func(file string) {
+		index[file] = next_index
+		low_link[file] = next_index
+		next_index++
+		stack = append(stack, file)
+		on_stack[file] = true
+
+		for _, dep := range file_relation_map[file] {
+			if _, visited := index[dep]; !visited {
+				connect(dep)
+				low_link[file] = min(low_link[file], low_link[dep])
+			} else if on_stack[dep] {
+				low_link[file] = min(low_link[file], index[dep])
+			}
+		}
+
+		if low_link[file] == index[file] {
+			for {
+				member := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				on_stack[member] = false
+				component_of[member] = next_component
+				if member == file {
+					break
+				}
+			}
+			next_component++
+		}
+	}
+	for _, file := range files {
+		if _, visited := index[file]; !visited {
+			connect(file)
+		}
+	}
+	return component_of
+}
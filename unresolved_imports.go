@@ -0,0 +1,35 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/wazzaps/repo_dagger/pkg/schema"
+)
+
+// Records an import of `root_python_packages` that didn't resolve to any file
+func (config *Config) addUnresolvedImport(file string, file_data string, pyimport pythonImport) {
+	config.unresolved_imports = append(config.unresolved_imports, schema.UnresolvedImport{
+		File:        file,
+		Line:        strings.Count(file_data[:pyimport.offset], "\n") + 1,
+		Module:      pyimport.module,
+		Conditional: pyimport.conditional,
+		TypeOnly:    pyimport.type_only,
+		Optional:    pyimport.optional,
+	})
+}
+
+// The imports of `root_python_packages` that didn't resolve to any file, sorted by file and line
+func (config *Config) UnresolvedImports() schema.UnresolvedImports {
+	unresolved := slices.Clone(config.unresolved_imports)
+	if unresolved == nil {
+		unresolved = schema.UnresolvedImports{}
+	}
+	slices.SortStableFunc(unresolved, func(a, b schema.UnresolvedImport) int {
+		if c := strings.Compare(a.File, b.File); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return unresolved
+}